@@ -174,6 +174,10 @@ func runService(c *cli.Context) error {
 		select {
 		case sig := <-stopChan:
 			logger.Infow("exit requested, finishing all ingress then shutting down", "signal", sig)
+			if whipsrv != nil {
+				// The service waits for the WHIP sessions to end as well
+				go stopWithDrain(whipsrv, conf.WHIP.DrainTimeout)
+			}
 			svc.Stop(false)
 
 		case sig := <-killChan:
@@ -193,6 +197,19 @@ func runService(c *cli.Context) error {
 	return svc.Run()
 }
 
+// drainer is implemented by the WHIP server, stopped once its sessions end on SIGTERM
+type drainer interface {
+	StopWithDrain(ctx context.Context)
+}
+
+// stopWithDrain stops srv once its sessions end, or once timeout expires if some never do
+func stopWithDrain(srv drainer, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	srv.StopWithDrain(ctx)
+}
+
 func setupHealthHandlers(conf *config.Config, svc *service.Service) error {
 	if conf.HealthPort == 0 {
 		return nil
@@ -201,6 +218,7 @@ func setupHealthHandlers(conf *config.Config, svc *service.Service) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", svc.HealthHandler)
 	mux.HandleFunc("/availability", svc.AvailabilityHandler)
+	mux.HandleFunc("/ready", svc.ReadyHandler)
 
 	go func() {
 		_ = http.ListenAndServe(fmt.Sprintf(":%d", conf.HealthPort), mux)
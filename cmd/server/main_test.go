@@ -0,0 +1,64 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDrainer ends its drain once idle is closed, if ever
+type testDrainer struct {
+	idle chan struct{}
+	err  error
+}
+
+func (d *testDrainer) StopWithDrain(ctx context.Context) {
+	select {
+	case <-d.idle:
+	case <-ctx.Done():
+		d.err = ctx.Err()
+	}
+}
+
+func TestStopWithDrain(t *testing.T) {
+	stop := func(d *testDrainer, timeout time.Duration) {
+		done := make(chan struct{})
+		go func() {
+			stopWithDrain(d, timeout)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("drain not bounded by the drain timeout")
+		}
+	}
+
+	// A publisher never leaving the node
+	d := &testDrainer{idle: make(chan struct{})}
+	stop(d, 50*time.Millisecond)
+	require.ErrorIs(t, d.err, context.DeadlineExceeded)
+
+	// Sessions ending before the timeout
+	d = &testDrainer{idle: make(chan struct{})}
+	close(d.idle)
+	stop(d, time.Hour)
+	require.NoError(t, d.err)
+}
@@ -15,7 +15,14 @@
 package config
 
 import (
+	"crypto/tls"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -33,10 +40,64 @@ const (
 	DefaultRTMPPort      int = 1935
 	DefaultWHIPPort          = 8080
 	DefaultHTTPRelayPort     = 9090
+
+	DefaultWHIPSlowRPCThreshold      = 0.5
+	DefaultWHIPRoomFullRetryAfter    = 5 * time.Second
+	DefaultWHIPUnavailableRetryAfter = 5 * time.Second
+	DefaultWHIPMinTargetLatency      = 20 * time.Millisecond
+	DefaultWHIPMaxTargetLatency      = 2 * time.Second
+	DefaultWHIPStreamKeysPerIPWindow = time.Minute
+	DefaultWHIPMaxSDPFragSize        = 64 << 10
+	DefaultWHIPMaxOfferSize          = 256 << 10
+	DefaultWHIPMaxTrickleCandidates  = 256
+	DefaultWHIPHealthWindow          = time.Minute
+	DefaultWHIPHealthMinNegotiations = 5
+	DefaultWHIPMaxHeaderBytes        = 16 << 10
+	DefaultWHIPMaxHeaderCount        = 100
+	DefaultWHIPSessionTokenTTL       = 24 * time.Hour
+	DefaultWHIPBodyReadTimeout       = 10 * time.Second
+	DefaultWHIPSDPResponseTimeout    = 5 * time.Second
+	DefaultWHIPSessionStartTimeout   = 10 * time.Second
+	DefaultWHIPRPCTimeout            = 5 * time.Second
+	DefaultWHIPHTTPReadTimeout       = 10 * time.Second
+	DefaultWHIPHTTPWriteTimeout      = 10 * time.Second
+	DefaultWHIPWatchdogGracePeriod   = 30 * time.Second
+	DefaultWHIPReaperInterval        = 30 * time.Second
+	DefaultWHIPReaperGracePeriod     = time.Minute
+	DefaultWHIPEndedSessionTTL       = time.Minute
+	DefaultWHIPDrainTimeout          = time.Hour
+	DefaultWHIPSessionWebhookQueue   = 100
+	DefaultWHIPBitrateRampDuration   = 10 * time.Second
+	DefaultWHIPStatsLabelTemplate    = "{app}/{stream_key}/{resource_id}"
+	DefaultWHIPStreamKeyQueryParam   = "token"
+
+	// Upper bounds of the SDP response and session start timeouts, and of their per app overrides
+	MaxWHIPSDPResponseTimeout  = 30 * time.Second
+	MaxWHIPSessionStartTimeout = time.Minute
+
+	SRTPAEADAES256GCM       = "SRTP_AEAD_AES_256_GCM"
+	SRTPAEADAES128GCM       = "SRTP_AEAD_AES_128_GCM"
+	SRTPAES128CMHMACSHA1_80 = "SRTP_AES128_CM_HMAC_SHA1_80"
+
+	WHIPIPFamilyIPv4 = "ipv4"
+	WHIPIPFamilyIPv6 = "ipv6"
+
+	WHIPRecvOnlyMediaIgnore = "ignore"
+	WHIPRecvOnlyMediaReject = "reject"
+
+	WHIPNoICEServersWarn = "warn"
+	WHIPNoICEServersFail = "fail"
+
+	WHIPTLSVersion12 = "1.2"
+	WHIPTLSVersion13 = "1.3"
+
+	// DebugKeyLogAcknowledgement must be copied to the config to enable WHIP key logging
+	DebugKeyLogAcknowledgement = "I understand that all session media can be decrypted"
 )
 
 var (
-	DefaultICEPortRange = []uint16{2000, 4000}
+	DefaultICEPortRange     = []uint16{2000, 4000}
+	DefaultWHIPRTCPFeedback = []string{"goog-remb", "ccm fir", "nack", "nack pli"}
 )
 
 type Config struct {
@@ -58,12 +119,17 @@ type ServiceConfig struct {
 	HTTPRelayPort    int           `yaml:"http_relay_port"`
 	Logging          logger.Config `yaml:"logging"`
 	Development      bool          `yaml:"development"`
+	Region           string        `yaml:"region"`  // Attached to metrics and logs for aggregation across deployments
+	Cluster          string        `yaml:"cluster"` // Attached to metrics and logs for aggregation across deployments
 
 	// Used for WHIP transport
 	RTCConfig rtcconfig.RTCConfig `yaml:"rtc_config"`
 
 	// CPU costs for various ingress types
 	CPUCost CPUCostConfig `yaml:"cpu_cost"`
+
+	// WHIP session behavior
+	WHIP WHIPConfig `yaml:"whip"`
 }
 
 type InternalConfig struct {
@@ -80,6 +146,186 @@ type CPUCostConfig struct {
 	MinIdleRatio                 float64 `yaml:"min_idle_ratio"` // Target idle cpu ratio when deciding availability for new requests
 }
 
+type WHIPConfig struct {
+	ReplayKeyframeOnRelay      bool              `yaml:"replay_keyframe_on_relay"`      // Cache the last video keyframe and replay it to newly associated relays
+	MaxKeyframeInterval        time.Duration     `yaml:"max_keyframe_interval"`         // Time without keyframe after which one is requested with a PLI while a relay is associated, at most once per interval. 0 to disable
+	MinKeyframeInterval        time.Duration     `yaml:"min_keyframe_interval"`         // Keyframe interval below which the publisher is logged as sending keyframes too often. 0 to disable
+	SlowRPCThreshold           float64           `yaml:"slow_rpc_threshold"`            // Fraction of the RPC timeout above which a call is logged as slow
+	FirstMediaTimeout          time.Duration     `yaml:"first_media_timeout"`           // Maximum time between ICE connection and the first media packet. 0 to disable
+	DTLSHandshakeTimeout       time.Duration     `yaml:"dtls_handshake_timeout"`        // Maximum time between ICE connection and the DTLS handshake completion. 0 to disable
+	WHEPURLTemplate            string            `yaml:"whep_url_template"`             // Playback URL returned with new sessions. {app} and {stream_key} are substituted
+	MigrationURLTemplate       string            `yaml:"migration_url_template"`        // URL advertised to clients of the sessions on a draining node. {app} and {stream_key} are substituted
+	DrainTimeout               time.Duration     `yaml:"drain_timeout"`                 // Time allowed for the sessions to end on SIGTERM before the remaining ones are stopped
+	StatsLabelTemplate         string            `yaml:"stats_label_template"`          // Connection label of the session media stats. {app}, {stream_key}, redacted, and {resource_id} are substituted
+	StreamKeyQueryParam        string            `yaml:"stream_key_query_param"`        // Query parameter the stream key is read from when neither the Authorization header nor the path has one
+	Maintenance                bool              `yaml:"maintenance"`                   // Start in maintenance mode, rejecting new sessions with 503. Can be toggled on the debug port at /admin/maintenance
+	ListSessions               bool              `yaml:"list_sessions"`                 // List the sessions of the node, stream keys included, on the debug port at /admin/sessions
+	RTCPFeedback               []string          `yaml:"rtcp_feedback"`                 // Video RTCP feedback types advertised in the answer, e.g. "nack pli"
+	ExtraResponseHeaders       map[string]string `yaml:"extra_response_headers"`        // Static headers added to every WHIP HTTP response
+	MaxHeaderBytes             int               `yaml:"max_header_bytes"`              // Maximum size of the request headers, larger requests get 431
+	MaxHeaderCount             int               `yaml:"max_header_count"`              // Maximum number of request header values, requests with more get 431
+	BodyReadTimeout            time.Duration     `yaml:"body_read_timeout"`             // Time allowed to receive the offer after sending 100 Continue to a request with Expect: 100-continue
+	LogSampleRate              int               `yaml:"log_sample_rate"`               // Log 1 in every N successful requests. Failed requests are always logged. 0 or 1 to log all requests
+	AllowedHosts               []string          `yaml:"allowed_hosts"`                 // Host names sessions can be created for, others get 421. Empty to allow all
+	AllowedOrigins             []string          `yaml:"allowed_origins"`               // Origins allowed to create sessions, others get 403. Empty to allow all
+	AllowedApps                []string          `yaml:"allowed_apps"`                  // {app} URL path elements accepted, others get 404. Empty to allow all
+	RejectMissingOrigin        bool              `yaml:"reject_missing_origin"`         // Reject session creation requests without an Origin header, as sent by native clients, with 403
+	CORSOrigins                []string          `yaml:"cors_origins"`                  // Origins returned in Access-Control-Allow-Origin, when matching the request Origin. Empty for *
+	AllowedAudioSampleRates    []uint32          `yaml:"allowed_audio_sample_rates"`    // Offers with another audio sample rate are rejected. Empty to accept all
+	AllowedAudioChannels       []uint32          `yaml:"allowed_audio_channels"`        // Offers with another audio channel count are rejected. Empty to accept all
+	RecvOnlyMedia              string            `yaml:"recvonly_media"`                // "ignore" or "reject" offered media sections the client does not send on
+	NoICEServers               string            `yaml:"no_ice_servers"`                // "warn" or "fail" at startup without ICE server nor public address advertised, unless lan_only
+	LANOnly                    bool              `yaml:"lan_only"`                      // Clients are on the network of the node, which needs no ICE server nor public address
+	AdvertiseICEServers        bool              `yaml:"advertise_ice_servers"`         // Return the rtc_config ICE servers, with their credentials, in Link headers of the POST and its preflight
+	TLSCertFile                string            `yaml:"tls_cert_file"`                 // PEM certificate chain served over HTTPS, with tls_key_file. Plain HTTP if empty
+	TLSKeyFile                 string            `yaml:"tls_key_file"`                  // PEM private key of tls_cert_file
+	TLSMinVersion              string            `yaml:"tls_min_version"`               // "1.2" or "1.3", the minimum TLS version accepted over HTTPS
+	SRTPProtectionProfiles     []string          `yaml:"srtp_protection_profiles"`      // SRTP protection profiles allowed in the DTLS handshake, in order of preference. Empty for the Pion defaults
+	RejectSSRCCollisions       bool              `yaml:"reject_ssrc_collisions"`        // Reject offers announcing the same SSRC in more than one media section with 400
+	RequireBundle              bool              `yaml:"require_bundle"`                // Reject offers with media sections outside of the a=group:BUNDLE group with 400
+	RequireRTCPMux             bool              `yaml:"require_rtcp_mux"`              // Reject offers with media sections without a=rtcp-mux with 400
+	EnableICERestart           *bool             `yaml:"enable_ice_restart"`            // PATCH requests are rejected with 405 if false
+	AllowSimulcast             *bool             `yaml:"allow_simulcast"`               // Simulcast offers are rejected with 406 if false
+	MaxRenegotiationsPerMinute int               `yaml:"max_renegotiations_per_minute"` // Renegotiations, such as ICE restarts, allowed per session and minute before getting 429. 0 for no limit
+	MaxRenegotiations          int               `yaml:"max_renegotiations"`            // Renegotiations allowed over the lifetime of a session. 0 for no limit
+	MaxRelaysPerSession        int               `yaml:"max_relays_per_session"`        // Relays, one per track, that may be associated with a session at once. 0 for no limit
+	MaxRelays                  int               `yaml:"max_relays"`                    // Relays that may be associated across all sessions of the node at once. 0 for no limit
+	RTCPReducedSize            *bool             `yaml:"rtcp_reduced_size"`             // Accept reduced-size RTCP when offered
+	ExtmapAllowMixed           *bool             `yaml:"extmap_allow_mixed"`            // Accept mixed one-byte and two-byte RTP header extensions when offered
+	AnswerModificationsHeader  bool              `yaml:"answer_modifications_header"`   // List how the answer departs from the offer in the X-Ingress-Modifications response header
+	RoomFullRetryAfter         time.Duration     `yaml:"room_full_retry_after"`         // Retry-After sent to clients rejected because the room or app is full
+	UnavailableRetryAfter      time.Duration     `yaml:"unavailable_retry_after"`       // Retry-After sent to clients rejected because the node is full, out of ports or in maintenance
+	DeleteSummary              bool              `yaml:"delete_summary"`                // Return a JSON session summary to DELETE requests accepting application/json
+	SessionTokenSecret         string            `yaml:"session_token_secret"`          // Secret signing the session tokens required by PATCH, PUT and DELETE requests, the same on all nodes. Empty to disable
+	SessionTokenTTL            time.Duration     `yaml:"session_token_ttl"`             // Validity of the session tokens, renewed by ICE restarts
+	DeleteGracePeriod          time.Duration     `yaml:"delete_grace_period"`           // Delay before closing a session deleted by its client, cut short by a new request for the same stream key. 0 to close immediately
+	AllowReconnect             bool              `yaml:"allow_reconnect"`               // PUT requests with a new offer to a resource URL replace the session of this node, keeping its resource id
+	MaxConcurrentSessions      int               `yaml:"max_concurrent_sessions"`       // Limit of concurrent sessions on the node, all apps included. 0 for no limit
+	MaxSessionsPerApp          int               `yaml:"max_sessions_per_app"`          // Default limit of concurrent sessions for each app. 0 for no limit
+	MaxSessionsPerStreamKey    int               `yaml:"max_sessions_per_stream_key"`   // Limit of concurrent sessions publishing with the same stream key. 0 for no limit
+	MaxSDPFragSize             int64             `yaml:"max_sdpfrag_size"`              // Maximum PATCH body size in bytes
+	MaxOfferSize               int64             `yaml:"max_offer_size"`                // Maximum POST and PUT body size in bytes
+	MaxTrickleCandidates       int               `yaml:"max_trickle_candidates"`        // Maximum number of candidate lines in a PATCH body
+	PreferredVideoCodec        string            `yaml:"preferred_video_codec"`         // Video mime type selected when offered among others, e.g. "video/H264"
+	PreferredAudioCodec        string            `yaml:"preferred_audio_codec"`         // Audio mime type selected when offered among others, e.g. "audio/opus"
+	PeerConnectionPoolSize     int               `yaml:"peer_connection_pool_size"`     // Number of peer connections created ahead of time for each transcoding mode. 0 to disable
+	TargetLatency              time.Duration     `yaml:"target_latency"`                // Default jitter buffer latency target. 0 for the per media kind defaults
+	MinTargetLatency           time.Duration     `yaml:"min_target_latency"`            // Lower bound applied to requested latency targets
+	MaxTargetLatency           time.Duration     `yaml:"max_target_latency"`            // Upper bound applied to requested latency targets
+	InitialBitrate             uint64            `yaml:"initial_bitrate"`               // Cap in bps of the receiver estimates forwarded to the publisher once connected. 0 to disable the ramp-up
+	BitrateRampRate            uint64            `yaml:"bitrate_ramp_rate"`             // Growth of the estimate cap in bps per second
+	BitrateRampDuration        time.Duration     `yaml:"bitrate_ramp_duration"`         // Time after the connection during which the estimates are capped
+	MaxStreamKeysPerIP         int               `yaml:"max_stream_keys_per_ip"`        // Distinct stream keys a source IP may attempt within the window before getting 429. 0 to disable
+	StreamKeysPerIPWindow      time.Duration     `yaml:"stream_keys_per_ip_window"`     // Rolling window for max_stream_keys_per_ip
+	PreferredInterfaces        []string          `yaml:"preferred_interfaces"`          // Network interfaces whose ICE candidates are advertised first, most preferred first
+	IPFamilies                 []string          `yaml:"ip_families"`                   // IP families of the candidates advertised in the answer, most preferred first. Unlisted families are not advertised, empty for all
+	MaxCandidatesPerType       int               `yaml:"max_candidates_per_type"`       // Candidates of each type advertised per media section of the answer, highest priority first. 0 for no limit
+	HealthFailureRateThreshold float64           `yaml:"health_failure_rate_threshold"` // Fraction of failed negotiations in the window above which /ready reports the node as not ready. 0 to disable
+	HealthWindow               time.Duration     `yaml:"health_window"`                 // Rolling window of negotiation outcomes for health_failure_rate_threshold
+	HealthMinNegotiations      int               `yaml:"health_min_negotiations"`       // Negotiations needed in the window before the node can be reported as not ready
+	WatchdogGracePeriod        time.Duration     `yaml:"watchdog_grace_period"`         // Time past its timeout after which a negotiation phase is reported as stuck, with the goroutine stacks at debug level
+	ReaperInterval             time.Duration     `yaml:"reaper_interval"`               // Interval of the scan closing the sessions that never started
+	ReaperGracePeriod          time.Duration     `yaml:"reaper_grace_period"`           // Time past the session start timeout after which a session that never started is closed
+	EndedSessionTTL            time.Duration     `yaml:"ended_session_ttl"`             // Time the state and end reason of an ended session can still be read with GET on its resource
+	SessionWebhookURL          string            `yaml:"session_webhook_url"`           // URL posted a signed event when a session has all its tracks ready and when it ends. Empty to disable
+	SessionWebhookQueueSize    int               `yaml:"session_webhook_queue_size"`    // Events waiting for delivery to session_webhook_url before new ones are dropped
+	DebugKeyLogFile            string            `yaml:"debug_key_log_file"`            // NSS key log file receiving the DTLS secrets of every session. Development only, requires debug_key_log_acknowledgement
+	DebugKeyLogAcknowledgement string            `yaml:"debug_key_log_acknowledgement"` // Must be set to DebugKeyLogAcknowledgement to enable debug_key_log_file
+	FallbackPortRangeStart     uint16            `yaml:"fallback_port_range_start"`     // Ephemeral UDP port range used when the rtc port range is exhausted. 0 to disable
+	FallbackPortRangeEnd       uint16            `yaml:"fallback_port_range_end"`
+
+	FEC      WHIPFECConfig            `yaml:"fec"`
+	Timeouts WHIPTimeouts             `yaml:"timeouts"`
+	Apps     map[string]WHIPAppConfig `yaml:"apps"` // Per app overrides, keyed by the {app} URL path element
+}
+
+// WHIPTimeouts are the node wide timeouts of the WHIP server. Zero values use the defaults
+type WHIPTimeouts struct {
+	SDPResponse  time.Duration `yaml:"sdp_response"`  // Time allowed to answer an offer, can be overridden per app
+	SessionStart time.Duration `yaml:"session_start"` // Time allowed for all tracks to be received after the answer, can be overridden per app
+	RPC          time.Duration `yaml:"rpc"`           // Time allowed to the RPCs forwarding DELETE and PATCH requests to the node of the session
+	HTTPRead     time.Duration `yaml:"http_read"`     // Time allowed to read a whole request, body included
+	HTTPWrite    time.Duration `yaml:"http_write"`    // Time allowed from the end of the request headers to the end of the response
+}
+
+type WHIPAppConfig struct {
+	MaxSessions          int           `yaml:"max_sessions"`           // Overrides max_sessions_per_app if > 0
+	SDPResponseTimeout   time.Duration `yaml:"sdp_response_timeout"`   // Time allowed to answer the offer, overrides the default if > 0
+	SessionStartTimeout  time.Duration `yaml:"session_start_timeout"`  // Time allowed for all tracks to be received after the answer, overrides the default if > 0
+	DTLSHandshakeTimeout time.Duration `yaml:"dtls_handshake_timeout"` // Time allowed for the DTLS handshake after ICE connection, overrides dtls_handshake_timeout if > 0
+
+	SessionWebhookURL       string `yaml:"session_webhook_url"`        // URL the events of the app sessions are posted to, overrides session_webhook_url if set
+	SessionWebhookAPIKey    string `yaml:"session_webhook_api_key"`    // API key the events of the app sessions are signed with, overrides api_key if set
+	SessionWebhookAPISecret string `yaml:"session_webhook_api_secret"` // Secret the events of the app sessions are signed with, overrides api_secret if set
+}
+
+// GetMaxSessions returns the concurrent session limit for the app, 0 if there is none
+func (c *WHIPConfig) GetMaxSessions(app string) int {
+	if appConf, ok := c.Apps[app]; ok && appConf.MaxSessions > 0 {
+		return appConf.MaxSessions
+	}
+	return c.MaxSessionsPerApp
+}
+
+// GetSDPResponseTimeout returns the time allowed to answer the offers to the app
+func (c *WHIPConfig) GetSDPResponseTimeout(app string) time.Duration {
+	if appConf, ok := c.Apps[app]; ok && appConf.SDPResponseTimeout > 0 {
+		return appConf.SDPResponseTimeout
+	}
+	if c.Timeouts.SDPResponse > 0 {
+		return c.Timeouts.SDPResponse
+	}
+	return DefaultWHIPSDPResponseTimeout
+}
+
+// GetSessionStartTimeout returns the time allowed for the tracks of the app sessions to be received
+func (c *WHIPConfig) GetSessionStartTimeout(app string) time.Duration {
+	if appConf, ok := c.Apps[app]; ok && appConf.SessionStartTimeout > 0 {
+		return appConf.SessionStartTimeout
+	}
+	if c.Timeouts.SessionStart > 0 {
+		return c.Timeouts.SessionStart
+	}
+	return DefaultWHIPSessionStartTimeout
+}
+
+// GetMaxOfferSize returns the maximum size in bytes of the SDP offers of session creation requests
+func (c *WHIPConfig) GetMaxOfferSize() int64 {
+	if c.MaxOfferSize > 0 {
+		return c.MaxOfferSize
+	}
+	return DefaultWHIPMaxOfferSize
+}
+
+// GetRPCTimeout returns the time allowed to the RPCs forwarding requests to the node of a session
+func (c *WHIPConfig) GetRPCTimeout() time.Duration {
+	if c.Timeouts.RPC > 0 {
+		return c.Timeouts.RPC
+	}
+	return DefaultWHIPRPCTimeout
+}
+
+// GetTLSMinVersion returns the minimum TLS version accepted when serving over HTTPS
+func (c *WHIPConfig) GetTLSMinVersion() uint16 {
+	if c.TLSMinVersion == WHIPTLSVersion13 {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// GetDTLSHandshakeTimeout returns the time allowed for the DTLS handshake of the app sessions after ICE connection, 0 if unbounded
+func (c *WHIPConfig) GetDTLSHandshakeTimeout(app string) time.Duration {
+	if appConf, ok := c.Apps[app]; ok && appConf.DTLSHandshakeTimeout > 0 {
+		return appConf.DTLSHandshakeTimeout
+	}
+	return c.DTLSHandshakeTimeout
+}
+
+type WHIPFECConfig struct {
+	ULPFEC  bool `yaml:"ulpfec"`  // Negotiate ULPFEC and recover lost video packets from it
+	FlexFEC bool `yaml:"flexfec"` // Negotiate FlexFEC. The repair stream is accepted but not used for recovery
+}
+
 func NewConfig(confString string) (*Config, error) {
 	conf := &Config{
 		ServiceConfig: &ServiceConfig{
@@ -127,6 +373,279 @@ func (c *ServiceConfig) InitWhipConf() error {
 		return nil
 	}
 
+	if c.WHIP.SlowRPCThreshold <= 0 {
+		c.WHIP.SlowRPCThreshold = DefaultWHIPSlowRPCThreshold
+	}
+
+	if len(c.WHIP.RTCPFeedback) == 0 {
+		c.WHIP.RTCPFeedback = DefaultWHIPRTCPFeedback
+	}
+
+	if c.WHIP.EnableICERestart == nil {
+		enableICERestart := true
+		c.WHIP.EnableICERestart = &enableICERestart
+	}
+
+	if c.WHIP.AllowSimulcast == nil {
+		allowSimulcast := true
+		c.WHIP.AllowSimulcast = &allowSimulcast
+	}
+
+	if c.WHIP.RoomFullRetryAfter <= 0 {
+		c.WHIP.RoomFullRetryAfter = DefaultWHIPRoomFullRetryAfter
+	}
+	if c.WHIP.UnavailableRetryAfter <= 0 {
+		c.WHIP.UnavailableRetryAfter = DefaultWHIPUnavailableRetryAfter
+	}
+	if c.WHIP.MinTargetLatency <= 0 {
+		c.WHIP.MinTargetLatency = DefaultWHIPMinTargetLatency
+	}
+	if c.WHIP.MaxTargetLatency <= 0 {
+		c.WHIP.MaxTargetLatency = DefaultWHIPMaxTargetLatency
+	}
+	if c.WHIP.MaxHeaderBytes <= 0 {
+		c.WHIP.MaxHeaderBytes = DefaultWHIPMaxHeaderBytes
+	}
+	if c.WHIP.MaxHeaderCount <= 0 {
+		c.WHIP.MaxHeaderCount = DefaultWHIPMaxHeaderCount
+	}
+	if c.WHIP.SessionTokenTTL <= 0 {
+		c.WHIP.SessionTokenTTL = DefaultWHIPSessionTokenTTL
+	}
+	if c.WHIP.DrainTimeout <= 0 {
+		c.WHIP.DrainTimeout = DefaultWHIPDrainTimeout
+	}
+	if c.WHIP.StatsLabelTemplate == "" {
+		c.WHIP.StatsLabelTemplate = DefaultWHIPStatsLabelTemplate
+	}
+	if c.WHIP.StreamKeyQueryParam == "" {
+		c.WHIP.StreamKeyQueryParam = DefaultWHIPStreamKeyQueryParam
+	}
+	if c.WHIP.BodyReadTimeout <= 0 {
+		c.WHIP.BodyReadTimeout = DefaultWHIPBodyReadTimeout
+	}
+	if c.WHIP.Timeouts.HTTPRead <= 0 {
+		c.WHIP.Timeouts.HTTPRead = DefaultWHIPHTTPReadTimeout
+	}
+	if c.WHIP.Timeouts.HTTPWrite <= 0 {
+		c.WHIP.Timeouts.HTTPWrite = DefaultWHIPHTTPWriteTimeout
+	}
+	if c.WHIP.MaxSDPFragSize <= 0 {
+		c.WHIP.MaxSDPFragSize = DefaultWHIPMaxSDPFragSize
+	}
+	if c.WHIP.MaxTrickleCandidates <= 0 {
+		c.WHIP.MaxTrickleCandidates = DefaultWHIPMaxTrickleCandidates
+	}
+	if c.WHIP.StreamKeysPerIPWindow <= 0 {
+		c.WHIP.StreamKeysPerIPWindow = DefaultWHIPStreamKeysPerIPWindow
+	}
+	if c.WHIP.InitialBitrate > 0 && c.WHIP.BitrateRampDuration <= 0 {
+		c.WHIP.BitrateRampDuration = DefaultWHIPBitrateRampDuration
+	}
+	if c.WHIP.WatchdogGracePeriod <= 0 {
+		c.WHIP.WatchdogGracePeriod = DefaultWHIPWatchdogGracePeriod
+	}
+	if c.WHIP.ReaperInterval <= 0 {
+		c.WHIP.ReaperInterval = DefaultWHIPReaperInterval
+	}
+	if c.WHIP.ReaperGracePeriod <= 0 {
+		c.WHIP.ReaperGracePeriod = DefaultWHIPReaperGracePeriod
+	}
+	if c.WHIP.EndedSessionTTL <= 0 {
+		c.WHIP.EndedSessionTTL = DefaultWHIPEndedSessionTTL
+	}
+	if c.WHIP.SessionWebhookQueueSize <= 0 {
+		c.WHIP.SessionWebhookQueueSize = DefaultWHIPSessionWebhookQueue
+	}
+	if c.WHIP.HealthWindow <= 0 {
+		c.WHIP.HealthWindow = DefaultWHIPHealthWindow
+	}
+	if c.WHIP.HealthMinNegotiations <= 0 {
+		c.WHIP.HealthMinNegotiations = DefaultWHIPHealthMinNegotiations
+	}
+	if c.WHIP.HealthFailureRateThreshold < 0 || c.WHIP.HealthFailureRateThreshold > 1 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip health_failure_rate_threshold must be between 0 and 1")
+	}
+	if c.WHIP.MaxTargetLatency < c.WHIP.MinTargetLatency {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_target_latency must not be lower than min_target_latency")
+	}
+	if c.WHIP.LogSampleRate < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip log_sample_rate must not be negative")
+	}
+
+	switch c.WHIP.RecvOnlyMedia {
+	case "":
+		c.WHIP.RecvOnlyMedia = WHIPRecvOnlyMediaIgnore
+	case WHIPRecvOnlyMediaIgnore, WHIPRecvOnlyMediaReject:
+	default:
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid whip recvonly_media %s", c.WHIP.RecvOnlyMedia)
+	}
+
+	switch c.WHIP.NoICEServers {
+	case "":
+		c.WHIP.NoICEServers = WHIPNoICEServersWarn
+	case WHIPNoICEServersWarn, WHIPNoICEServersFail:
+	default:
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid whip no_ice_servers %s", c.WHIP.NoICEServers)
+	}
+
+	if (c.WHIP.TLSCertFile == "") != (c.WHIP.TLSKeyFile == "") {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip tls_cert_file and tls_key_file must be set together")
+	}
+
+	switch c.WHIP.TLSMinVersion {
+	case "":
+		c.WHIP.TLSMinVersion = WHIPTLSVersion12
+	case WHIPTLSVersion12, WHIPTLSVersion13:
+	default:
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid whip tls_min_version %s", c.WHIP.TLSMinVersion)
+	}
+
+	if c.WHIP.RTCPReducedSize == nil {
+		rtcpReducedSize := true
+		c.WHIP.RTCPReducedSize = &rtcpReducedSize
+	}
+
+	if c.WHIP.PreferredVideoCodec != "" && !slices.ContainsFunc([]string{"video/VP8", "video/H264"}, func(m string) bool { return strings.EqualFold(m, c.WHIP.PreferredVideoCodec) }) {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported whip preferred_video_codec %s", c.WHIP.PreferredVideoCodec)
+	}
+	if c.WHIP.PreferredAudioCodec != "" && !slices.ContainsFunc([]string{"audio/opus", "audio/PCMA"}, func(m string) bool { return strings.EqualFold(m, c.WHIP.PreferredAudioCodec) }) {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported whip preferred_audio_codec %s", c.WHIP.PreferredAudioCodec)
+	}
+
+	if c.WHIP.DebugKeyLogFile != "" {
+		if !c.Development {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip debug_key_log_file is only allowed in development mode")
+		}
+		if c.WHIP.DebugKeyLogAcknowledgement != DebugKeyLogAcknowledgement {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip debug_key_log_file requires debug_key_log_acknowledgement to be %q", DebugKeyLogAcknowledgement)
+		}
+		logger.Warnw("WHIP DTLS key logging enabled, session media can be decrypted from captures. Never use in production", nil, "keyLogFile", c.WHIP.DebugKeyLogFile)
+	}
+
+	if c.WHIP.MaxConcurrentSessions < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_concurrent_sessions must not be negative")
+	}
+	if c.WHIP.MaxSessionsPerStreamKey < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_sessions_per_stream_key must not be negative")
+	}
+	if c.RTCConfig.ICEPortRangeStart != 0 {
+		if c.RTCConfig.ICEPortRangeEnd < c.RTCConfig.ICEPortRangeStart || c.RTCConfig.ICEPortRangeEnd > math.MaxUint16 {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid rtc port range %d-%d", c.RTCConfig.ICEPortRangeStart, c.RTCConfig.ICEPortRangeEnd)
+		}
+		// Each session listens on one port of the range per local address
+		if size := int(c.RTCConfig.ICEPortRangeEnd-c.RTCConfig.ICEPortRangeStart) + 1; c.WHIP.MaxConcurrentSessions > size {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "rtc port range %d-%d is too small for whip max_concurrent_sessions %d", c.RTCConfig.ICEPortRangeStart, c.RTCConfig.ICEPortRangeEnd, c.WHIP.MaxConcurrentSessions)
+		}
+	}
+
+	if c.WHIP.FallbackPortRangeStart != 0 || c.WHIP.FallbackPortRangeEnd != 0 {
+		if c.RTCConfig.ICEPortRangeStart == 0 {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip fallback port range requires rtc port_range_start and port_range_end")
+		}
+		if c.WHIP.FallbackPortRangeStart == 0 || c.WHIP.FallbackPortRangeEnd < c.WHIP.FallbackPortRangeStart {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid whip fallback port range %d-%d", c.WHIP.FallbackPortRangeStart, c.WHIP.FallbackPortRangeEnd)
+		}
+	}
+
+	if c.WHIP.DTLSHandshakeTimeout < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip dtls_handshake_timeout must not be negative")
+	}
+	if t := c.WHIP.Timeouts; t.SDPResponse < 0 || t.SessionStart < 0 || t.RPC < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip timeouts must not be negative")
+	}
+	if c.WHIP.Timeouts.SDPResponse > MaxWHIPSDPResponseTimeout {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip timeouts sdp_response must not exceed %s", MaxWHIPSDPResponseTimeout)
+	}
+	if c.WHIP.Timeouts.SessionStart > MaxWHIPSessionStartTimeout {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip timeouts session_start must not exceed %s", MaxWHIPSessionStartTimeout)
+	}
+	for app, appConf := range c.WHIP.Apps {
+		if appConf.SDPResponseTimeout > MaxWHIPSDPResponseTimeout {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip app %s sdp_response_timeout must not exceed %s", app, MaxWHIPSDPResponseTimeout)
+		}
+		if appConf.SessionStartTimeout > MaxWHIPSessionStartTimeout {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip app %s session_start_timeout must not exceed %s", app, MaxWHIPSessionStartTimeout)
+		}
+		if appConf.SessionWebhookURL != "" {
+			u, err := url.Parse(appConf.SessionWebhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return psrpc.NewErrorf(psrpc.InvalidArgument, "whip app %s session_webhook_url must be an http or https URL", app)
+			}
+		}
+		if (appConf.SessionWebhookAPIKey == "") != (appConf.SessionWebhookAPISecret == "") {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whip app %s session_webhook_api_key and session_webhook_api_secret must be set together", app)
+		}
+	}
+
+	for _, name := range c.WHIP.SRTPProtectionProfiles {
+		if !slices.Contains([]string{SRTPAEADAES256GCM, SRTPAEADAES128GCM, SRTPAES128CMHMACSHA1_80}, name) {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported whip srtp_protection_profiles entry %s", name)
+		}
+	}
+
+	if c.WHIP.IPFamilies != nil && len(c.WHIP.IPFamilies) == 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip ip_families must enable at least one family")
+	}
+	if c.WHIP.MaxKeyframeInterval < 0 || c.WHIP.MinKeyframeInterval < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_keyframe_interval and min_keyframe_interval must not be negative")
+	}
+	if c.WHIP.MaxKeyframeInterval > 0 && c.WHIP.MinKeyframeInterval >= c.WHIP.MaxKeyframeInterval {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip min_keyframe_interval must be lower than max_keyframe_interval")
+	}
+
+	if c.WHIP.SessionTokenSecret != "" && len(c.WHIP.SessionTokenSecret) < 32 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip session_token_secret must be at least 32 characters")
+	}
+
+	if c.WHIP.DeleteGracePeriod < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip delete_grace_period must not be negative")
+	}
+
+	if c.WHIP.MaxRelaysPerSession < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_relays_per_session must not be negative")
+	}
+	if c.WHIP.MaxRelays < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_relays must not be negative")
+	}
+	if c.WHIP.MaxCandidatesPerType < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "whip max_candidates_per_type must not be negative")
+	}
+
+	for i, family := range c.WHIP.IPFamilies {
+		if family != WHIPIPFamilyIPv4 && family != WHIPIPFamilyIPv6 {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported whip ip_families entry %s", family)
+		}
+		if slices.Contains(c.WHIP.IPFamilies[:i], family) {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "duplicate whip ip_families entry %s", family)
+		}
+	}
+
+	for _, name := range c.WHIP.PreferredInterfaces {
+		if _, err := net.InterfaceByName(name); err != nil {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "unknown whip preferred_interfaces entry %s: %v", name, err)
+		}
+	}
+
+	if c.WHIP.WHEPURLTemplate != "" {
+		u, err := url.Parse(c.WHIP.WHEPURLTemplate)
+		if err != nil || !u.IsAbs() {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "whep_url_template must be an absolute URL")
+		}
+	}
+	if c.WHIP.MigrationURLTemplate != "" {
+		u, err := url.Parse(c.WHIP.MigrationURLTemplate)
+		if err != nil || !u.IsAbs() {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "migration_url_template must be an absolute URL")
+		}
+	}
+	if c.WHIP.SessionWebhookURL != "" {
+		u, err := url.Parse(c.WHIP.SessionWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "session_webhook_url must be an http or https URL")
+		}
+	}
+
 	if c.RTCConfig.UDPPort.Start == 0 && c.RTCConfig.ICEPortRangeStart == 0 {
 		c.RTCConfig.UDPPort.Start = 7885
 	}
@@ -171,7 +690,15 @@ func (c *Config) InitLogger(values ...interface{}) error {
 
 // To use with zap logger
 func (c *Config) getLoggerValues() []interface{} {
-	return []interface{}{"nodeID", c.NodeID}
+	values := []interface{}{"nodeID", c.NodeID}
+	if c.Region != "" {
+		values = append(values, "region", c.Region)
+	}
+	if c.Cluster != "" {
+		values = append(values, "cluster", c.Cluster)
+	}
+
+	return values
 }
 
 // To use with logrus
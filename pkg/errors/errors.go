@@ -38,19 +38,131 @@ var (
 	ErrUnableToAddPad               = psrpc.NewErrorf(psrpc.Internal, "could not add pads to bin")
 	ErrMissingResourceId            = psrpc.NewErrorf(psrpc.InvalidArgument, "missing resource ID")
 	ErrInvalidRelayToken            = psrpc.NewErrorf(psrpc.PermissionDenied, "invalid token")
+	ErrOriginNotAllowed             = psrpc.NewErrorf(psrpc.PermissionDenied, "origin not allowed")
+	ErrHostNotAllowed               = psrpc.NewErrorf(psrpc.InvalidArgument, "host not served by this endpoint")
 	ErrIngressNotFound              = psrpc.NewErrorf(psrpc.NotFound, "ingress not found")
+	ErrMissingPublishParams         = psrpc.NewErrorf(psrpc.Internal, "no parameters for the session")
 	ErrServerCapacityExceeded       = psrpc.NewErrorf(psrpc.ResourceExhausted, "server capacity exceeded")
 	ErrServerShuttingDown           = psrpc.NewErrorf(psrpc.Unavailable, "server shutting down")
 	ErrIngressClosing               = psrpc.NewErrorf(psrpc.Unavailable, "ingress closing")
+	ErrReconnectTranscoding         = psrpc.NewErrorf(psrpc.Unimplemented, "reconnection not supported for transcoded sessions")
+	ErrReconnectNotStarted          = psrpc.NewErrorf(psrpc.Unavailable, "session being reconnected has not started")
 	ErrMissingStreamKey             = psrpc.NewErrorf(psrpc.InvalidArgument, "missing stream key")
 	ErrPrerollBufferReset           = psrpc.NewErrorf(psrpc.Internal, "preroll buffer reset")
 	ErrInvalidSimulcast             = psrpc.NewErrorf(psrpc.NotAcceptable, "invalid simulcast configuration")
 	ErrSimulcastTranscode           = psrpc.NewErrorf(psrpc.NotAcceptable, "simulcast is not supported when transcoding")
+	ErrSimulcastNotAllowed          = psrpc.NewErrorf(psrpc.NotAcceptable, "simulcast is not allowed")
 	ErrRoomDisconnected             = psrpc.NewErrorf(psrpc.NotAcceptable, "room disonnected")
+	ErrETagMismatch                 = psrpc.NewErrorf(psrpc.FailedPrecondition, "If-Match does not match the current ICE session of the resource")
 	ErrInvalidWHIPRestartRequest    = psrpc.NewErrorf(psrpc.InvalidArgument, "whip restart request was invalid")
+	ErrRoomFull                     = psrpc.NewErrorf(psrpc.ResourceExhausted, "room is full")
+	ErrUnsupportedAudioFormat       = psrpc.NewErrorf(psrpc.NotAcceptable, "unsupported audio sample rate or channel count")
+	ErrNoMediaReceived              = psrpc.NewErrorf(psrpc.DeadlineExceeded, "no media received after connection was established")
+	ErrInvalidWHIPOffer             = psrpc.NewErrorf(psrpc.InvalidArgument, "invalid WHIP offer")
+	ErrInvalidTargetLatency         = psrpc.NewErrorf(psrpc.InvalidArgument, "invalid target latency")
+	ErrTooManyStreamKeys            = psrpc.NewErrorf(psrpc.ResourceExhausted, "too many stream keys attempted")
+	ErrTooManyStreams               = psrpc.NewErrorf(psrpc.ResourceExhausted, "too many sessions for this stream key")
+	ErrAppSessionLimitReached       = psrpc.NewErrorf(psrpc.Unavailable, "app session limit reached")
+	ErrSessionLimitReached          = psrpc.NewErrorf(psrpc.Unavailable, "node session limit reached")
+	ErrSDPFragTooLarge              = psrpc.NewErrorf(psrpc.InvalidArgument, "sdpfrag body or candidate count over limit")
+	ErrOfferTooLarge                = psrpc.NewErrorf(psrpc.InvalidArgument, "offer body over size limit")
+	ErrAppNotAllowed                = psrpc.NewErrorf(psrpc.NotFound, "unknown app")
+	ErrNoAvailablePorts             = psrpc.NewErrorf(psrpc.Unavailable, "no available port in the ICE port range")
+	ErrNoICEServers                 = psrpc.NewErrorf(psrpc.FailedPrecondition, "no ICE server configured and no public address advertised")
+	ErrMaintenance                  = psrpc.NewErrorf(psrpc.Unavailable, "server in maintenance, not accepting new sessions")
+	ErrTooManyRelays                = psrpc.NewErrorf(psrpc.ResourceExhausted, "too many relays associated with the session")
+	ErrRelayLimitReached            = psrpc.NewErrorf(psrpc.ResourceExhausted, "node relay limit reached")
+	ErrTooManyRenegotiations        = psrpc.NewErrorf(psrpc.ResourceExhausted, "too many renegotiations for the session")
+	ErrSSRCCollision                = psrpc.NewErrorf(psrpc.InvalidArgument, "SSRC announced in more than one media section")
+	ErrContentLengthMismatch        = psrpc.NewErrorf(psrpc.InvalidArgument, "request body length does not match Content-Length")
+	ErrBundleRequired               = psrpc.NewErrorf(psrpc.InvalidArgument, "offer must BUNDLE all media sections on a single transport")
+	ErrRTCPMuxRequired              = psrpc.NewErrorf(psrpc.InvalidArgument, "offer must multiplex RTP and RTCP with a=rtcp-mux in all media sections")
+	ErrDTLSTimeout                  = psrpc.NewErrorf(psrpc.DeadlineExceeded, "DTLS handshake not completed in time after ICE connection")
+	ErrSRTPProfileNotAllowed        = psrpc.NewErrorf(psrpc.FailedPrecondition, "DTLS handshake failed, no allowed SRTP protection profile negotiated")
+	ErrInsecureTransport            = psrpc.NewErrorf(psrpc.InvalidArgument, "offer must use DTLS-SRTP (UDP/TLS/RTP/SAVPF) with a DTLS fingerprint")
+	ErrInvalidSessionToken          = psrpc.NewErrorf(psrpc.Unauthenticated, "missing, invalid or expired session token")
+	ErrICEGatheringTimeout          = psrpc.NewErrorf(psrpc.DeadlineExceeded, "timed out while waiting for ICE candidate gathering")
+	ErrRequestBodyRead              = psrpc.NewErrorf(psrpc.InvalidArgument, "failed reading request body")
+	ErrClientDisconnected           = psrpc.NewErrorf(psrpc.Canceled, "client disconnected during negotiation")
 	ErrRoomDisconnectedUnexpectedly = RetryableError{psrpc.NewErrorf(psrpc.Unavailable, "room disonnected unexpectedly")}
 )
 
+// whipErrorCodes are the stable codes reported to WHIP clients, checked in order so that
+// errors wrapping others, e.g. with ErrInvalidWHIPOfferReason, get the most specific code
+var whipErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidWHIPOffer, "invalid_offer"},
+	{ErrUnsupportedDecodeFormat, "unsupported_codec"},
+	{ErrUnsupportedAudioFormat, "unsupported_audio_format"},
+	{ErrDuplicateTrack, "too_many_tracks"},
+	{ErrInvalidSimulcast, "invalid_simulcast"},
+	{ErrSimulcastTranscode, "simulcast_transcode"},
+	{ErrSimulcastNotAllowed, "simulcast_not_allowed"},
+	{ErrSSRCCollision, "ssrc_collision"},
+	{ErrBundleRequired, "bundle_required"},
+	{ErrRTCPMuxRequired, "rtcp_mux_required"},
+	{ErrInsecureTransport, "insecure_transport"},
+	{ErrContentLengthMismatch, "content_length_mismatch"},
+	{ErrRequestBodyRead, "body_read_failed"},
+	{ErrInvalidTargetLatency, "invalid_target_latency"},
+	{ErrInvalidWHIPRestartRequest, "invalid_restart_request"},
+	{ErrETagMismatch, "etag_mismatch"},
+	{ErrSDPFragTooLarge, "sdpfrag_too_large"},
+	{ErrOfferTooLarge, "offer_too_large"},
+	{ErrAppNotAllowed, "app_not_allowed"},
+	{ErrMissingStreamKey, "missing_stream_key"},
+	{ErrHostNotAllowed, "host_not_allowed"},
+	{ErrOriginNotAllowed, "origin_not_allowed"},
+	{ErrInvalidRelayToken, "invalid_relay_token"},
+	{ErrInvalidSessionToken, "invalid_session_token"},
+	{ErrIngressNotFound, "ingress_not_found"},
+	{ErrRoomFull, "room_full"},
+	{ErrServerCapacityExceeded, "server_capacity_exceeded"},
+	{ErrAppSessionLimitReached, "app_session_limit_reached"},
+	{ErrTooManyStreams, "stream_key_session_limit_reached"},
+	{ErrSessionLimitReached, "node_session_limit_reached"},
+	{ErrTooManyStreamKeys, "stream_key_rate_limited"},
+	{ErrTooManyRenegotiations, "renegotiation_rate_limited"},
+	{ErrTooManyRelays, "too_many_relays"},
+	{ErrRelayLimitReached, "node_relay_limit_reached"},
+	{ErrNoAvailablePorts, "no_available_ports"},
+	{ErrMaintenance, "maintenance"},
+	{ErrServerShuttingDown, "shutting_down"},
+	{ErrIngressClosing, "ingress_closing"},
+	{ErrReconnectTranscoding, "reconnect_not_supported"},
+	{ErrReconnectNotStarted, "reconnect_not_started"},
+	{ErrICEGatheringTimeout, "ice_gathering_timeout"},
+	{ErrClientDisconnected, "client_disconnected"},
+	{ErrSourceNotReady, "source_not_ready"},
+	{ErrNoMediaReceived, "no_media_received"},
+	{ErrDTLSTimeout, "dtls_timeout"},
+	{ErrSRTPProfileNotAllowed, "srtp_profile_not_allowed"},
+	{ErrMissingPublishParams, "missing_publish_params"},
+}
+
+// WHIPErrorCode returns the stable code of a WHIP request failure. Errors without a dedicated code,
+// such as the ones returned by another node over RPC, get their psrpc code, e.g. "not_found".
+func WHIPErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	for _, c := range whipErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+
+	var psrpcErr psrpc.Error
+	if errors.As(err, &psrpcErr) {
+		return string(psrpcErr.Code())
+	}
+
+	return string(psrpc.Internal)
+}
+
 type RetryableError struct {
 	psrpcErr psrpc.Error
 }
@@ -101,10 +213,18 @@ func ErrHttpRelayFailure(statusCode int) psrpc.Error {
 	return psrpc.NewErrorf(psrpc.Internal, "HTTP request failed with code %d", statusCode)
 }
 
+func ErrRelayedSessionFailed(reason string) psrpc.Error {
+	return psrpc.NewErrorf(psrpc.Internal, "WHIP session failed: %s", reason)
+}
+
 func ErrUnsupportedDecodeMimeType(mimeType string) psrpc.Error {
 	return psrpc.NewErrorf(psrpc.NotAcceptable, "unsupported mime type (%s) for the source media", mimeType)
 }
 
+func ErrInvalidWHIPOfferReason(reason string) psrpc.Error {
+	return psrpc.NewErrorf(psrpc.InvalidArgument, "%w: %s", ErrInvalidWHIPOffer, reason)
+}
+
 func ErrorToGstFlowReturn(err error) gst.FlowReturn {
 	switch {
 	case err == nil:
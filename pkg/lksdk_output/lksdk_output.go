@@ -190,9 +190,10 @@ func NewLKSDKOutput(ctx context.Context, onDisconnected func(), p *params.Params
 	return s, nil
 }
 
-func (s *LKSDKOutput) AddAudioTrack(mimeType string, disableDTX bool, stereo bool) (*lksdk.LocalTrack, error) {
+// AddAudioTrack publishes the audio track. defaultName is used if the ingress does not set a track name
+func (s *LKSDKOutput) AddAudioTrack(mimeType string, disableDTX bool, stereo bool, defaultName string) (*lksdk.LocalTrack, error) {
 	opts := &lksdk.TrackPublicationOptions{
-		Name:       s.params.Audio.Name,
+		Name:       getTrackName(s.params.Audio.Name, defaultName),
 		Source:     s.params.Audio.Source,
 		DisableDTX: disableDTX,
 		Stereo:     stereo,
@@ -225,9 +226,10 @@ func (s *LKSDKOutput) AddAudioTrack(mimeType string, disableDTX bool, stereo boo
 	return track, nil
 }
 
-func (s *LKSDKOutput) AddVideoTrack(layers []*livekit.VideoLayer, mimeType string) ([]*lksdk.LocalTrack, []*RTCPHandler, error) {
+// AddVideoTrack publishes the video track layers. defaultName is used if the ingress does not set a track name
+func (s *LKSDKOutput) AddVideoTrack(layers []*livekit.VideoLayer, mimeType string, defaultName string) ([]*lksdk.LocalTrack, []*RTCPHandler, error) {
 	opts := &lksdk.TrackPublicationOptions{
-		Name:        s.params.Video.Name,
+		Name:        getTrackName(s.params.Video.Name, defaultName),
 		Source:      s.params.Video.Source,
 		VideoWidth:  int(layers[0].Width),
 		VideoHeight: int(layers[0].Height),
@@ -343,3 +345,10 @@ func (s *LKSDKOutput) Close() error {
 
 	return err
 }
+
+func getTrackName(name string, defaultName string) string {
+	if name != "" {
+		return name
+	}
+	return defaultName
+}
@@ -122,7 +122,7 @@ func (s *WebRTCSink) addAudioTrack() (*Output, error) {
 
 		if sdkOut != nil {
 			var track *lksdk.LocalTrack
-			track, err = sdkOut.AddAudioTrack(putils.GetMimeTypeForAudioCodec(s.params.AudioEncodingOptions.AudioCodec), s.params.AudioEncodingOptions.DisableDtx, s.params.AudioEncodingOptions.Channels > 1)
+			track, err = sdkOut.AddAudioTrack(putils.GetMimeTypeForAudioCodec(s.params.AudioEncodingOptions.AudioCodec), s.params.AudioEncodingOptions.DisableDtx, s.params.AudioEncodingOptions.Channels > 1, s.getTrackLabel(types.Audio))
 			if err != nil {
 				return
 			}
@@ -180,7 +180,7 @@ func (s *WebRTCSink) addVideoTrack(w, h int) ([]*Output, error) {
 			var tracks []*lksdk.LocalTrack
 			var pliHandlers []*lksdk_output.RTCPHandler
 
-			tracks, pliHandlers, err = sdkOut.AddVideoTrack(sortedLayers, putils.GetMimeTypeForVideoCodec(s.params.VideoEncodingOptions.VideoCodec))
+			tracks, pliHandlers, err = sdkOut.AddVideoTrack(sortedLayers, putils.GetMimeTypeForVideoCodec(s.params.VideoEncodingOptions.VideoCodec), s.getTrackLabel(types.Video))
 			if err != nil {
 				return
 			}
@@ -198,6 +198,15 @@ func (s *WebRTCSink) addVideoTrack(w, h int) ([]*Output, error) {
 	return outputs, nil
 }
 
+// getTrackLabel returns the publisher track label for WHIP inputs, if any
+func (s *WebRTCSink) getTrackLabel(kind types.StreamKind) string {
+	ep, ok := s.params.ExtraParams.(*params.WhipExtraParams)
+	if !ok {
+		return ""
+	}
+	return ep.TrackLabels[kind]
+}
+
 func (s *WebRTCSink) AddTrack(kind types.StreamKind, caps *gst.Caps) (*gst.Bin, error) {
 	var bin *gst.Bin
 
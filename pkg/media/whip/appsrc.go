@@ -96,10 +96,30 @@ func (w *whipAppSource) Start(ctx context.Context, getCorrectedTs func(time.Dura
 		return errors.ErrHttpRelayFailure(resp.StatusCode)
 	}
 
+	if v := resp.Header.Get(types.HeaderExtensionsHeader); v != "" {
+		extensions, err := types.ParseHeaderExtensions(v)
+		if err != nil {
+			logger.Warnw("invalid relayed RTP header extensions", err, "resourceID", w.resourceId, "kind", w.trackKind)
+		} else {
+			logger.Debugw("relayed RTP header extensions", "resourceID", w.resourceId, "kind", w.trackKind, "extensions", extensions)
+		}
+	}
+	if v := resp.Header.Get(types.SenderReportHeader); v != "" {
+		sr, err := types.ParseSenderReport(v)
+		if err != nil {
+			logger.Warnw("invalid relayed sender report", err, "resourceID", w.resourceId, "kind", w.trackKind)
+		} else {
+			logger.Debugw("relayed sender report", "resourceID", w.resourceId, "kind", w.trackKind, "rtpTime", sr.RTPTime, "ntpTime", sr.NTPTime, "clockRate", sr.ClockRate)
+		}
+	}
+
 	go func() {
 		defer resp.Body.Close()
 
 		err := w.copyRelayedData(resp.Body, getCorrectedTs)
+		if err == io.ErrUnexpectedEOF {
+			err = w.getSessionEnd(resp.Trailer)
+		}
 		logger.Debugw("WHIP app source relay stopped", "error", err, "resourceID", w.resourceId, "kind", w.trackKind)
 
 		w.appSrc.EndStream()
@@ -124,6 +144,27 @@ func (w *whipAppSource) GetAppSource() *app.Source {
 	return w.appSrc
 }
 
+// getSessionEnd returns how the WHIP session ended when its relay stopped, io.EOF for a normal end,
+// as reported by the WHIP server in the relay trailer
+func (w *whipAppSource) getSessionEnd(trailer http.Header) error {
+	v := trailer.Get(types.SessionEndTrailer)
+	if v == "" {
+		// relay stopped without the session ending
+		return io.ErrUnexpectedEOF
+	}
+
+	end, err := types.ParseSessionEnd(v)
+	if err != nil {
+		logger.Warnw("invalid relayed session end", err, "resourceID", w.resourceId, "kind", w.trackKind)
+		return io.ErrUnexpectedEOF
+	}
+	if end.Failed {
+		return errors.ErrRelayedSessionFailed(end.Error)
+	}
+
+	return io.EOF
+}
+
 func (w *whipAppSource) readRelayedData(r io.Reader, dataC chan<- readResult) {
 	var err error
 	var ts time.Duration
@@ -68,7 +68,9 @@ type Params struct {
 }
 
 type WhipExtraParams struct {
-	MimeTypes map[types.StreamKind]string `json:"mime_types"`
+	MimeTypes        map[types.StreamKind]string                  `json:"mime_types"`
+	TrackLabels      map[types.StreamKind]string                  `json:"track_labels,omitempty"`      // msid track identifiers from the publisher offer
+	HeaderExtensions map[types.StreamKind][]types.HeaderExtension `json:"header_extensions,omitempty"` // RTP header extensions negotiated with the publisher
 }
 
 func InitLogger(conf *config.Config, info *livekit.IngressInfo, loggingFields map[string]string) error {
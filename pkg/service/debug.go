@@ -16,6 +16,7 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -41,6 +42,10 @@ func (s *Service) StartDebugHandlers() {
 	mux := http.NewServeMux()
 	mux.HandleFunc(fmt.Sprintf("/%s/", gstPipelineDotFileApp), s.handleGstPipelineDotFile)
 	mux.HandleFunc(fmt.Sprintf("/%s/", pprofApp), s.handlePProf)
+	mux.HandleFunc("/admin/config", s.handleAdminConfig)
+	mux.HandleFunc("/admin/maintenance", s.handleAdminMaintenance)
+	mux.HandleFunc("/admin/capacity", s.handleAdminCapacity)
+	mux.HandleFunc("/admin/sessions", s.handleAdminSessions)
 
 	go func() {
 		addr := fmt.Sprintf(":%d", s.conf.DebugHandlerPort)
@@ -58,6 +63,142 @@ func (s *Service) GetGstPipelineDotFile(resourceID string) (string, error) {
 	return api.GetPipelineDot(context.Background())
 }
 
+// handleAdminConfig returns the effective WebRTC and WHIP configuration of this node, with credentials masked
+func (s *Service) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if s.whipSrv == nil {
+		http.Error(w, "WHIP disabled", http.StatusNotFound)
+		return
+	}
+
+	c := s.whipSrv.GetEffectiveConfig()
+	if c == nil {
+		http.Error(w, "WHIP server not started", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		logger.Debugw("failed writing effective config", "error", err)
+	}
+}
+
+// handleAdminMaintenance returns the WHIP maintenance mode on GET, and sets it on POST with the "enabled" query parameter
+func (s *Service) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.whipSrv == nil {
+		http.Error(w, "WHIP disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "invalid enabled parameter", http.StatusBadRequest)
+			return
+		}
+		s.whipSrv.SetMaintenance(enabled)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, _ = w.Write([]byte(strconv.FormatBool(s.whipSrv.InMaintenance())))
+}
+
+// capacity is the session capacity estimate of this node, for schedulers placing new sessions
+type capacity struct {
+	ActiveSessions int `json:"active_sessions"` // WHIP sessions on this node
+	MaxSessions    int `json:"max_sessions"`    // whip max_concurrent_sessions, 0 for no limit
+	ActiveRelays   int `json:"active_relays"`   // Relays associated across all WHIP sessions
+	// WHIP sessions by lifecycle state. Negotiating sessions are not counted in active_sessions
+	NegotiatingSessions int     `json:"negotiating_sessions"`
+	ConnectingSessions  int     `json:"connecting_sessions"`
+	ConnectedSessions   int     `json:"connected_sessions"`
+	EndedSessions       uint64  `json:"ended_sessions"` // Since the service started
+	MaxRelays           int     `json:"max_relays"`     // whip max_relays, 0 for no limit
+	AvailableCPU        float64 `json:"available_cpu"`  // CPUs available above the minimum idle ratio
+	// Sessions the available CPUs and the session limit leave room for, keyed by request type
+	AvailableSessions map[string]int `json:"available_sessions"`
+	// CPU cost of a session of each request type
+	CPUCosts map[string]float64 `json:"cpu_costs"`
+}
+
+// handleAdminCapacity returns the sessions on this node, the session limit and an estimate of the sessions it can still accept
+func (s *Service) handleAdminCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.confLock.Lock()
+	maxSessions := s.conf.WHIP.MaxConcurrentSessions
+	maxRelays := s.conf.WHIP.MaxRelays
+	s.confLock.Unlock()
+
+	headroom, costs := s.monitor.GetCPUHeadroom()
+	c := &capacity{
+		MaxSessions:  maxSessions,
+		MaxRelays:    maxRelays,
+		AvailableCPU: headroom,
+		CPUCosts: map[string]float64{
+			"rtmp":                    costs.RTMPCpuCost,
+			"whip":                    costs.WHIPCpuCost,
+			"whip_bypass_transcoding": costs.WHIPBypassTranscodingCpuCost,
+			"url":                     costs.URLCpuCost,
+		},
+		AvailableSessions: make(map[string]int),
+	}
+	if s.whipSrv != nil {
+		c.ActiveSessions = s.whipSrv.SessionCount()
+		c.ActiveRelays = s.whipSrv.RelayCount()
+		st := s.whipSrv.GetSessionStates()
+		c.NegotiatingSessions = st.Negotiating
+		c.ConnectingSessions = st.Connecting
+		c.ConnectedSessions = st.Connected
+		c.EndedSessions = st.Ended
+	}
+
+	for typ, cost := range c.CPUCosts {
+		var available int
+		if cost > 0 {
+			available = int(headroom / cost)
+		}
+		// Only WHIP sessions are bound by the session limit
+		if maxSessions > 0 && strings.HasPrefix(typ, "whip") {
+			available = min(available, max(maxSessions-c.ActiveSessions, 0))
+		}
+		c.AvailableSessions[typ] = available
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		logger.Debugw("failed writing capacity", "error", err)
+	}
+}
+
+// handleAdminSessions lists the WHIP sessions on this node. It is only served with whip list_sessions, as it exposes stream keys
+func (s *Service) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.confLock.Lock()
+	enabled := s.conf.WHIP.ListSessions
+	s.confLock.Unlock()
+
+	if s.whipSrv == nil || !enabled {
+		http.Error(w, "session listing disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.whipSrv.ListSessions()); err != nil {
+		logger.Debugw("failed writing sessions", "error", err)
+	}
+}
+
 // URL path format is "/<application>/<ingress_id>/<optional_other_params>"
 func (s *Service) handleGstPipelineDotFile(w http.ResponseWriter, r *http.Request) {
 	pathElements := strings.Split(r.URL.Path, "/")
@@ -47,7 +47,10 @@ import (
 	"github.com/livekit/psrpc"
 )
 
-const shutdownTimer = time.Second * 5
+const (
+	shutdownTimer         = time.Second * 5
+	sessionSummaryTimeout = time.Second * 2
+)
 
 type publishResponse struct {
 	params *params.Params
@@ -142,7 +145,7 @@ func (s *Service) HandleRTMPPublishRequest(streamKey, resourceId string) (*param
 	return p, stats, nil
 }
 
-func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (p *params.Params, ready func(mimeTypes map[types.StreamKind]string, err error) *stats.LocalMediaStatsGatherer, ended func(err error), err error) {
+func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (p *params.Params, ready func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer, ended func(summary *types.SessionSummary, err error), err error) {
 	ctx, span := tracer.Start(context.Background(), "Service.HandleWHIPPublishRequest")
 	defer span.End()
 
@@ -166,7 +169,7 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 		}
 	}
 
-	ready = func(mimeTypes map[types.StreamKind]string, err error) *stats.LocalMediaStatsGatherer {
+	ready = func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer {
 		ctx, span := tracer.Start(context.Background(), "Service.HandleWHIPPublishRequest.ready")
 		defer span.End()
 		if err != nil {
@@ -191,7 +194,9 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 			}})
 		} else {
 			p.SetExtraParams(&params.WhipExtraParams{
-				MimeTypes: mimeTypes,
+				MimeTypes:        mimeTypes,
+				TrackLabels:      trackLabels,
+				HeaderExtensions: headerExtensions,
 			})
 
 			err := s.manager.startIngress(ctx, p, func(ctx context.Context) {
@@ -209,22 +214,39 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 		return stats
 	}
 
-	if !*p.EnableTranscoding {
-		ended = func(err error) {
-			ctx, span := tracer.Start(context.Background(), "Service.HandleWHIPPublishRequest.ended")
-			defer span.End()
-
-			if err == nil {
-				p.SetStatus(livekit.IngressState_ENDPOINT_INACTIVE, nil)
-			} else {
-				logger.Warnw("ingress failed", err)
-				p.SetStatus(livekit.IngressState_ENDPOINT_ERROR, err)
-			}
+	ended = func(summary *types.SessionSummary, err error) {
+		ctx, span := tracer.Start(context.Background(), "Service.HandleWHIPPublishRequest.ended")
+		defer span.End()
 
-			p.SendStateUpdate(ctx)
-			s.sm.IngressEnded(p.IngressInfo.State.ResourceId)
-			DeregisterIngressRpcHandlers(rpcServer, p.IngressInfo)
+		if summary != nil {
+			p.GetLogger().Infow("WHIP session ended", "duration", summary.Duration, "bytesBeforeFirstFrame", summary.BytesBeforeFirstFrame, "packetsBeforeFirstFrame", summary.PacketsBeforeFirstFrame, "bitrates", summary.Bitrates, "error", err)
+		}
+
+		if *p.EnableTranscoding {
+			// The handler process owns the ingress state, and reports the end reason it reads from the relays
+			return
+		}
+
+		// Best effort, do not hold the session teardown
+		ctx, cancel := context.WithTimeout(ctx, sessionSummaryTimeout)
+		defer cancel()
+
+		if summary != nil && summary.Stats != nil {
+			// Include the final stats in the last state update
+			lsu := &stats.LocalStatsUpdater{Params: p}
+			_ = lsu.UpdateMediaStats(ctx, summary.Stats)
 		}
+
+		if err == nil {
+			p.SetStatus(livekit.IngressState_ENDPOINT_INACTIVE, nil)
+		} else {
+			logger.Warnw("ingress failed", err)
+			p.SetStatus(livekit.IngressState_ENDPOINT_ERROR, err)
+		}
+
+		p.SendStateUpdate(ctx)
+		s.sm.IngressEnded(p.IngressInfo.State.ResourceId)
+		DeregisterIngressRpcHandlers(rpcServer, p.IngressInfo)
 	}
 
 	return p, ready, ended, nil
@@ -396,7 +418,8 @@ func (s *Service) Run() error {
 
 	<-s.shutdown.Watch()
 	logger.Infow("shutting down")
-	for !s.sm.IsIdle() {
+	// WHIP sessions still negotiating are not known to the session manager yet
+	for !s.sm.IsIdle() || (s.whipSrv != nil && !s.whipSrv.IsIdle()) {
 		logger.Debugw("instance waiting for sessions to finish", "sessions_count", len(s.ListIngress()))
 		time.Sleep(shutdownTimer)
 	}
@@ -502,6 +525,7 @@ func (s *Service) GetHealthHandlers() whip.HealthHandlers {
 	return whip.HealthHandlers{
 		"/health":       http.HandlerFunc(s.HealthHandler),
 		"/availability": http.HandlerFunc(s.AvailabilityHandler),
+		"/ready":        http.HandlerFunc(s.ReadyHandler),
 	}
 }
 
@@ -514,6 +538,30 @@ func (s *Service) AvailabilityHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("Available"))
 }
 
+// ReadyHandler reports this node as not ready when it is in maintenance, draining or WHIP negotiations are failing
+// at a high rate, so that load balancers send new sessions to other nodes
+func (s *Service) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if s.whipSrv != nil {
+		if s.whipSrv.InMaintenance() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Maintenance"))
+			return
+		}
+		if s.whipSrv.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Draining"))
+			return
+		}
+		if healthy, failureRate := s.whipSrv.Healthy(); !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(fmt.Sprintf("Degraded, WHIP negotiation failure rate %.2f", failureRate)))
+			return
+		}
+	}
+
+	_, _ = w.Write([]byte("Ready"))
+}
+
 func (s *Service) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("Healthy"))
 }
@@ -48,8 +48,14 @@ type LocalStatsUpdater struct {
 }
 
 type LocalMediaStatsGatherer struct {
-	lock  sync.Mutex
-	stats []*MediaTrackStatGatherer
+	lock          sync.Mutex
+	stats         []*MediaTrackStatGatherer
+	turnServer    string
+	targetLatency time.Duration
+
+	rampClampedEstimates uint32
+	iceGatheringDuration time.Duration
+	connectionLabel      string
 }
 
 func NewMediaStats(statsUpdater types.MediaStatsUpdater) *MediaStatsReporter {
@@ -96,6 +102,18 @@ func (m *MediaStatsReporter) UpdateStats(ctx context.Context) {
 		for k, v := range ms.TrackStats {
 			res.TrackStats[k] = v
 		}
+		if ms.TurnServer != "" {
+			res.TurnServer = ms.TurnServer
+		}
+		if ms.TargetLatencyMs != 0 {
+			res.TargetLatencyMs = ms.TargetLatencyMs
+		}
+		if ms.RampClampedEstimates != 0 {
+			res.RampClampedEstimates = ms.RampClampedEstimates
+		}
+		if ms.IceGatheringDurationMs != 0 {
+			res.IceGatheringDurationMs = ms.IceGatheringDurationMs
+		}
 
 	}
 	m.lock.Unlock()
@@ -133,12 +151,57 @@ func (l *LocalMediaStatsGatherer) RegisterTrackStats(path string) *MediaTrackSta
 	return g
 }
 
+// SetTURNServer records the TURN server relaying the session media, empty if none
+func (l *LocalMediaStatsGatherer) SetTURNServer(turnServer string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.turnServer = turnServer
+}
+
+// SetRampClampedEstimates records the number of receiver estimates capped by the bandwidth ramp-up
+func (l *LocalMediaStatsGatherer) SetRampClampedEstimates(count uint32) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rampClampedEstimates = count
+}
+
+// SetICEGatheringDuration records the time the local ICE candidates took to be gathered
+func (l *LocalMediaStatsGatherer) SetICEGatheringDuration(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.iceGatheringDuration = d
+}
+
+// SetTargetLatency records the latency target the session buffers are tuned for, 0 for the defaults
+func (l *LocalMediaStatsGatherer) SetTargetLatency(targetLatency time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.targetLatency = targetLatency
+}
+
+// SetConnectionLabel records the label identifying the session in the exported stats
+func (l *LocalMediaStatsGatherer) SetConnectionLabel(label string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.connectionLabel = label
+}
+
 func (l *LocalMediaStatsGatherer) GatherStats(ctx context.Context) (*ipc.MediaStats, error) {
 	ms := &ipc.MediaStats{
 		TrackStats: make(map[string]*ipc.TrackStats),
 	}
 
 	l.lock.Lock()
+	ms.TurnServer = l.turnServer
+	ms.TargetLatencyMs = uint32(l.targetLatency.Milliseconds())
+	ms.RampClampedEstimates = l.rampClampedEstimates
+	ms.IceGatheringDurationMs = uint32(l.iceGatheringDuration.Milliseconds())
+	ms.ConnectionLabel = l.connectionLabel
 	for _, ts := range l.stats {
 		s := ts.UpdateStats()
 		ms.TrackStats[ts.Path()] = s
@@ -165,7 +228,10 @@ func (a *LocalStatsUpdater) UpdateMediaStats(ctx context.Context, s *ipc.MediaSt
 }
 
 func LogMediaStats(s *ipc.MediaStats, logger logger.Logger) {
+	if s.TurnServer != "" || s.TargetLatencyMs != 0 || s.RampClampedEstimates != 0 || s.IceGatheringDurationMs != 0 {
+		logger.Infow("session stats update", "connectionLabel", s.ConnectionLabel, "turnServer", s.TurnServer, "targetLatencyMs", s.TargetLatencyMs, "rampClampedEstimates", s.RampClampedEstimates, "iceGatheringDurationMs", s.IceGatheringDurationMs)
+	}
 	for k, v := range s.TrackStats {
-		logger.Infow("track stats update", "name", k, "currentBitrate", v.CurrentBitrate, "averageBitrate", v.AverageBitrate, "currentPackets", v.CurrentPackets, "totalPacket", v.TotalPackets, "currentLossRate", v.CurrentLossRate, "totalLossRate", v.TotalLossRate, "currentPLI", v.CurrentPli, "totalPLI", v.TotalPli, "jitter", v.Jitter)
+		logger.Infow("track stats update", "connectionLabel", s.ConnectionLabel, "name", k, "currentBitrate", v.CurrentBitrate, "averageBitrate", v.AverageBitrate, "currentPackets", v.CurrentPackets, "totalPacket", v.TotalPackets, "totalBytes", v.TotalBytes, "currentLossRate", v.CurrentLossRate, "totalLossRate", v.TotalLossRate, "currentPLI", v.CurrentPli, "totalPLI", v.TotalPli, "currentRecovered", v.CurrentRecoveredPackets, "totalRecovered", v.TotalRecoveredPackets, "currentFPS", v.CurrentFps, "averageFPS", v.AverageFps, "keyframeIntervalMs", v.KeyframeIntervalMs, "jitter", v.Jitter)
 	}
 }
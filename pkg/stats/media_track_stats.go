@@ -26,6 +26,10 @@ import (
 
 const (
 	maxJitterStatsLen = 100_000
+
+	// Number of recent frame RTP timestamps kept to not count again late packets of a frame,
+	// out of order packets or B-frames.
+	recentFrameTimestampsLen = 8
 )
 
 type MediaTrackStatGatherer struct {
@@ -33,21 +37,30 @@ type MediaTrackStatGatherer struct {
 
 	path string
 
-	totalBytes   int64
-	totalPackets int64
-	totalLost    int64
-	totalPLI     int64
-	startTime    time.Time
-
-	currentBytes   int64
-	currentPackets int64
-	currentLost    int64
-	currentPLI     int64
-	lastQueryTime  time.Time
+	totalBytes     int64
+	totalPackets   int64
+	totalLost      int64
+	totalPLI       int64
+	totalRecovered int64
+	totalFrames    int64
+	startTime      time.Time
+
+	currentBytes     int64
+	currentPackets   int64
+	currentLost      int64
+	currentPLI       int64
+	currentRecovered int64
+	currentFrames    int64
+	lastQueryTime    time.Time
 
 	lastPacketTime     time.Time
 	lastPacketInterval time.Duration
 	jitter             morestats.Sample
+
+	recentFrameTimestamps      [recentFrameTimestampsLen]uint32
+	recentFrameTimestampsCount int
+
+	keyframeInterval time.Duration
 }
 
 func NewMediaTrackStatGatherer(path string) *MediaTrackStatGatherer {
@@ -105,6 +118,41 @@ func (g *MediaTrackStatGatherer) PLI() {
 	g.totalPLI++
 }
 
+func (g *MediaTrackStatGatherer) PacketRecovered(count int64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.currentRecovered += count
+	g.totalRecovered += count
+}
+
+// FrameReceived can be called for every packet of a video frame, identified by its RTP timestamp
+func (g *MediaTrackStatGatherer) FrameReceived(rtpTimestamp uint32) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for i := 0; i < g.recentFrameTimestampsCount; i++ {
+		if g.recentFrameTimestamps[i] == rtpTimestamp {
+			return
+		}
+	}
+
+	copy(g.recentFrameTimestamps[1:], g.recentFrameTimestamps[:recentFrameTimestampsLen-1])
+	g.recentFrameTimestamps[0] = rtpTimestamp
+	g.recentFrameTimestampsCount = min(g.recentFrameTimestampsCount+1, recentFrameTimestampsLen)
+
+	g.totalFrames++
+	g.currentFrames++
+}
+
+// KeyframeReceived records the interval between the last two keyframes of a video track
+func (g *MediaTrackStatGatherer) KeyframeReceived(interval time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.keyframeInterval = interval
+}
+
 func (g *MediaTrackStatGatherer) UpdateStats() *ipc.TrackStats {
 	g.lock.Lock()
 	defer g.lock.Unlock()
@@ -116,6 +164,9 @@ func (g *MediaTrackStatGatherer) UpdateStats() *ipc.TrackStats {
 
 	currentLossRate := float64(g.currentLost) / float64(g.currentPackets)
 
+	averageFPS := float64(g.totalFrames) * float64(time.Second) / float64(now.Sub(g.startTime))
+	currentFPS := float64(g.currentFrames) * float64(time.Second) / float64(now.Sub(g.lastQueryTime))
+
 	jitter := g.jitter.Sort() // To make quantile computation faster
 
 	jitterStats := &ipc.JitterStats{
@@ -130,6 +181,7 @@ func (g *MediaTrackStatGatherer) UpdateStats() *ipc.TrackStats {
 	st := &ipc.TrackStats{
 		AverageBitrate:  averageBps,
 		CurrentBitrate:  currentBps,
+		TotalBytes:      uint64(g.totalBytes),
 		TotalPackets:    uint64(g.totalPackets),
 		CurrentPackets:  uint64(g.currentPackets),
 		TotalLossRate:   float64(g.totalLost) / float64(g.totalPackets),
@@ -137,6 +189,12 @@ func (g *MediaTrackStatGatherer) UpdateStats() *ipc.TrackStats {
 		TotalPli:        uint64(g.totalPLI),
 		CurrentPli:      uint64(g.currentPLI),
 		Jitter:          jitterStats,
+
+		TotalRecoveredPackets:   uint64(g.totalRecovered),
+		CurrentRecoveredPackets: uint64(g.currentRecovered),
+		AverageFps:              averageFPS,
+		CurrentFps:              currentFPS,
+		KeyframeIntervalMs:      uint32(g.keyframeInterval.Milliseconds()),
 	}
 
 	g.lastQueryTime = now
@@ -144,6 +202,8 @@ func (g *MediaTrackStatGatherer) UpdateStats() *ipc.TrackStats {
 	g.currentPackets = 0
 	g.currentLost = 0
 	g.currentPLI = 0
+	g.currentRecovered = 0
+	g.currentFrames = 0
 
 	return st
 }
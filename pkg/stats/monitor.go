@@ -79,13 +79,13 @@ func (m *Monitor) Start(conf *config.Config) error {
 		Namespace:   "livekit",
 		Subsystem:   "node",
 		Name:        "cpu_load",
-		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "INGRESS"},
+		ConstLabels: NodeLabels(conf, "node_type", "INGRESS"),
 	})
 	m.promNodeAvailable = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace:   "livekit",
 		Subsystem:   "ingress",
 		Name:        "available",
-		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		ConstLabels: NodeLabels(conf),
 	}, func() float64 {
 		c := m.CanAccept()
 		if c {
@@ -97,7 +97,7 @@ func (m *Monitor) Start(conf *config.Config) error {
 		Namespace:   "livekit",
 		Subsystem:   "ingress",
 		Name:        "requests",
-		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		ConstLabels: NodeLabels(conf),
 	}, []string{"type", "transcoding"})
 
 	prometheus.MustRegister(m.promCPULoad, m.promNodeAvailable, m.requestGauge)
@@ -220,6 +220,19 @@ func (m *Monitor) GetCPULoad() float64 {
 	return (float64(m.cpuStats.NumCPU()) - m.cpuStats.GetCPUIdle()) / float64(m.cpuStats.NumCPU()) * 100
 }
 
+// GetCPUHeadroom returns the CPUs available for new requests above the minimum idle ratio, with the
+// cost of each request type. The headroom is 0 until the monitor is started and once it is shut down.
+func (m *Monitor) GetCPUHeadroom() (float64, config.CPUCostConfig) {
+	m.costConfigLock.Lock()
+	defer m.costConfigLock.Unlock()
+
+	if !m.started.IsBroken() || m.shutdown.IsBroken() {
+		return 0, m.cpuCostConfig
+	}
+
+	return max(m.getAvailable(m.cpuCostConfig.MinIdleRatio), 0), m.cpuCostConfig
+}
+
 func (m *Monitor) CanAccept() bool {
 	if !m.started.IsBroken() || m.shutdown.IsBroken() {
 		return false
@@ -320,3 +333,19 @@ func (m *Monitor) IngressEnded(info *livekit.IngressInfo) {
 func (m *Monitor) getAvailable(minIdleRatio float64) float64 {
 	return m.cpuStats.GetCPUIdle() - m.pendingCPUs.Load() - minIdleRatio*m.cpuStats.NumCPU()
 }
+
+// NodeLabels returns the labels identifying this node, followed by the extra label name and value pairs
+func NodeLabels(conf *config.Config, extra ...string) prometheus.Labels {
+	labels := prometheus.Labels{"node_id": conf.NodeID}
+	if conf.Region != "" {
+		labels["region"] = conf.Region
+	}
+	if conf.Cluster != "" {
+		labels["cluster"] = conf.Cluster
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels[extra[i]] = extra[i+1]
+	}
+
+	return labels
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HeaderExtensionsHeader is the relay response header carrying the RTP header extensions negotiated with the publisher
+const HeaderExtensionsHeader = "X-RTP-Header-Extensions"
+
+// HeaderExtension is an RTP header extension ID to URI mapping negotiated with the publisher
+type HeaderExtension struct {
+	ID  int    `json:"id"`
+	URI string `json:"uri"`
+}
+
+// FormatHeaderExtensions encodes the extensions as a comma separated list of id=uri pairs
+func FormatHeaderExtensions(extensions []HeaderExtension) string {
+	pairs := make([]string, 0, len(extensions))
+	for _, e := range extensions {
+		pairs = append(pairs, fmt.Sprintf("%d=%s", e.ID, e.URI))
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// ParseHeaderExtensions decodes a list encoded by FormatHeaderExtensions
+func ParseHeaderExtensions(s string) ([]HeaderExtension, error) {
+	var extensions []HeaderExtension
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, uri, ok := strings.Cut(pair, "=")
+		if !ok || uri == "" {
+			return nil, fmt.Errorf("invalid header extension %q", pair)
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 1 || n > 255 {
+			return nil, fmt.Errorf("invalid header extension ID %q", id)
+		}
+
+		extensions = append(extensions, HeaderExtension{ID: n, URI: uri})
+	}
+
+	return extensions, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderExtensionsRoundTrip(t *testing.T) {
+	extensions := []HeaderExtension{
+		{ID: 1, URI: "urn:ietf:params:rtp-hdrext:sdes:mid"},
+		{ID: 3, URI: "http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"},
+	}
+
+	s := FormatHeaderExtensions(extensions)
+	require.Equal(t, "1=urn:ietf:params:rtp-hdrext:sdes:mid, 3=http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time", s)
+
+	parsed, err := ParseHeaderExtensions(s)
+	require.NoError(t, err)
+	require.Equal(t, extensions, parsed)
+
+	parsed, err = ParseHeaderExtensions("")
+	require.NoError(t, err)
+	require.Empty(t, parsed)
+
+	_, err = ParseHeaderExtensions("x=urn:a")
+	require.Error(t, err)
+	_, err = ParseHeaderExtensions("1")
+	require.Error(t, err)
+}
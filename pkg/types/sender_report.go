@@ -0,0 +1,80 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SenderReportHeader is the relay response header carrying the latest RTP to NTP timestamp mapping of the track
+const SenderReportHeader = "X-RTP-Sender-Report"
+
+// SenderReport is the RTP to NTP timestamp mapping of the last RTCP sender report received for a track,
+// used downstream to align the tracks of a session
+type SenderReport struct {
+	RTPTime   uint32 `json:"rtp_time"`
+	NTPTime   uint64 `json:"ntp_time"` // 64 bit NTP timestamp, seconds in the upper 32 bits
+	ClockRate uint32 `json:"clock_rate"`
+}
+
+// FormatSenderReport encodes the mapping as a semicolon separated list of key=value pairs
+func FormatSenderReport(sr SenderReport) string {
+	return fmt.Sprintf("rtp=%d; ntp=%d; clock_rate=%d", sr.RTPTime, sr.NTPTime, sr.ClockRate)
+}
+
+// ParseSenderReport decodes a mapping encoded by FormatSenderReport
+func ParseSenderReport(s string) (SenderReport, error) {
+	var sr SenderReport
+	var hasRTP, hasNTP bool
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return SenderReport{}, fmt.Errorf("invalid sender report field %q", pair)
+		}
+
+		var err error
+		switch key {
+		case "rtp":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 32)
+			sr.RTPTime, hasRTP = uint32(v), true
+		case "ntp":
+			sr.NTPTime, err = strconv.ParseUint(value, 10, 64)
+			hasNTP = true
+		case "clock_rate":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 32)
+			sr.ClockRate = uint32(v)
+		default:
+			// Ignore fields added later
+		}
+		if err != nil {
+			return SenderReport{}, fmt.Errorf("invalid sender report field %q", pair)
+		}
+	}
+
+	if !hasRTP || !hasNTP {
+		return SenderReport{}, fmt.Errorf("incomplete sender report %q", s)
+	}
+
+	return sr, nil
+}
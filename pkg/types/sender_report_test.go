@@ -0,0 +1,49 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderReportRoundTrip(t *testing.T) {
+	sr := SenderReport{
+		RTPTime:   4294967295,
+		NTPTime:   0xe8f3a1b2c3d4e5f6,
+		ClockRate: 90000,
+	}
+
+	s := FormatSenderReport(sr)
+	require.Equal(t, "rtp=4294967295; ntp=16785938025301730806; clock_rate=90000", s)
+
+	parsed, err := ParseSenderReport(s)
+	require.NoError(t, err)
+	require.Equal(t, sr, parsed)
+
+	parsed, err = ParseSenderReport(s + "; future=1")
+	require.NoError(t, err)
+	require.Equal(t, sr, parsed)
+
+	_, err = ParseSenderReport("")
+	require.Error(t, err)
+	_, err = ParseSenderReport("rtp=1")
+	require.Error(t, err)
+	_, err = ParseSenderReport("rtp=4294967296; ntp=1")
+	require.Error(t, err)
+	_, err = ParseSenderReport("rtp; ntp=1")
+	require.Error(t, err)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SessionEndTrailer is the relay response trailer carrying how the WHIP session of the relayed track ended
+const SessionEndTrailer = "X-Ingress-Session-End"
+
+const (
+	sessionEndStatusEnded  = "ended"
+	sessionEndStatusFailed = "failed"
+)
+
+// SessionEnd is how a WHIP session ended, so that the handler process reading its relays reports
+// the same reason as the WHIP server
+type SessionEnd struct {
+	Failed bool
+	Error  string // reason of the failure
+}
+
+// NewSessionEnd returns the end of a session that ended with err, nil for a normal end
+func NewSessionEnd(err error) SessionEnd {
+	if err == nil {
+		return SessionEnd{}
+	}
+
+	return SessionEnd{Failed: true, Error: err.Error()}
+}
+
+// FormatSessionEnd encodes the end of a session as a semicolon separated list of key=value pairs
+func FormatSessionEnd(end SessionEnd) string {
+	if !end.Failed {
+		return "status=" + sessionEndStatusEnded
+	}
+
+	// Error messages may contain separators or characters not allowed in a trailer value
+	return fmt.Sprintf("status=%s; error=%s", sessionEndStatusFailed, url.QueryEscape(end.Error))
+}
+
+// ParseSessionEnd decodes the end of a session encoded by FormatSessionEnd
+func ParseSessionEnd(s string) (SessionEnd, error) {
+	var end SessionEnd
+	var status string
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return SessionEnd{}, fmt.Errorf("invalid session end field %q", pair)
+		}
+
+		switch key {
+		case "status":
+			status = value
+		case "error":
+			var err error
+			end.Error, err = url.QueryUnescape(value)
+			if err != nil {
+				return SessionEnd{}, fmt.Errorf("invalid session end field %q", pair)
+			}
+		default:
+			// Ignore fields added later
+		}
+	}
+
+	switch status {
+	case sessionEndStatusEnded:
+		return SessionEnd{}, nil
+	case sessionEndStatusFailed:
+		end.Failed = true
+		return end, nil
+	default:
+		return SessionEnd{}, fmt.Errorf("invalid session end status %q", s)
+	}
+}
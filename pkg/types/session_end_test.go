@@ -0,0 +1,55 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionEndRoundTrip(t *testing.T) {
+	// A normal end and a failure are reported differently
+	ended := NewSessionEnd(nil)
+	require.False(t, ended.Failed)
+	require.Equal(t, "status=ended", FormatSessionEnd(ended))
+
+	failed := NewSessionEnd(errors.New("first media timeout; no packet received\nin 5s"))
+	require.True(t, failed.Failed)
+	s := FormatSessionEnd(failed)
+	require.NotContains(t, s, "\n")
+
+	parsed, err := ParseSessionEnd(s)
+	require.NoError(t, err)
+	require.Equal(t, failed, parsed)
+
+	parsed, err = ParseSessionEnd("status=ended")
+	require.NoError(t, err)
+	require.Equal(t, ended, parsed)
+
+	parsed, err = ParseSessionEnd(s + "; future=1")
+	require.NoError(t, err)
+	require.Equal(t, failed, parsed)
+
+	_, err = ParseSessionEnd("")
+	require.Error(t, err)
+	_, err = ParseSessionEnd("status=unknown")
+	require.Error(t, err)
+	_, err = ParseSessionEnd("status=failed; error=%zz")
+	require.Error(t, err)
+	_, err = ParseSessionEnd("status")
+	require.Error(t, err)
+}
@@ -16,10 +16,32 @@ package types
 
 import (
 	"context"
+	"time"
 
 	"github.com/livekit/ingress/pkg/ipc"
 )
 
+// SessionSummary describes a session once it has ended
+type SessionSummary struct {
+	Duration time.Duration
+	Stats    *ipc.MediaStats
+
+	// RTP payload received on the video track before its first keyframe, such as parameter sets and
+	// non decodable frames. Zero if no keyframe was received.
+	BytesBeforeFirstFrame   uint64
+	PacketsBeforeFirstFrame uint64
+
+	// Bitrates advertised in the offer and measured over the session, for the tracks with an advertised bitrate
+	Bitrates map[StreamKind]TrackBitrate
+}
+
+// TrackBitrate compares the bitrate a publisher advertised for a track with the one it sent
+type TrackBitrate struct {
+	Advertised uint64  // bps
+	Measured   uint64  // bps, averaged over the session
+	Ratio      float64 // Measured / Advertised
+}
+
 type MediaStatsUpdater interface {
 	UpdateMediaStats(ctx context.Context, stats *ipc.MediaStats) error
 }
@@ -0,0 +1,97 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/protocol/logger"
+)
+
+// AnswerRequest holds the negotiation state an AnswerBuilder builds the answer from
+type AnswerRequest struct {
+	// Offer is the offer as applied to the peer connection, after the codec and feedback filtering
+	Offer string
+	// LocalAnswer is the answer generated by the media engine from the offer, once ICE gathering
+	// completed. It is the local description of the peer connection and cannot be changed.
+	LocalAnswer string
+	// Params are the parameters of the session, with the WHIP configuration
+	Params *params.Params
+}
+
+// AnswerBuilder produces the SDP answer returned to the WHIP client.
+//
+// The peer connection keeps using LocalAnswer whatever is returned, so the answer must stay
+// compatible with it: same media sections in the same order, mids, ICE credentials, DTLS
+// fingerprint and setup role. Builders may reorder, remove or rewrite what only the client
+// acts on, such as codecs among the negotiated ones, header extensions, feedback, candidates
+// and bandwidth lines. The a=ice-options:trickle attribute is added after the builder runs if missing.
+//
+// BuildAnswer is called from concurrent sessions. Returning an error fails the session setup.
+type AnswerBuilder interface {
+	BuildAnswer(req *AnswerRequest) (string, error)
+}
+
+// DefaultAnswerBuilder applies the reduced-size RTCP, mixed header extension, IP family, candidate ordering and candidate limit settings to the local answer
+type DefaultAnswerBuilder struct{}
+
+func (DefaultAnswerBuilder) BuildAnswer(req *AnswerRequest) (string, error) {
+	conf := &req.Params.WHIP
+
+	// Pion always advertises reduced-size RTCP. The local description must match the generated answer,
+	// so only the answer sent to the client is updated.
+	answer, err := filterRTCPReducedSize(req.LocalAnswer, req.Offer, conf.RTCPReducedSize == nil || *conf.RTCPReducedSize)
+	if err != nil {
+		return "", err
+	}
+
+	// Pion echoes a=extmap-allow-mixed when offered, and parses both header extension formats whatever the answer
+	answer, err = filterExtmapAllowMixed(answer, req.Offer, conf.ExtmapAllowMixed == nil || *conf.ExtmapAllowMixed)
+	if err != nil {
+		return "", err
+	}
+
+	// Preferred interfaces are applied after the IP families so that they take precedence over the family order
+	answer = filterCandidateFamilies(answer, conf.IPFamilies)
+	answer = applyPreferredInterfaces(req.Params.GetLogger(), conf.PreferredInterfaces, answer)
+
+	return applyCandidateLimit(req.Params.GetLogger(), conf.MaxCandidatesPerType, answer), nil
+}
+
+// applyCandidateLimit trims the local candidates to the limit per type. It runs after candidates are
+// reordered, as the priorities it keeps the highest of are rewritten to match the preferred order.
+func applyCandidateLimit(l logger.Logger, maxPerType int, sdp string) string {
+	sdp, removed := limitCandidates(sdp, maxPerType)
+	if removed > 0 {
+		l.Infow("trimmed answer candidates", "removed", removed, "maxPerType", maxPerType)
+	}
+
+	return sdp
+}
+
+// applyPreferredInterfaces reorders the local candidates according to the preferred interfaces.
+// Interface addresses are resolved for every session as they may change at runtime.
+func applyPreferredInterfaces(l logger.Logger, interfaces []string, sdp string) string {
+	if len(interfaces) == 0 {
+		return sdp
+	}
+
+	preferred, err := getInterfaceIPs(interfaces)
+	if err != nil {
+		l.Warnw("failed resolving preferred interfaces, keeping candidate order", err, "interfaces", interfaces)
+		return sdp
+	}
+
+	return prioritizeCandidates(sdp, preferred)
+}
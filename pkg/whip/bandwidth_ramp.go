@@ -0,0 +1,89 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+	"time"
+)
+
+// bandwidthRamp caps the receiver bandwidth estimates sent to the publisher for a while after the
+// connection is established, so that its bitrate does not grow faster than its uplink absorbs
+type bandwidthRamp struct {
+	initial  uint64 // bps
+	rate     uint64 // bps per second
+	duration time.Duration
+
+	lock    sync.Mutex
+	start   time.Time
+	clamped uint32
+}
+
+func newBandwidthRamp(initial uint64, rate uint64, duration time.Duration) *bandwidthRamp {
+	return &bandwidthRamp{
+		initial:  initial,
+		rate:     rate,
+		duration: duration,
+	}
+}
+
+// Start starts the ramp, usually once the connection is established. Later calls are ignored.
+func (r *bandwidthRamp) Start(now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.start.IsZero() {
+		r.start = now
+	}
+}
+
+// Limit returns the highest estimate allowed at the given time, and false if the ramp is not running
+func (r *bandwidthRamp) Limit(now time.Time) (uint64, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.limit(now)
+}
+
+// Apply returns the estimate capped to the current limit
+func (r *bandwidthRamp) Apply(estimate uint64, now time.Time) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	limit, ok := r.limit(now)
+	if !ok || estimate <= limit {
+		return estimate
+	}
+	r.clamped++
+
+	return limit
+}
+
+// Clamped returns the number of estimates capped so far
+func (r *bandwidthRamp) Clamped() uint32 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.clamped
+}
+
+func (r *bandwidthRamp) limit(now time.Time) (uint64, bool) {
+	elapsed := now.Sub(r.start)
+	if r.start.IsZero() || elapsed >= r.duration {
+		return 0, false
+	}
+
+	return r.initial + uint64(float64(r.rate)*elapsed.Seconds()), true
+}
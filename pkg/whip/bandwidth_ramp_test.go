@@ -0,0 +1,48 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthRamp(t *testing.T) {
+	r := newBandwidthRamp(500_000, 250_000, 4*time.Second)
+	now := time.Now()
+
+	// Not started
+	require.Equal(t, uint64(5_000_000), r.Apply(5_000_000, now))
+
+	r.Start(now)
+	r.Start(now.Add(time.Second))
+
+	limit, ok := r.Limit(now.Add(2 * time.Second))
+	require.True(t, ok)
+	require.Equal(t, uint64(1_000_000), limit)
+
+	require.Equal(t, uint64(500_000), r.Apply(5_000_000, now))
+	require.Equal(t, uint64(300_000), r.Apply(300_000, now.Add(time.Second)))
+	require.Equal(t, uint64(1_000_000), r.Apply(5_000_000, now.Add(2*time.Second)))
+
+	// Over
+	_, ok = r.Limit(now.Add(4 * time.Second))
+	require.False(t, ok)
+	require.Equal(t, uint64(5_000_000), r.Apply(5_000_000, now.Add(5*time.Second)))
+
+	require.Equal(t, uint32(2), r.Clamped())
+}
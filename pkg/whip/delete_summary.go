@@ -0,0 +1,79 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"github.com/livekit/ingress/pkg/types"
+)
+
+// deleteSummary is the JSON body returned to clients ending their session with a DELETE request
+type deleteSummary struct {
+	DurationMs              int64                         `json:"duration_ms"`
+	ICEGatheringDurationMs  uint32                        `json:"ice_gathering_duration_ms,omitempty"`
+	BytesBeforeFirstFrame   uint64                        `json:"bytes_before_first_frame,omitempty"`
+	PacketsBeforeFirstFrame uint64                        `json:"packets_before_first_frame,omitempty"`
+	Tracks                  map[string]deleteTrackSummary `json:"tracks,omitempty"` // keyed by stats path, e.g. "input.video"
+}
+
+type deleteTrackSummary struct {
+	TotalBytes     uint64  `json:"total_bytes"`
+	TotalPackets   uint64  `json:"total_packets"`
+	AverageBitrate uint32  `json:"average_bitrate"`
+	TotalLossRate  float64 `json:"total_loss_rate"`
+	TotalPLI       uint64  `json:"total_pli"`
+	AverageFPS     float64 `json:"average_fps,omitempty"`
+	JitterP99Ms    float64 `json:"jitter_p99_ms,omitempty"`
+	// Bitrate advertised in the offer, and the ratio of the average bitrate to it
+	AdvertisedBitrate uint64  `json:"advertised_bitrate,omitempty"`
+	BitrateRatio      float64 `json:"bitrate_ratio,omitempty"`
+}
+
+func newDeleteSummary(summary *types.SessionSummary) *deleteSummary {
+	res := &deleteSummary{
+		DurationMs:              summary.Duration.Milliseconds(),
+		BytesBeforeFirstFrame:   summary.BytesBeforeFirstFrame,
+		PacketsBeforeFirstFrame: summary.PacketsBeforeFirstFrame,
+	}
+
+	if summary.Stats == nil {
+		return res
+	}
+
+	res.ICEGatheringDurationMs = summary.Stats.IceGatheringDurationMs
+
+	res.Tracks = make(map[string]deleteTrackSummary, len(summary.Stats.TrackStats))
+	for path, ts := range summary.Stats.TrackStats {
+		t := deleteTrackSummary{
+			TotalBytes:     ts.TotalBytes,
+			TotalPackets:   ts.TotalPackets,
+			AverageBitrate: ts.AverageBitrate,
+			TotalLossRate:  ts.TotalLossRate,
+			TotalPLI:       ts.TotalPli,
+			AverageFPS:     ts.AverageFps,
+		}
+		if ts.Jitter != nil {
+			t.JitterP99Ms = ts.Jitter.P99
+		}
+		for kind, b := range summary.Bitrates {
+			if inputStatsPath(kind) == path {
+				t.AdvertisedBitrate = b.Advertised
+				t.BitrateRatio = b.Ratio
+			}
+		}
+		res.Tracks[path] = t
+	}
+
+	return res
+}
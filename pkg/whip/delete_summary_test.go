@@ -0,0 +1,81 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/ipc"
+	"github.com/livekit/ingress/pkg/types"
+)
+
+func TestNewDeleteSummary(t *testing.T) {
+	require.Equal(t, &deleteSummary{DurationMs: 1500}, newDeleteSummary(&types.SessionSummary{Duration: 1500 * time.Millisecond}))
+
+	summary := newDeleteSummary(&types.SessionSummary{
+		Duration:                time.Minute,
+		BytesBeforeFirstFrame:   1200,
+		PacketsBeforeFirstFrame: 3,
+		Stats: &ipc.MediaStats{
+			TrackStats: map[string]*ipc.TrackStats{
+				"input.video": {
+					TotalBytes:     1000,
+					TotalPackets:   10,
+					AverageBitrate: 2000,
+					TotalLossRate:  0.01,
+					TotalPli:       2,
+					AverageFps:     30,
+					Jitter:         &ipc.JitterStats{P99: 12},
+				},
+				"input.audio": {
+					TotalBytes:   100,
+					TotalPackets: 5,
+				},
+			},
+			IceGatheringDurationMs: 250,
+		},
+	})
+
+	require.Equal(t, &deleteSummary{
+		DurationMs:              60000,
+		ICEGatheringDurationMs:  250,
+		BytesBeforeFirstFrame:   1200,
+		PacketsBeforeFirstFrame: 3,
+		Tracks: map[string]deleteTrackSummary{
+			"input.video": {TotalBytes: 1000, TotalPackets: 10, AverageBitrate: 2000, TotalLossRate: 0.01, TotalPLI: 2, AverageFPS: 30, JitterP99Ms: 12},
+			"input.audio": {TotalBytes: 100, TotalPackets: 5},
+		},
+	}, summary)
+}
+
+func TestTrackBitrates(t *testing.T) {
+	ms := &ipc.MediaStats{
+		TrackStats: map[string]*ipc.TrackStats{
+			"input.video": {AverageBitrate: 1500000},
+		},
+	}
+	bitrates := getTrackBitrates(map[types.StreamKind]uint64{types.Video: 2000000, types.Audio: 64000}, ms)
+	require.Equal(t, map[types.StreamKind]types.TrackBitrate{
+		types.Video: {Advertised: 2000000, Measured: 1500000, Ratio: 0.75},
+		types.Audio: {Advertised: 64000},
+	}, bitrates)
+	require.Nil(t, getTrackBitrates(nil, ms))
+
+	summary := newDeleteSummary(&types.SessionSummary{Stats: ms, Bitrates: bitrates})
+	require.Equal(t, deleteTrackSummary{AverageBitrate: 1500000, AdvertisedBitrate: 2000000, BitrateRatio: 0.75}, summary.Tracks["input.video"])
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"github.com/livekit/ingress/pkg/config"
+)
+
+const maskedValue = "***"
+
+// EffectiveConfig is the resolved WebRTC configuration and the WHIP settings in use by the server
+type EffectiveConfig struct {
+	ICEServers              []EffectiveICEServer `json:"ice_servers"`
+	ICETransportPolicy      string               `json:"ice_transport_policy"`
+	NAT1To1IPs              []string             `json:"nat_1to1_ips"`
+	UseMDNS                 bool                 `json:"use_mdns"`
+	UDPMux                  bool                 `json:"udp_mux"`
+	UDPPortStart            int                  `json:"udp_port_start"`
+	UDPPortEnd              int                  `json:"udp_port_end"`
+	ICEPortRangeStart       uint32               `json:"port_range_start"`
+	ICEPortRangeEnd         uint32               `json:"port_range_end"`
+	TCPPort                 uint32               `json:"tcp_port"`
+	TCPMux                  bool                 `json:"tcp_mux"`
+	NodeIP                  string               `json:"node_ip"`
+	UseExternalIP           bool                 `json:"use_external_ip"`
+	ExternalIPOnly          bool                 `json:"external_ip_only"`
+	UseICELite              bool                 `json:"use_ice_lite"`
+	ForceTCP                bool                 `json:"force_tcp"`
+	EnableLoopbackCandidate bool                 `json:"enable_loopback_candidate"`
+	InterfaceIncludes       []string             `json:"interface_includes"`
+	InterfaceExcludes       []string             `json:"interface_excludes"`
+	IPIncludes              []string             `json:"ip_includes"`
+	IPExcludes              []string             `json:"ip_excludes"`
+
+	WHIP config.WHIPConfig `json:"whip"`
+}
+
+// EffectiveICEServer is an ICE server with its credentials masked
+type EffectiveICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// GetEffectiveConfig returns the configuration in use, or nil if the server is not started
+func (s *WHIPServer) GetEffectiveConfig() *EffectiveConfig {
+	if s.webRTCConfig == nil || s.conf == nil {
+		return nil
+	}
+
+	rtcConf := s.conf.RTCConfig
+	c := &EffectiveConfig{
+		ICETransportPolicy:      s.webRTCConfig.Configuration.ICETransportPolicy.String(),
+		NAT1To1IPs:              s.webRTCConfig.NAT1To1IPs,
+		UseMDNS:                 s.webRTCConfig.UseMDNS,
+		UDPMux:                  s.webRTCConfig.UDPMux != nil,
+		UDPPortStart:            rtcConf.UDPPort.Start,
+		UDPPortEnd:              rtcConf.UDPPort.End,
+		ICEPortRangeStart:       rtcConf.ICEPortRangeStart,
+		ICEPortRangeEnd:         rtcConf.ICEPortRangeEnd,
+		TCPPort:                 rtcConf.TCPPort,
+		TCPMux:                  s.webRTCConfig.TCPMuxListener != nil,
+		NodeIP:                  rtcConf.NodeIP,
+		UseExternalIP:           rtcConf.UseExternalIP,
+		ExternalIPOnly:          rtcConf.ExternalIPOnly,
+		UseICELite:              rtcConf.UseICELite,
+		ForceTCP:                rtcConf.ForceTCP,
+		EnableLoopbackCandidate: rtcConf.EnableLoopbackCandidate,
+		InterfaceIncludes:       rtcConf.Interfaces.Includes,
+		InterfaceExcludes:       rtcConf.Interfaces.Excludes,
+		IPIncludes:              rtcConf.IPs.Includes,
+		IPExcludes:              rtcConf.IPs.Excludes,
+		WHIP:                    s.conf.WHIP,
+	}
+
+	if c.WHIP.SessionTokenSecret != "" {
+		c.WHIP.SessionTokenSecret = maskedValue
+	}
+
+	for _, iceServer := range s.webRTCConfig.Configuration.ICEServers {
+		server := EffectiveICEServer{
+			URLs: iceServer.URLs,
+		}
+		if iceServer.Username != "" {
+			server.Username = maskedValue
+		}
+		if iceServer.Credential != nil {
+			server.Credential = maskedValue
+		}
+		c.ICEServers = append(c.ICEServers, server)
+	}
+
+	return c
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	MimeTypeULPFEC  = "video/ulpfec"
+	MimeTypeFlexFEC = "video/flexfec-03"
+
+	ulpfecPayloadType  = 116
+	flexfecPayloadType = 118
+
+	rtpHeaderSize          = 12
+	ulpfecHeaderSize       = 10
+	ulpfecLevelHeaderShort = 4
+	ulpfecLevelHeaderLong  = 8
+
+	fecHistorySize = 512
+)
+
+func isFECMimeType(mimeType string) bool {
+	switch strings.ToLower(mimeType) {
+	case MimeTypeULPFEC, MimeTypeFlexFEC:
+		return true
+	default:
+		return false
+	}
+}
+
+// ULPFECReceiver recovers single packet losses from in band ULPFEC packets (RFC 5109) sent on the
+// media SSRC. Only the level 0 protection is used. It is not safe for concurrent use.
+type ULPFECReceiver struct {
+	payloadType uint8
+
+	history [fecHistorySize][]byte
+}
+
+// NewULPFECReceiver returns nil if ULPFEC wasn't negotiated for the receiver
+func NewULPFECReceiver(receiver *webrtc.RTPReceiver) *ULPFECReceiver {
+	if receiver == nil {
+		return nil
+	}
+
+	for _, c := range receiver.GetParameters().Codecs {
+		if strings.EqualFold(c.MimeType, MimeTypeULPFEC) {
+			return &ULPFECReceiver{
+				payloadType: uint8(c.PayloadType),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *ULPFECReceiver) IsFECPacket(pkt *rtp.Packet) bool {
+	return pkt.PayloadType == r.payloadType
+}
+
+func (r *ULPFECReceiver) PushMedia(pkt *rtp.Packet) {
+	b, err := pkt.Marshal()
+	if err != nil {
+		return
+	}
+
+	r.history[pkt.SequenceNumber%fecHistorySize] = b
+}
+
+// Recover returns the media packet restored from the FEC packet, or nil if no packet
+// could be recovered, either because none was lost or too many were.
+func (r *ULPFECReceiver) Recover(fecPkt *rtp.Packet) *rtp.Packet {
+	p := fecPkt.Payload
+	if len(p) < ulpfecHeaderSize+ulpfecLevelHeaderShort {
+		return nil
+	}
+
+	levelHeaderSize := ulpfecLevelHeaderShort
+	if p[0]&0x40 != 0 {
+		levelHeaderSize = ulpfecLevelHeaderLong
+	}
+	if len(p) < ulpfecHeaderSize+levelHeaderSize {
+		return nil
+	}
+
+	snBase := binary.BigEndian.Uint16(p[2:4])
+	protectionLength := int(binary.BigEndian.Uint16(p[ulpfecHeaderSize : ulpfecHeaderSize+2]))
+	mask := p[ulpfecHeaderSize+2 : ulpfecHeaderSize+levelHeaderSize]
+
+	payloadStart := ulpfecHeaderSize + levelHeaderSize
+	if len(p) < payloadStart+protectionLength {
+		return nil
+	}
+
+	var missing []uint16
+	var received [][]byte
+	for i := 0; i < len(mask)*8; i++ {
+		if mask[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+
+		sn := snBase + uint16(i)
+		b := r.history[sn%fecHistorySize]
+		if b == nil || binary.BigEndian.Uint16(b[2:4]) != sn {
+			missing = append(missing, sn)
+			continue
+		}
+		received = append(received, b)
+	}
+
+	if len(missing) != 1 {
+		return nil
+	}
+
+	// Recovery bit string, laid out as the first 8 bytes of the FEC header without the SN base:
+	// P, X, CC, M, PT, length recovery and TS recovery
+	var bitString [8]byte
+	copy(bitString[0:2], p[0:2])
+	copy(bitString[2:4], p[8:10])
+	copy(bitString[4:8], p[4:8])
+
+	payload := make([]byte, protectionLength)
+	copy(payload, p[payloadStart:payloadStart+protectionLength])
+
+	for _, b := range received {
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(b)-rtpHeaderSize))
+
+		bitString[0] ^= b[0]
+		bitString[1] ^= b[1]
+		bitString[2] ^= length[0]
+		bitString[3] ^= length[1]
+		for i := 4; i < 8; i++ {
+			bitString[i] ^= b[i]
+		}
+
+		for i := 0; i < protectionLength && rtpHeaderSize+i < len(b); i++ {
+			payload[i] ^= b[rtpHeaderSize+i]
+		}
+	}
+
+	length := int(binary.BigEndian.Uint16(bitString[2:4]))
+	if length > protectionLength {
+		// Level 0 does not cover the whole packet
+		return nil
+	}
+
+	b := make([]byte, rtpHeaderSize+length)
+	b[0] = 0x80 | (bitString[0] & 0x3f)
+	b[1] = bitString[1]
+	binary.BigEndian.PutUint16(b[2:4], missing[0])
+	copy(b[4:8], bitString[4:8])
+	binary.BigEndian.PutUint32(b[8:12], fecPkt.SSRC)
+	copy(b[rtpHeaderSize:], payload[:length])
+
+	recovered := &rtp.Packet{}
+	if err := recovered.Unmarshal(b); err != nil {
+		return nil
+	}
+	r.history[missing[0]%fecHistorySize] = b
+
+	return recovered
+}
+
+// drainFECTrack consumes a repair stream sent on its own SSRC, as with FlexFEC. Recovery from these
+// streams is not supported.
+func drainFECTrack(track *webrtc.TrackRemote) {
+	for {
+		if _, _, err := track.ReadRTP(); err != nil {
+			return
+		}
+	}
+}
+
+// fecPlaceholder keeps the sequence number space of a jitter buffer contiguous once a FEC packet
+// sharing it is removed from the stream, so that the FEC packet isn't waited for as a lost one
+func fecPlaceholder(fecPkt *rtp.Packet) *rtp.Packet {
+	h := fecPkt.Header.Clone()
+	h.Marker = false
+
+	return &rtp.Packet{Header: h}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// buildULPFEC generates a level 0 ULPFEC packet protecting all the packets passed
+func buildULPFEC(t *testing.T, pkts []*rtp.Packet, sn uint16) *rtp.Packet {
+	var bitString [8]byte
+	var protectionLength int
+	var raws [][]byte
+
+	for _, pkt := range pkts {
+		b, err := pkt.Marshal()
+		require.NoError(t, err)
+		raws = append(raws, b)
+
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(b)-rtpHeaderSize))
+		bitString[0] ^= b[0]
+		bitString[1] ^= b[1]
+		bitString[2] ^= length[0]
+		bitString[3] ^= length[1]
+		for i := 4; i < 8; i++ {
+			bitString[i] ^= b[i]
+		}
+
+		protectionLength = max(protectionLength, len(b)-rtpHeaderSize)
+	}
+
+	payload := make([]byte, ulpfecHeaderSize+ulpfecLevelHeaderShort+protectionLength)
+	payload[0] = bitString[0] & 0x3f
+	payload[1] = bitString[1]
+	binary.BigEndian.PutUint16(payload[2:4], pkts[0].SequenceNumber)
+	copy(payload[4:8], bitString[4:8])
+	copy(payload[8:10], bitString[2:4])
+	binary.BigEndian.PutUint16(payload[10:12], uint16(protectionLength))
+	for i := range pkts {
+		payload[12+i/8] |= 0x80 >> (i % 8)
+	}
+
+	for _, b := range raws {
+		for i := 0; i < len(b)-rtpHeaderSize; i++ {
+			payload[ulpfecHeaderSize+ulpfecLevelHeaderShort+i] ^= b[rtpHeaderSize+i]
+		}
+	}
+
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    ulpfecPayloadType,
+			SequenceNumber: sn,
+			Timestamp:      pkts[len(pkts)-1].Timestamp,
+			SSRC:           pkts[0].SSRC,
+		},
+		Payload: payload,
+	}
+}
+
+func TestULPFECRecover(t *testing.T) {
+	pkts := []*rtp.Packet{
+		{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 65534, Timestamp: 1000, SSRC: 1234}, Payload: []byte{1, 2, 3, 4, 5}},
+		{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 65535, Timestamp: 1000, SSRC: 1234}, Payload: []byte{6, 7}},
+		{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 0, Timestamp: 1000, SSRC: 1234, Marker: true}, Payload: []byte{8, 9, 10}},
+	}
+	fecPkt := buildULPFEC(t, pkts, 1)
+
+	t.Run("single loss", func(t *testing.T) {
+		r := &ULPFECReceiver{payloadType: ulpfecPayloadType}
+		r.PushMedia(pkts[0])
+		r.PushMedia(pkts[2])
+
+		require.True(t, r.IsFECPacket(fecPkt))
+		recovered := r.Recover(fecPkt)
+		require.NotNil(t, recovered)
+		require.Equal(t, pkts[1].Header.SequenceNumber, recovered.SequenceNumber)
+		require.Equal(t, pkts[1].Header.Timestamp, recovered.Timestamp)
+		require.Equal(t, pkts[1].Header.PayloadType, recovered.PayloadType)
+		require.Equal(t, pkts[1].Header.Marker, recovered.Marker)
+		require.Equal(t, pkts[1].Payload, recovered.Payload)
+	})
+
+	t.Run("marker recovered", func(t *testing.T) {
+		r := &ULPFECReceiver{payloadType: ulpfecPayloadType}
+		r.PushMedia(pkts[0])
+		r.PushMedia(pkts[1])
+
+		recovered := r.Recover(fecPkt)
+		require.NotNil(t, recovered)
+		require.True(t, recovered.Marker)
+		require.Equal(t, pkts[2].Payload, recovered.Payload)
+	})
+
+	t.Run("no loss", func(t *testing.T) {
+		r := &ULPFECReceiver{payloadType: ulpfecPayloadType}
+		for _, pkt := range pkts {
+			r.PushMedia(pkt)
+		}
+
+		require.Nil(t, r.Recover(fecPkt))
+	})
+
+	t.Run("multiple losses", func(t *testing.T) {
+		r := &ULPFECReceiver{payloadType: ulpfecPayloadType}
+		r.PushMedia(pkts[0])
+
+		require.Nil(t, r.Recover(fecPkt))
+	})
+}
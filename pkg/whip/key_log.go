@@ -0,0 +1,61 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Serializes writes from all the sessions sharing the key log file
+var keyLogLock sync.Mutex
+
+// keyLogWriter appends the DTLS secrets of a session to a key log file in the NSS format, which the
+// SRTP keys are derived from, so that packet captures can be decrypted. The file is opened for
+// every write so that it can be rotated or removed while the server runs.
+type keyLogWriter struct {
+	path       string
+	resourceId string
+
+	headerWritten bool
+}
+
+func newKeyLogWriter(path string, resourceId string) *keyLogWriter {
+	return &keyLogWriter{
+		path:       path,
+		resourceId: resourceId,
+	}
+}
+
+func (w *keyLogWriter) Write(p []byte) (int, error) {
+	keyLogLock.Lock()
+	defer keyLogLock.Unlock()
+
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if !w.headerWritten {
+		if _, err := fmt.Fprintf(f, "# resource %s\n", w.resourceId); err != nil {
+			return 0, err
+		}
+		w.headerWritten = true
+	}
+
+	return f.Write(p)
+}
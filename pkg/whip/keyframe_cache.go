@@ -0,0 +1,181 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/avc"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// KeyframeCache holds the most recent keyframe of a single video track, so that it can be
+// replayed to a relay that gets associated in the middle of a GOP.
+type KeyframeCache struct {
+	logger   logger.Logger
+	mimeType string
+
+	lock          sync.Mutex
+	data          []byte
+	ts            time.Duration
+	width, height uint
+}
+
+func NewKeyframeCache(logger logger.Logger, mimeType string) *KeyframeCache {
+	return &KeyframeCache{
+		logger:   logger,
+		mimeType: mimeType,
+	}
+}
+
+// Update stores the sample if it is a keyframe, and returns whether it is. Any previously cached keyframe is dropped.
+func (c *KeyframeCache) Update(data []byte, ts time.Duration) bool {
+	isKeyframe, width, height := parseKeyframe(c.mimeType, data)
+	if !isKeyframe {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.data != nil && (width != c.width || height != c.height) {
+		c.logger.Debugw("invalidating cached keyframe on resolution change", "width", width, "height", height, "previousWidth", c.width, "previousHeight", c.height)
+	}
+
+	c.data = append(c.data[:0], data...)
+	c.ts = ts
+	c.width = width
+	c.height = height
+
+	return true
+}
+
+// Get returns a copy of the cached keyframe, if any.
+func (c *KeyframeCache) Get() ([]byte, time.Duration, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.data == nil {
+		return nil, 0, false
+	}
+
+	return append([]byte(nil), c.data...), c.ts, true
+}
+
+// isKeyframePacket returns whether the RTP payload starts a keyframe
+func isKeyframePacket(mimeType string, payload []byte) bool {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		// https://datatracker.ietf.org/doc/html/rfc6184#section-5.2
+		if len(payload) < 2 {
+			return false
+		}
+		switch payload[0] & 0x1f {
+		case 24: // STAP-A
+			for b := payload[1:]; len(b) > 2; {
+				size := int(binary.BigEndian.Uint16(b))
+				if size == 0 || len(b) < 2+size {
+					return false
+				}
+				if avc.GetNaluType(b[2]) == avc.NALU_IDR {
+					return true
+				}
+				b = b[2+size:]
+			}
+			return false
+		case 28: // FU-A
+			// Start of a fragmented IDR
+			return payload[1]&0x80 != 0 && avc.GetNaluType(payload[1]) == avc.NALU_IDR
+		default:
+			return avc.GetNaluType(payload[0]) == avc.NALU_IDR
+		}
+
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		// https://datatracker.ietf.org/doc/html/rfc7741#section-4.2
+		if len(payload) < 1 || payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+			// Not the start of the first partition
+			return false
+		}
+		i := 1
+		if payload[0]&0x80 != 0 {
+			if len(payload) < 2 {
+				return false
+			}
+			ext := payload[1]
+			i++
+			if ext&0x80 != 0 {
+				if len(payload) <= i {
+					return false
+				}
+				if payload[i]&0x80 != 0 {
+					// 15 bit picture ID
+					i++
+				}
+				i++
+			}
+			if ext&0x40 != 0 {
+				i++
+			}
+			if ext&0x30 != 0 {
+				i++
+			}
+		}
+
+		return len(payload) > i && payload[i]&0x01 == 0
+
+	default:
+		return false
+	}
+}
+
+// parseKeyframe expects depacketized samples, Annex B for H264
+func parseKeyframe(mimeType string, data []byte) (bool, uint, uint) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		if len(avc.ExtractNalusOfTypeFromByteStream(avc.NALU_IDR, data, false)) == 0 {
+			return false, 0, 0
+		}
+
+		spss := avc.ExtractNalusOfTypeFromByteStream(avc.NALU_SPS, data, true)
+		if len(spss) == 0 {
+			return true, 0, 0
+		}
+		sps, err := avc.ParseSPSNALUnit(spss[0], false)
+		if err != nil {
+			return true, 0, 0
+		}
+
+		return true, sps.Width, sps.Height
+
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		// https://datatracker.ietf.org/doc/html/rfc6386#section-9.1
+		if len(data) < 10 || data[0]&0x01 != 0 {
+			return false, 0, 0
+		}
+
+		width := binary.LittleEndian.Uint16(data[6:8]) & 0x3fff
+		height := binary.LittleEndian.Uint16(data[8:10]) & 0x3fff
+
+		return true, uint(width), uint(height)
+
+	default:
+		return false, 0, 0
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// vp8Frame builds a depacketized VP8 frame of size bytes, tagged to tell frames apart
+func vp8Frame(keyframe bool, tag byte, size int) []byte {
+	data := make([]byte, size)
+	if !keyframe {
+		data[0] = 0x01
+	}
+	data[1] = tag
+	binary.LittleEndian.PutUint16(data[6:8], 640)
+	binary.LittleEndian.PutUint16(data[8:10], 480)
+
+	return data
+}
+
+func TestIsKeyframePacket(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		expected bool
+	}{
+		{"h264 idr", webrtc.MimeTypeH264, []byte{0x65, 0x88}, true},
+		{"h264 non idr", webrtc.MimeTypeH264, []byte{0x41, 0x9a}, false},
+		{"h264 stap-a sps pps idr", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce, 0x00, 0x02, 0x65, 0x88}, true},
+		{"h264 stap-a sps pps", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}, false},
+		{"h264 fu-a idr start", webrtc.MimeTypeH264, []byte{0x7c, 0x85, 0x88}, true},
+		{"h264 fu-a idr middle", webrtc.MimeTypeH264, []byte{0x7c, 0x05, 0x88}, false},
+		{"vp8 keyframe", webrtc.MimeTypeVP8, []byte{0x10, 0x00, 0x9d}, true},
+		{"vp8 keyframe with picture id", webrtc.MimeTypeVP8, []byte{0x90, 0x80, 0x81, 0x23, 0x00, 0x9d}, true},
+		{"vp8 interframe", webrtc.MimeTypeVP8, []byte{0x10, 0x01, 0x9d}, false},
+		{"vp8 continuation", webrtc.MimeTypeVP8, []byte{0x00, 0x00, 0x9d}, false},
+		{"opus", webrtc.MimeTypeOpus, []byte{0x00}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isKeyframePacket(tc.mimeType, tc.payload))
+		})
+	}
+}
+
+func TestKeyframeCache(t *testing.T) {
+	c := NewKeyframeCache(logger.GetLogger(), webrtc.MimeTypeVP8)
+
+	_, _, ok := c.Get()
+	require.False(t, ok)
+
+	require.False(t, c.Update(vp8Frame(false, 1, 20), time.Second))
+	_, _, ok = c.Get()
+	require.False(t, ok)
+
+	key := vp8Frame(true, 2, 20)
+	require.True(t, c.Update(key, 2*time.Second))
+	require.False(t, c.Update(vp8Frame(false, 3, 20), 3*time.Second))
+
+	data, ts, ok := c.Get()
+	require.True(t, ok)
+	require.Equal(t, key, data)
+	require.Equal(t, 2*time.Second, ts)
+
+	// The cached keyframe is copied
+	data[1] = 0xff
+	data, _, _ = c.Get()
+	require.Equal(t, byte(2), data[1])
+
+	require.True(t, c.Update(vp8Frame(true, 4, 20), 4*time.Second))
+	data, ts, _ = c.Get()
+	require.Equal(t, byte(4), data[1])
+	require.Equal(t, 4*time.Second, ts)
+}
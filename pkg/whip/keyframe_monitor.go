@@ -0,0 +1,93 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+	"time"
+)
+
+// keyframeMonitor measures the interval between the keyframes of a video track. While a relay is
+// associated, it tells when a keyframe should be requested because the publisher sends them too rarely.
+type keyframeMonitor struct {
+	minInterval time.Duration // 0 to never report keyframes as too frequent
+	maxInterval time.Duration // 0 to never request keyframes
+
+	lock         sync.Mutex
+	associated   bool
+	lastKeyframe time.Time
+	lastRequest  time.Time
+	interval     time.Duration
+}
+
+func newKeyframeMonitor(minInterval, maxInterval time.Duration) *keyframeMonitor {
+	return &keyframeMonitor{
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+	}
+}
+
+// SetAssociated starts or stops requesting keyframes. The maximum interval is counted from the association,
+// as the relay already requests a keyframe when started.
+func (m *keyframeMonitor) SetAssociated(associated bool, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.associated = associated
+	if associated {
+		m.lastRequest = now
+	}
+}
+
+// OnKeyframe records a keyframe and returns the interval since the previous one, 0 for the first one,
+// and whether it is below the minimum interval
+func (m *keyframeMonitor) OnKeyframe(now time.Time) (time.Duration, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var interval time.Duration
+	if !m.lastKeyframe.IsZero() {
+		interval = now.Sub(m.lastKeyframe)
+		m.interval = interval
+	}
+	m.lastKeyframe = now
+
+	return interval, interval > 0 && interval < m.minInterval
+}
+
+// ShouldRequestKeyframe returns true if a relay is associated and neither a keyframe was received
+// nor one requested within the maximum interval. The request is recorded when returning true.
+func (m *keyframeMonitor) ShouldRequestKeyframe(now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.associated || m.maxInterval == 0 {
+		return false
+	}
+	if now.Sub(m.lastKeyframe) < m.maxInterval || now.Sub(m.lastRequest) < m.maxInterval {
+		return false
+	}
+	m.lastRequest = now
+
+	return true
+}
+
+// Interval returns the last observed interval between keyframes, 0 until two were received
+func (m *keyframeMonitor) Interval() time.Duration {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.interval
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyframeMonitor(t *testing.T) {
+	m := newKeyframeMonitor(time.Second, 5*time.Second)
+	now := time.Now()
+
+	interval, tooFrequent := m.OnKeyframe(now)
+	require.Zero(t, interval)
+	require.False(t, tooFrequent)
+
+	interval, tooFrequent = m.OnKeyframe(now.Add(500 * time.Millisecond))
+	require.Equal(t, 500*time.Millisecond, interval)
+	require.True(t, tooFrequent)
+
+	now = now.Add(500 * time.Millisecond)
+	_, tooFrequent = m.OnKeyframe(now.Add(2 * time.Second))
+	require.False(t, tooFrequent)
+	require.Equal(t, 2*time.Second, m.Interval())
+	now = now.Add(2 * time.Second)
+
+	// No request without a relay
+	require.False(t, m.ShouldRequestKeyframe(now.Add(time.Minute)))
+
+	m.SetAssociated(true, now.Add(time.Second))
+	require.False(t, m.ShouldRequestKeyframe(now.Add(4*time.Second)))
+	// The interval is counted from the association
+	require.False(t, m.ShouldRequestKeyframe(now.Add(5*time.Second)))
+	require.True(t, m.ShouldRequestKeyframe(now.Add(6*time.Second)))
+
+	// Requests are throttled to one per interval
+	require.False(t, m.ShouldRequestKeyframe(now.Add(10*time.Second)))
+	require.True(t, m.ShouldRequestKeyframe(now.Add(11*time.Second)))
+
+	m.OnKeyframe(now.Add(12 * time.Second))
+	require.False(t, m.ShouldRequestKeyframe(now.Add(16*time.Second)))
+
+	m.SetAssociated(false, now.Add(16*time.Second))
+	require.False(t, m.ShouldRequestKeyframe(now.Add(time.Minute)))
+}
+
+func TestKeyframeMonitorDisabled(t *testing.T) {
+	m := newKeyframeMonitor(0, 0)
+	now := time.Now()
+
+	m.SetAssociated(true, now)
+	m.OnKeyframe(now)
+	_, tooFrequent := m.OnKeyframe(now.Add(time.Millisecond))
+	require.False(t, tooFrequent)
+	require.False(t, m.ShouldRequestKeyframe(now.Add(time.Hour)))
+}
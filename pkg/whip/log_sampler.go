@@ -0,0 +1,39 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync/atomic"
+)
+
+// logSampler selects 1 in every n requests for the per-request logs. A nil sampler selects all requests.
+type logSampler struct {
+	n     uint64
+	count atomic.Uint64
+}
+
+func newLogSampler(n int) *logSampler {
+	return &logSampler{
+		n: uint64(n),
+	}
+}
+
+func (l *logSampler) Sample() bool {
+	if l == nil || l.n <= 1 {
+		return true
+	}
+
+	return (l.count.Add(1)-1)%l.n == 0
+}
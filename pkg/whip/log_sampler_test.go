@@ -0,0 +1,33 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSampler(t *testing.T) {
+	var nilSampler *logSampler
+	require.True(t, nilSampler.Sample())
+
+	l := newLogSampler(3)
+	var sampled []bool
+	for i := 0; i < 6; i++ {
+		sampled = append(sampled, l.Sample())
+	}
+	require.Equal(t, []bool{true, false, false, true, false, false}, sampled)
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/psrpc"
+)
+
+// negotiationTracker keeps the outcomes of the negotiations over a rolling window, to report
+// the node as degraded when too many of them fail
+type negotiationTracker struct {
+	window     time.Duration
+	threshold  float64
+	minSamples int
+
+	lock     sync.Mutex
+	outcomes []negotiationOutcome // oldest first
+	degraded bool
+}
+
+type negotiationOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+func newNegotiationTracker(window time.Duration, threshold float64, minSamples int) *negotiationTracker {
+	return &negotiationTracker{
+		window:     window,
+		threshold:  threshold,
+		minSamples: minSamples,
+	}
+}
+
+func (t *negotiationTracker) Record(failed bool, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.evict(now)
+	t.outcomes = append(t.outcomes, negotiationOutcome{at: now, failed: failed})
+}
+
+// Healthy returns false if the failure rate in the window is above the threshold, along with the failure rate.
+// Fewer outcomes than the minimum sample count are always considered healthy.
+func (t *negotiationTracker) Healthy(now time.Time) (bool, float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.evict(now)
+
+	var failures int
+	for _, o := range t.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+
+	var failureRate float64
+	if len(t.outcomes) > 0 {
+		failureRate = float64(failures) / float64(len(t.outcomes))
+	}
+	degraded := len(t.outcomes) >= t.minSamples && failureRate > t.threshold
+
+	if degraded != t.degraded {
+		if degraded {
+			logger.Warnw("WHIP negotiation failure rate above threshold, reporting not ready", nil, "failureRate", failureRate, "negotiations", len(t.outcomes), "threshold", t.threshold)
+		} else {
+			logger.Infow("WHIP negotiation failure rate recovered, reporting ready", "failureRate", failureRate, "negotiations", len(t.outcomes))
+		}
+		t.degraded = degraded
+	}
+
+	return !degraded, failureRate
+}
+
+func (t *negotiationTracker) evict(now time.Time) {
+	i := 0
+	for i < len(t.outcomes) && now.Sub(t.outcomes[i].at) > t.window {
+		i++
+	}
+	t.outcomes = t.outcomes[i:]
+}
+
+// isNegotiationFailure tells apart errors caused by this node from the ones caused by the
+// client offer, the ingress configuration or capacity limits, which do not make the node unhealthy
+func isNegotiationFailure(err error) bool {
+	var psrpcErr psrpc.Error
+	if !errors.As(err, &psrpcErr) {
+		return true
+	}
+
+	switch psrpcErr.Code() {
+	case psrpc.Internal, psrpc.Unknown, psrpc.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
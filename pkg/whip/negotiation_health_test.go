@@ -0,0 +1,57 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/errors"
+)
+
+func TestNegotiationTracker(t *testing.T) {
+	tr := newNegotiationTracker(time.Minute, 0.5, 4)
+	now := time.Now()
+
+	// Below the minimum sample count
+	tr.Record(true, now)
+	tr.Record(true, now)
+	tr.Record(true, now)
+	healthy, _ := tr.Healthy(now)
+	require.True(t, healthy)
+
+	tr.Record(false, now)
+	healthy, failureRate := tr.Healthy(now)
+	require.False(t, healthy)
+	require.Equal(t, 0.75, failureRate)
+
+	// Old failures leave the window
+	for i := 0; i < 4; i++ {
+		tr.Record(false, now.Add(90*time.Second))
+	}
+	healthy, failureRate = tr.Healthy(now.Add(90 * time.Second))
+	require.True(t, healthy)
+	require.Equal(t, 0.0, failureRate)
+}
+
+func TestIsNegotiationFailure(t *testing.T) {
+	require.True(t, isNegotiationFailure(errors.New("ice failed")))
+	require.True(t, isNegotiationFailure(errors.ErrNoMediaReceived))
+	require.False(t, isNegotiationFailure(errors.ErrInvalidWHIPOffer))
+	require.False(t, isNegotiationFailure(errors.ErrUnsupportedDecodeFormat))
+	require.False(t, isNegotiationFailure(errors.ErrAppSessionLimitReached))
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/livekit/protocol/logger"
+)
+
+// peerConnectionPool keeps a few peer connections with their media engine, interceptors
+// and transceivers already set up, so that a new WHIP session does not pay the setup cost
+// while the client waits for the SDP answer.
+//
+// Pion cannot start ICE gathering before the remote offer is applied (rolling back a local
+// offer is not supported), so candidates are still gathered when the offer is received.
+type peerConnectionPool struct {
+	rtcConfig *rtcconfig.WebRTCConfig
+	conf      *config.WHIPConfig
+	size      int
+
+	lock   sync.Mutex
+	pcs    map[bool][]*webrtc.PeerConnection // keyed by transcoding enabled
+	closed bool
+
+	refill chan struct{}
+	done   chan struct{}
+}
+
+func newPeerConnectionPool(webRTCConfig *rtcconfig.WebRTCConfig, conf *config.WHIPConfig, size int) *peerConnectionPool {
+	rtcConfCopy := *webRTCConfig
+	updateSettingEngine(&rtcConfCopy.SettingEngine, conf)
+
+	p := &peerConnectionPool{
+		rtcConfig: &rtcConfCopy,
+		conf:      conf,
+		size:      size,
+		pcs:       make(map[bool][]*webrtc.PeerConnection),
+		refill:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	go p.run()
+	p.signalRefill()
+
+	return p
+}
+
+// Get returns a pooled peer connection, or nil if none is available
+func (p *peerConnectionPool) Get(transcoding bool) *webrtc.PeerConnection {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	var pc *webrtc.PeerConnection
+	if pcs := p.pcs[transcoding]; len(pcs) > 0 {
+		pc = pcs[len(pcs)-1]
+		p.pcs[transcoding] = pcs[:len(pcs)-1]
+	}
+	p.lock.Unlock()
+
+	p.signalRefill()
+
+	return pc
+}
+
+func (p *peerConnectionPool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	pcs := p.pcs
+	p.pcs = nil
+	p.lock.Unlock()
+
+	close(p.done)
+
+	for _, l := range pcs {
+		for _, pc := range l {
+			_ = pc.Close()
+		}
+	}
+}
+
+func (p *peerConnectionPool) signalRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (p *peerConnectionPool) run() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refill:
+			for _, transcoding := range []bool{false, true} {
+				p.fill(transcoding)
+			}
+		}
+	}
+}
+
+func (p *peerConnectionPool) fill(transcoding bool) {
+	for {
+		p.lock.Lock()
+		if p.closed || len(p.pcs[transcoding]) >= p.size {
+			p.lock.Unlock()
+			return
+		}
+		p.lock.Unlock()
+
+		pc, err := newPeerConnection(p.rtcConfig, p.conf, transcoding)
+		if err != nil {
+			logger.Warnw("failed creating pooled peer connection", err, "transcoding", transcoding)
+			return
+		}
+
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			_ = pc.Close()
+			return
+		}
+		p.pcs[transcoding] = append(p.pcs[transcoding], pc)
+		p.lock.Unlock()
+	}
+}
@@ -15,6 +15,7 @@
 package whip
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -93,15 +94,42 @@ func (h *WHIPRelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		close(done)
 	}()
 
+	// A failed association must not detach the relay already associated with the track
+	associated := false
 	defer func() {
 		pw.Close()
-		h.whipServer.DissociateRelay(resourceId, kind)
+		if associated {
+			h.whipServer.DissociateRelay(resourceId, kind)
+		}
 	}()
 
+	// Samples are relayed without their RTP headers, the negotiated extensions are advertised for downstream to interpret them
+	if extensions := h.whipServer.GetHeaderExtensions(resourceId, kind); len(extensions) > 0 {
+		w.Header().Set(types.HeaderExtensionsHeader, types.FormatHeaderExtensions(extensions))
+	}
+	// Relayed timestamps are already synchronized, the mapping lets downstream align with other sources.
+	// It is only available once the publisher sent a sender report.
+	if sr, ok := h.whipServer.GetSenderReport(resourceId, kind); ok {
+		w.Header().Set(types.SenderReportHeader, types.FormatSenderReport(sr))
+	}
+
+	// Set once the session ended, the handler process reporting the same end as this node
+	w.Header().Set("Trailer", types.SessionEndTrailer)
+
 	err = h.whipServer.AssociateRelay(resourceId, kind, token, pw)
 	if err != nil {
 		return
 	}
+	associated = true
 
 	err = <-done
+	if err == nil {
+		// The relay is only closed by the track stopping, along with the session
+		ctx, cancel := context.WithTimeout(r.Context(), relaySessionEndTimeout)
+		defer cancel()
+
+		if ended, endErr := h.whipServer.waitForSessionEnd(ctx, resourceId); ended {
+			w.Header().Set(types.SessionEndTrailer, types.FormatSessionEnd(types.NewSessionEnd(endErr)))
+		}
+	}
 }
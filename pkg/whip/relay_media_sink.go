@@ -16,19 +16,27 @@ package whip
 
 import (
 	"io"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v3/pkg/media"
 
+	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/utils"
 	"github.com/livekit/protocol/logger"
 )
 
 type RelayMediaSink struct {
 	mediaBuffer *utils.PrerollBuffer
+
+	// nil if keyframe replay is disabled
+	keyframeCache *KeyframeCache
+	// The frames between the replayed keyframe and the reset are lost, so the following deltas
+	// cannot be decoded and are dropped until the next keyframe
+	awaitingKeyframe atomic.Bool
 }
 
-func NewRelayMediaSink(logger logger.Logger) *RelayMediaSink {
+func NewRelayMediaSink(logger logger.Logger, keyframeCache *KeyframeCache) *RelayMediaSink {
 	mediaBuffer := utils.NewPrerollBuffer(func() error {
 		logger.Infow("preroll buffer reset event")
 
@@ -36,12 +44,24 @@ func NewRelayMediaSink(logger logger.Logger) *RelayMediaSink {
 	})
 
 	return &RelayMediaSink{
-		mediaBuffer: mediaBuffer,
+		mediaBuffer:   mediaBuffer,
+		keyframeCache: keyframeCache,
 	}
 }
 
 func (rs *RelayMediaSink) SetWriter(w io.WriteCloser) error {
-	return rs.mediaBuffer.SetWriter(w)
+	err := rs.mediaBuffer.SetWriter(w)
+	if err != nil {
+		return err
+	}
+
+	if w == nil {
+		// The preroll buffer was reset. Seed it with the last keyframe so that the next relay
+		// gets a decodable frame immediately
+		return rs.replayKeyframe(false)
+	}
+
+	return nil
 }
 
 func (rs *RelayMediaSink) Close() error {
@@ -51,5 +71,39 @@ func (rs *RelayMediaSink) Close() error {
 }
 
 func (rs *RelayMediaSink) PushSample(s *media.Sample, ts time.Duration) error {
-	return utils.SerializeMediaForRelay(rs.mediaBuffer, s.Data, ts)
+	var isKeyframe bool
+	if rs.keyframeCache != nil {
+		isKeyframe = rs.keyframeCache.Update(s.Data, ts)
+		if isKeyframe {
+			rs.awaitingKeyframe.Store(false)
+		} else if rs.awaitingKeyframe.Load() {
+			return nil
+		}
+	}
+
+	err := utils.SerializeMediaForRelay(rs.mediaBuffer, s.Data, ts)
+	if err == errors.ErrPrerollBufferReset {
+		// A keyframe was just cached, replaying it replays the sample
+		if replayErr := rs.replayKeyframe(isKeyframe); replayErr != nil {
+			return replayErr
+		}
+	}
+
+	return err
+}
+
+// replayKeyframe writes the cached keyframe to the reset preroll buffer. Unless the keyframe is the
+// sample being pushed, the deltas are dropped until the next keyframe
+func (rs *RelayMediaSink) replayKeyframe(current bool) error {
+	if rs.keyframeCache == nil {
+		return nil
+	}
+	rs.awaitingKeyframe.Store(!current)
+
+	data, ts, ok := rs.keyframeCache.Get()
+	if !ok {
+		return nil
+	}
+
+	return utils.SerializeMediaForRelay(rs.mediaBuffer, data, ts)
 }
@@ -0,0 +1,97 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/utils"
+	"github.com/livekit/protocol/logger"
+)
+
+type relayBuffer struct {
+	bytes.Buffer
+}
+
+func (b *relayBuffer) Close() error {
+	return nil
+}
+
+// relayedTags returns the tags of the frames written to the relay
+func relayedTags(t *testing.T, b *relayBuffer) []byte {
+	var tags []byte
+	for {
+		data, _, err := utils.DeserializeMediaForRelay(b)
+		if err == io.EOF {
+			return tags
+		}
+		require.NoError(t, err)
+		tags = append(tags, data[1])
+	}
+}
+
+func newTestRelayMediaSink() *RelayMediaSink {
+	return NewRelayMediaSink(logger.GetLogger(), NewKeyframeCache(logger.GetLogger(), webrtc.MimeTypeVP8))
+}
+
+func TestRelayMediaSinkReplayAfterReset(t *testing.T) {
+	rs := newTestRelayMediaSink()
+
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(true, 1, 20)}, 0))
+	// Fill the preroll buffer until it is reset
+	var err error
+	for tag := byte(2); err == nil; tag++ {
+		err = rs.PushSample(&media.Sample{Data: vp8Frame(false, tag, 3000000)}, time.Duration(tag)*time.Second)
+	}
+	require.ErrorIs(t, err, errors.ErrPrerollBufferReset)
+
+	// The deltas of the lost frames are dropped until the next keyframe
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 10, 20)}, 10*time.Second))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(true, 11, 20)}, 11*time.Second))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 12, 20)}, 12*time.Second))
+
+	b := &relayBuffer{}
+	require.NoError(t, rs.SetWriter(b))
+	require.Equal(t, []byte{1, 11, 12}, relayedTags(t, b))
+}
+
+func TestRelayMediaSinkReplayAfterDissociation(t *testing.T) {
+	rs := newTestRelayMediaSink()
+
+	b := &relayBuffer{}
+	require.NoError(t, rs.SetWriter(b))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(true, 1, 20)}, 0))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 2, 20)}, time.Second))
+	require.Equal(t, []byte{1, 2}, relayedTags(t, b))
+
+	// The next relay starts from the cached keyframe, without the deltas that do not follow it
+	require.NoError(t, rs.SetWriter(nil))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 3, 20)}, 2*time.Second))
+
+	b = &relayBuffer{}
+	require.NoError(t, rs.SetWriter(b))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 4, 20)}, 3*time.Second))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(true, 5, 20)}, 4*time.Second))
+	require.NoError(t, rs.PushSample(&media.Sample{Data: vp8Frame(false, 6, 20)}, 5*time.Second))
+	require.Equal(t, []byte{1, 5, 6}, relayedTags(t, b))
+}
@@ -50,14 +50,25 @@ type RelayWhipTrackHandler struct {
 	writePLI     func(ssrc webrtc.SSRC)
 	onRTCP       func(packet rtcp.Packet)
 
+	onFirstKeyframe func(bytesBefore, packetsBefore uint64)
+
+	// nil for audio tracks
+	keyframeMonitor     *keyframeMonitor
+	loggedFrequentFrame bool
+
 	jb        *jitter.Buffer
 	relaySink *RelayMediaSink
+	fec       *ULPFECReceiver
 
 	firstPacket sync.Once
 	fuse        core.Fuse
 	lastSn      uint16
 	lastSnValid bool
 
+	// media pushed to the jitter buffer before the first keyframe, including the keyframe
+	bytesBeforeKeyframe   uint64
+	packetsBeforeKeyframe uint64
+
 	statsLock  sync.Mutex
 	trackStats *stats.MediaTrackStatGatherer
 }
@@ -70,8 +81,12 @@ func NewRelayWhipTrackHandler(
 	receiver *webrtc.RTPReceiver,
 	writePLI func(ssrc webrtc.SSRC),
 	onRTCP func(packet rtcp.Packet),
+	replayKeyframe bool,
+	targetLatency time.Duration,
+	onFirstKeyframe func(bytesBefore, packetsBefore uint64),
+	keyframeMonitor *keyframeMonitor,
 ) (*RelayWhipTrackHandler, error) {
-	jb, err := createJitterBuffer(track, logger, writePLI)
+	jb, err := createJitterBuffer(track, logger, writePLI, targetLatency)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +94,12 @@ func NewRelayWhipTrackHandler(
 	if err != nil {
 		return nil, err
 	}
-	relaySink := NewRelayMediaSink(logger)
+
+	var keyframeCache *KeyframeCache
+	if replayKeyframe && track.Kind() == webrtc.RTPCodecTypeVideo {
+		keyframeCache = NewKeyframeCache(logger, track.Codec().MimeType)
+	}
+	relaySink := NewRelayMediaSink(logger, keyframeCache)
 
 	return &RelayWhipTrackHandler{
 		logger:       logger,
@@ -89,8 +109,13 @@ func NewRelayWhipTrackHandler(
 		relaySink:    relaySink,
 		sync:         sync,
 		jb:           jb,
+		writePLI:     writePLI,
 		onRTCP:       onRTCP,
 		depacketizer: depacketizer,
+		fec:          NewULPFECReceiver(receiver),
+
+		onFirstKeyframe: onFirstKeyframe,
+		keyframeMonitor: keyframeMonitor,
 	}, nil
 }
 
@@ -124,6 +149,10 @@ func (t *RelayWhipTrackHandler) SetMediaTrackStatsGatherer(st *stats.LocalMediaS
 }
 
 func (t *RelayWhipTrackHandler) SetWriter(w io.WriteCloser) error {
+	if t.keyframeMonitor != nil {
+		t.keyframeMonitor.SetAssociated(w != nil, time.Now())
+	}
+
 	return t.relaySink.SetWriter(w)
 }
 
@@ -217,15 +246,46 @@ func (t *RelayWhipTrackHandler) processRTPPacket() error {
 		return err
 	}
 
+	if t.fec != nil {
+		if t.fec.IsFECPacket(pkt) {
+			return t.pushFEC(pkt)
+		}
+		t.fec.PushMedia(pkt)
+	}
+
 	return t.pushRTP(pkt)
 }
 
+func (t *RelayWhipTrackHandler) pushFEC(pkt *rtp.Packet) error {
+	if recovered := t.fec.Recover(pkt); recovered != nil {
+		t.statsLock.Lock()
+		stats := t.trackStats
+		t.statsLock.Unlock()
+
+		if stats != nil {
+			stats.PacketRecovered(1)
+		}
+
+		// The jitter buffer reorders the recovered packet
+		if err := t.pushRTP(recovered); err != nil {
+			return err
+		}
+	}
+
+	return t.pushRTP(fecPlaceholder(pkt))
+}
+
 func (t *RelayWhipTrackHandler) pushRTP(pkt *rtp.Packet) error {
 	t.firstPacket.Do(func() {
 		t.logger.Debugw("first packet received")
 		t.sync.Initialize(pkt)
 	})
 
+	if t.onFirstKeyframe != nil {
+		t.bytesBeforeKeyframe += uint64(len(pkt.Payload))
+		t.packetsBeforeKeyframe++
+	}
+
 	t.jb.Push(pkt)
 
 	samples := t.jb.PopSamples(false)
@@ -276,6 +336,9 @@ func (t *RelayWhipTrackHandler) pushRTP(pkt *rtp.Packet) error {
 
 			if stats != nil {
 				stats.MediaReceived(int64(len(buf)))
+				if t.remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+					stats.FrameReceived(pkt.Timestamp)
+				}
 			}
 
 			_, err = buffer.Write(buf)
@@ -293,6 +356,27 @@ func (t *RelayWhipTrackHandler) pushRTP(pkt *rtp.Packet) error {
 			Duration: sampleDuration,
 		}
 
+		if t.onFirstKeyframe != nil || t.keyframeMonitor != nil {
+			if isKeyframe, _, _ := parseKeyframe(t.remoteTrack.Codec().MimeType, s.Data); isKeyframe {
+				if t.onFirstKeyframe != nil {
+					// Packets are counted when pushed, exclude the ones of the keyframe
+					var keyframeBytes uint64
+					for _, pkt := range pkts {
+						keyframeBytes += uint64(len(pkt.Payload))
+					}
+					t.onFirstKeyframe(t.bytesBeforeKeyframe-min(keyframeBytes, t.bytesBeforeKeyframe), t.packetsBeforeKeyframe-min(uint64(len(pkts)), t.packetsBeforeKeyframe))
+					t.onFirstKeyframe = nil
+				}
+				if t.keyframeMonitor != nil {
+					t.onKeyframe()
+				}
+			}
+		}
+		if t.keyframeMonitor != nil && t.writePLI != nil && t.keyframeMonitor.ShouldRequestKeyframe(time.Now()) {
+			t.logger.Debugw("requesting keyframe, none received within the maximum interval")
+			t.writePLI(t.remoteTrack.SSRC())
+		}
+
 		err = t.relaySink.PushSample(s, ts)
 		if err != nil {
 			return err
@@ -301,3 +385,24 @@ func (t *RelayWhipTrackHandler) pushRTP(pkt *rtp.Packet) error {
 
 	return nil
 }
+
+func (t *RelayWhipTrackHandler) onKeyframe() {
+	interval, tooFrequent := t.keyframeMonitor.OnKeyframe(time.Now())
+	if interval == 0 {
+		return
+	}
+
+	if tooFrequent && !t.loggedFrequentFrame {
+		// Logged once per track, as the publisher keeps the same keyframe interval
+		t.logger.Infow("publisher sends keyframes more often than the minimum interval", "interval", interval, "minInterval", t.keyframeMonitor.minInterval)
+		t.loggedFrequentFrame = true
+	}
+
+	t.statsLock.Lock()
+	stats := t.trackStats
+	t.statsLock.Unlock()
+
+	if stats != nil {
+		stats.KeyframeReceived(interval)
+	}
+}
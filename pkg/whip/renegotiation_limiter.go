@@ -0,0 +1,65 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+	"time"
+)
+
+const renegotiationRateWindow = time.Minute
+
+// renegotiationLimiter bounds the renegotiations of a session, such as ICE restarts, so that a
+// misbehaving client cannot renegotiate in a loop
+type renegotiationLimiter struct {
+	maxPerMinute int // 0 for no limit
+	maxTotal     int // 0 for no limit
+
+	lock   sync.Mutex
+	total  int
+	recent []time.Time // oldest first
+}
+
+func newRenegotiationLimiter(maxPerMinute int, maxTotal int) *renegotiationLimiter {
+	return &renegotiationLimiter{
+		maxPerMinute: maxPerMinute,
+		maxTotal:     maxTotal,
+	}
+}
+
+// Allow records a renegotiation and returns the number of renegotiations in the last minute
+// and whether it is within the limits. Rejected renegotiations are not counted.
+func (l *renegotiationLimiter) Allow(now time.Time) (int, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	i := 0
+	for i < len(l.recent) && now.Sub(l.recent[i]) >= renegotiationRateWindow {
+		i++
+	}
+	l.recent = l.recent[i:]
+
+	if l.maxPerMinute > 0 && len(l.recent) >= l.maxPerMinute {
+		return len(l.recent), false
+	}
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return len(l.recent), false
+	}
+
+	l.recent = append(l.recent, now)
+	l.total++
+
+	return len(l.recent), true
+}
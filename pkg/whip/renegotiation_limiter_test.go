@@ -0,0 +1,54 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenegotiationLimiter(t *testing.T) {
+	now := time.Now()
+
+	t.Run("rate", func(t *testing.T) {
+		l := newRenegotiationLimiter(2, 0)
+
+		_, ok := l.Allow(now)
+		require.True(t, ok)
+		_, ok = l.Allow(now.Add(time.Second))
+		require.True(t, ok)
+
+		count, ok := l.Allow(now.Add(2 * time.Second))
+		require.False(t, ok)
+		require.Equal(t, 2, count)
+
+		// The first renegotiation left the window
+		_, ok = l.Allow(now.Add(time.Minute))
+		require.True(t, ok)
+	})
+
+	t.Run("total", func(t *testing.T) {
+		l := newRenegotiationLimiter(0, 2)
+
+		for i := 0; i < 2; i++ {
+			_, ok := l.Allow(now.Add(time.Duration(i) * time.Hour))
+			require.True(t, ok)
+		}
+		_, ok := l.Allow(now.Add(3 * time.Hour))
+		require.False(t, ok)
+	})
+}
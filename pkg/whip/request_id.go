@@ -0,0 +1,101 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+	"github.com/livekit/psrpc/pkg/metadata"
+)
+
+const (
+	// Correlation id of a request, taken from the client when valid and generated otherwise, echoed in the response
+	requestIDHeader = "X-Request-ID"
+	// psrpc metadata key carrying the request id to the node handling the session
+	requestIDMetadataKey = "request_id"
+	requestIDPrefix      = "WHR_"
+	maxRequestIDLength   = 128
+)
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an id added to its context, so that the log lines of a request can be
+// found across the WHIP server, the session it created and the RPCs it made
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = utils.NewGuid(requestIDPrefix)
+		}
+		w.Header().Set(requestIDHeader, id)
+		addExposedHeaders(w, requestIDHeader)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// isValidRequestID only accepts the ids that are safe to log and to echo, as they come from the client
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func getRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger adds the request id of the context to the logger, if any
+func requestLogger(ctx context.Context, l logger.Logger) logger.Logger {
+	if id := getRequestID(ctx); id != "" {
+		return l.WithValues("requestID", id)
+	}
+
+	return l
+}
+
+// withRequestIDMetadata returns ctx with the request id of reqCtx in the metadata of the RPCs made with it.
+// RPCs are not made with the request context itself, so that they are not canceled when the client hangs up.
+func withRequestIDMetadata(ctx context.Context, reqCtx context.Context) context.Context {
+	if id := getRequestID(reqCtx); id != "" {
+		return metadata.AppendMetadataToOutgoingContext(ctx, requestIDMetadataKey, id)
+	}
+
+	return ctx
+}
+
+// getIncomingRequestID returns the id of the request an RPC was made for, if any
+func getIncomingRequestID(ctx context.Context) string {
+	if head := metadata.IncomingHeader(ctx); head != nil {
+		return head.Metadata[requestIDMetadataKey]
+	}
+
+	return ""
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/psrpc/pkg/metadata"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var ids []string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, getRequestID(r.Context()))
+		addExposedHeaders(w, "Location", "ETag")
+	}))
+
+	serve := func(id string) string {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		// Browsers can read the id whatever the handler exposes
+		require.Equal(t, requestIDHeader+", Location, ETag", w.Header().Get("Access-Control-Expose-Headers"))
+		return w.Header().Get(requestIDHeader)
+	}
+
+	// The client id is kept, generated otherwise
+	require.Equal(t, "client-id_1.2:3", serve("client-id_1.2:3"))
+	generated := serve("")
+	require.True(t, strings.HasPrefix(generated, requestIDPrefix))
+	require.NotEqual(t, generated, serve(""))
+	// Invalid ids are replaced
+	require.True(t, strings.HasPrefix(serve("id\nforged"), requestIDPrefix))
+	require.True(t, strings.HasPrefix(serve(strings.Repeat("a", maxRequestIDLength+1)), requestIDPrefix))
+
+	require.Len(t, ids, 5)
+	require.Equal(t, "client-id_1.2:3", ids[0])
+	require.Equal(t, generated, ids[1])
+}
+
+func TestWithRequestIDMetadata(t *testing.T) {
+	reqCtx := context.WithValue(context.Background(), requestIDKey{}, "WHR_1")
+	ctx := withRequestIDMetadata(context.Background(), reqCtx)
+	require.Equal(t, "WHR_1", metadata.OutgoingContextMetadata(ctx)[requestIDMetadataKey])
+
+	// The RPC handler gets the metadata in the incoming header
+	ctx = metadata.NewContextWithIncomingHeader(context.Background(), &metadata.Header{Metadata: metadata.OutgoingContextMetadata(ctx)})
+	require.Equal(t, "WHR_1", getIncomingRequestID(ctx))
+	require.Empty(t, getIncomingRequestID(context.Background()))
+
+	// Requests without an id add no metadata
+	require.Nil(t, metadata.OutgoingContextMetadata(withRequestIDMetadata(context.Background(), context.Background())))
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"context"
+
+	google_protobuf2 "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/rpc"
+)
+
+// resourceRPCHandler serves the RPCs of a resource with the session handling it, which changes when the
+// client reconnects. h, the session the resource was created for, serves them until it is added to the server.
+type resourceRPCHandler struct {
+	s          *WHIPServer
+	resourceId string
+	h          *whipHandler
+}
+
+func (r *resourceRPCHandler) getHandler() *whipHandler {
+	r.s.handlersLock.Lock()
+	defer r.s.handlersLock.Unlock()
+
+	if h := r.s.handlers[r.resourceId]; h != nil {
+		return h
+	}
+
+	return r.h
+}
+
+func (r *resourceRPCHandler) UpdateIngress(ctx context.Context, req *livekit.UpdateIngressRequest) (*livekit.IngressState, error) {
+	return r.getHandler().UpdateIngress(ctx, req)
+}
+
+func (r *resourceRPCHandler) DeleteIngress(ctx context.Context, req *livekit.DeleteIngressRequest) (*livekit.IngressState, error) {
+	return r.getHandler().DeleteIngress(ctx, req)
+}
+
+func (r *resourceRPCHandler) DeleteWHIPResource(ctx context.Context, req *rpc.DeleteWHIPResourceRequest) (*google_protobuf2.Empty, error) {
+	return r.getHandler().DeleteWHIPResource(ctx, req)
+}
+
+func (r *resourceRPCHandler) ICERestartWHIPResource(ctx context.Context, req *rpc.ICERestartWHIPResourceRequest) (*rpc.ICERestartWHIPResourceResponse, error) {
+	return r.getHandler().ICERestartWHIPResource(ctx, req)
+}
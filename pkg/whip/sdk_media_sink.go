@@ -68,6 +68,7 @@ type SDKMediaSink struct {
 
 	codecParameters webrtc.RTPCodecParameters
 	streamKind      types.StreamKind
+	trackLabel      string
 
 	tracksLock sync.Mutex
 	tracks     map[livekit.VideoQuality]*SDKMediaSinkTrack
@@ -81,6 +82,7 @@ func NewSDKMediaSink(
 	sdkOutput *lksdk_output.LKSDKOutput,
 	codecParameters webrtc.RTPCodecParameters,
 	streamKind types.StreamKind,
+	trackLabel string,
 	layers []livekit.VideoQuality,
 ) *SDKMediaSink {
 	s := &SDKMediaSink{
@@ -89,6 +91,7 @@ func NewSDKMediaSink(
 		sdkOutput:       sdkOutput,
 		tracks:          make(map[livekit.VideoQuality]*SDKMediaSinkTrack),
 		streamKind:      streamKind,
+		trackLabel:      trackLabel,
 		codecParameters: codecParameters,
 	}
 
@@ -120,13 +123,14 @@ func (sp *SDKMediaSink) addTrack(quality livekit.VideoQuality) {
 }
 
 func (sp *SDKMediaSink) ensureAudioTracksInitialized(pkt *rtp.Packet, t *SDKMediaSinkTrack) (bool, error) {
-	stereo := strings.Contains(sp.codecParameters.SDPFmtpLine, "sprop-stereo=1")
-	audioState := getAudioState(sp.codecParameters.MimeType, stereo, sp.codecParameters.ClockRate)
+	f := getAudioFormat(sp.codecParameters.MimeType, sp.codecParameters.ClockRate, sp.codecParameters.SDPFmtpLine)
+	stereo := f.channels == 2
+	audioState := getAudioState(sp.codecParameters.MimeType, stereo, f.sampleRate)
 	sp.params.SetInputAudioState(context.Background(), audioState, true)
 
 	sp.logger.Infow("adding audio track", "stereo", stereo, "codec", sp.codecParameters.MimeType)
 	var err error
-	t.localTrack, err = sp.sdkOutput.AddAudioTrack(sp.codecParameters.MimeType, false, stereo)
+	t.localTrack, err = sp.sdkOutput.AddAudioTrack(sp.codecParameters.MimeType, false, stereo, sp.trackLabel)
 	if err != nil {
 		return false, err
 	}
@@ -188,7 +192,7 @@ func (sp *SDKMediaSink) ensureVideoTracksInitialized(pkt *rtp.Packet, t *SDKMedi
 		sp.params.SetInputVideoState(context.Background(), videoState, true)
 	}
 
-	tracks, rtcpHandlers, err := sp.sdkOutput.AddVideoTrack(layers, sp.codecParameters.MimeType)
+	tracks, rtcpHandlers, err := sp.sdkOutput.AddVideoTrack(layers, sp.codecParameters.MimeType, sp.trackLabel)
 	if err != nil {
 		return false, err
 	}
@@ -39,12 +39,18 @@ type SDKWhipTrackHandler struct {
 	receiver         *webrtc.RTPReceiver
 	writePLI         func(ssrc webrtc.SSRC)
 	sendRTCPUpStream func(pkt rtcp.Packet)
+	onFirstKeyframe  func(bytesBefore, packetsBefore uint64)
+	fec              *ULPFECReceiver
 
 	startRTCP   sync.Once
 	fuse        core.Fuse
 	lastSn      uint16
 	lastSnValid bool
 
+	// media received before the first keyframe
+	bytesBeforeKeyframe   uint64
+	packetsBeforeKeyframe uint64
+
 	stateLock      sync.Mutex
 	trackMediaSink *SDKMediaSinkTrack
 	trackStats     *stats.MediaTrackStatGatherer
@@ -58,6 +64,7 @@ func NewSDKWhipTrackHandler(
 	receiver *webrtc.RTPReceiver,
 	writePLI func(ssrc webrtc.SSRC),
 	sendRTCPUpStream func(pkt rtcp.Packet),
+	onFirstKeyframe func(bytesBefore, packetsBefore uint64),
 ) (*SDKWhipTrackHandler, error) {
 
 	return &SDKWhipTrackHandler{
@@ -67,6 +74,8 @@ func NewSDKWhipTrackHandler(
 		receiver:         receiver,
 		writePLI:         writePLI,
 		sendRTCPUpStream: sendRTCPUpStream,
+		onFirstKeyframe:  onFirstKeyframe,
+		fec:              NewULPFECReceiver(receiver),
 	}, nil
 }
 
@@ -218,18 +227,54 @@ func (t *SDKWhipTrackHandler) processRTPPacket(trackMediaSink *SDKMediaSinkTrack
 		return err
 	}
 
+	if t.fec != nil {
+		if t.fec.IsFECPacket(pkt) {
+			return t.pushFEC(pkt, trackMediaSink)
+		}
+		t.fec.PushMedia(pkt)
+	}
+
 	return t.pushRTP(pkt, trackMediaSink)
 }
 
+func (t *SDKWhipTrackHandler) pushFEC(pkt *rtp.Packet, trackMediaSink *SDKMediaSinkTrack) error {
+	if recovered := t.fec.Recover(pkt); recovered != nil {
+		t.stateLock.Lock()
+		stats := t.trackStats
+		t.stateLock.Unlock()
+
+		if stats != nil {
+			stats.PacketRecovered(1)
+		}
+
+		// Bypass the sequence number tracking, the loss was already accounted for
+		if err := t.pushMedia(recovered, trackMediaSink); err != nil {
+			return err
+		}
+	}
+
+	// The FEC packet is not forwarded, but it shares the sequence number space of the media
+	t.trackSequenceNumber(pkt.SequenceNumber)
+
+	return nil
+}
+
 func (t *SDKWhipTrackHandler) pushRTP(pkt *rtp.Packet, trackMediaSink *SDKMediaSinkTrack) error {
+	t.trackSequenceNumber(pkt.SequenceNumber)
+
+	return t.pushMedia(pkt, trackMediaSink)
+}
+
+// trackSequenceNumber accounts for the packets lost before sn
+func (t *SDKWhipTrackHandler) trackSequenceNumber(sn uint16) {
 	t.stateLock.Lock()
 	stats := t.trackStats
 	t.stateLock.Unlock()
 
-	if t.lastSnValid && t.lastSn+1 != pkt.SequenceNumber {
-		gap := pkt.SequenceNumber - t.lastSn
-		if t.lastSn-pkt.SequenceNumber < gap {
-			gap = t.lastSn - pkt.SequenceNumber
+	if t.lastSnValid && t.lastSn+1 != sn {
+		gap := sn - t.lastSn
+		if t.lastSn-sn < gap {
+			gap = t.lastSn - sn
 		}
 		if stats != nil {
 			stats.PacketLost(int64(gap - 1))
@@ -237,10 +282,29 @@ func (t *SDKWhipTrackHandler) pushRTP(pkt *rtp.Packet, trackMediaSink *SDKMediaS
 	}
 
 	t.lastSnValid = true
-	t.lastSn = pkt.SequenceNumber
+	t.lastSn = sn
+}
+
+func (t *SDKWhipTrackHandler) pushMedia(pkt *rtp.Packet, trackMediaSink *SDKMediaSinkTrack) error {
+	t.stateLock.Lock()
+	stats := t.trackStats
+	t.stateLock.Unlock()
+
+	if t.onFirstKeyframe != nil {
+		if isKeyframePacket(t.remoteTrack.Codec().MimeType, pkt.Payload) {
+			t.onFirstKeyframe(t.bytesBeforeKeyframe, t.packetsBeforeKeyframe)
+			t.onFirstKeyframe = nil
+		} else {
+			t.bytesBeforeKeyframe += uint64(len(pkt.Payload))
+			t.packetsBeforeKeyframe++
+		}
+	}
 
 	if stats != nil {
 		stats.MediaReceived(int64(len(pkt.Payload)))
+		if t.remoteTrack.Kind() == webrtc.RTPCodecTypeVideo && len(pkt.Payload) > 0 {
+			stats.FrameReceived(pkt.Timestamp)
+		}
 	}
 
 	err := trackMediaSink.PushRTP(pkt)
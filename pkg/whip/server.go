@@ -17,12 +17,22 @@ package whip
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -32,6 +42,8 @@ import (
 	"github.com/livekit/ingress/pkg/stats"
 	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/pion/sdp/v3"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/rpc"
@@ -40,42 +52,144 @@ import (
 )
 
 const (
-	sdpResponseTimeout  = 5 * time.Second
-	sessionStartTimeout = 10 * time.Second
-	rpcTimeout          = 5 * time.Second
+	// Time allowed to the in flight requests to complete when the HTTP server is shut down
+	httpShutdownTimeout = 5 * time.Second
+	// Interval at which StopWithDrain checks whether all sessions ended
+	drainPollInterval = time.Second
+	// Time allowed to the sessions closed by Stop to report their end before the session webhook is stopped
+	sessionEndReportTimeout = 5 * time.Second
+	// Time a relay waits, once its track stopped, for the session to end and tell why
+	relaySessionEndTimeout = 5 * time.Second
+	// Time browsers may cache preflight responses, the maximum allowed by Chromium
+	corsMaxAge = 2 * time.Hour
+	// Port utilization above which a warning is logged
+	portUtilizationWarningThreshold = 0.8
+
+	// Requested jitter buffer latency target, in milliseconds
+	targetLatencyHeader = "X-Target-Latency"
+	// URL clients should publish to instead while this node drains
+	migrateToHeader = "X-Migrate-To"
+
+	// JSON list of the negotiated tracks, returned when the client accepts application/json
+	tracksHeader = "X-Ingress-Tracks"
+	// Token proving the ownership of a resource, returned on creation and required by PATCH and DELETE when enabled
+	sessionTokenHeader = "X-Ingress-Session-Token"
+	// Stable code of the failure of a request, see errors.WHIPErrorCode
+	errorCodeHeader = "X-Ingress-Error-Code"
+	// Transformations applied to produce the answer, when answer_modifications_header is set
+	modificationsHeader = "X-Ingress-Modifications"
 )
 
+// Headers set by the handlers that must not be overridden by the configured extra response headers
+var reservedResponseHeaders = []string{"Location", "ETag", "Content-Type", "Content-Length", requestIDHeader}
+
 type HealthHandlers map[string]http.HandlerFunc
 
 type WHIPServer struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-
-	conf         *config.Config
-	webRTCConfig *rtcconfig.WebRTCConfig
-	onPublish    func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error) *stats.LocalMediaStatsGatherer, func(error), error)
-	rpcClient    rpc.IngressHandlerClient
-
-	handlersLock sync.Mutex
-	handlers     map[string]*whipHandler
+	ctx        context.Context
+	cancel     context.CancelFunc
+	httpServer *http.Server
+
+	conf          *config.Config
+	webRTCConfig  *rtcconfig.WebRTCConfig
+	onPublish     func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer, func(summary *types.SessionSummary, err error), error)
+	rpcClient     rpc.IngressHandlerClient
+	pcPool        *peerConnectionPool
+	keyLimiter    *streamKeyLimiter
+	sessionTokens *sessionTokens
+	answerBuilder AnswerBuilder
+	negotiations  *negotiationTracker
+	logSampler    *logSampler
+	sessionHooks  map[string]*sessionNotifier // keyed by app, the empty app for the global webhook
+
+	promPortUtilization   prometheus.GaugeFunc
+	promSSRCCollisions    prometheus.Counter
+	promTimeToFirstFrame  *prometheus.HistogramVec
+	promICEGathering      *prometheus.HistogramVec
+	promStuckNegotiations *prometheus.CounterVec
+	promActiveSessions    prometheus.GaugeFunc
+	promNegotiation       *prometheus.HistogramVec
+	promRequests          *prometheus.CounterVec
+	promICERestarts       prometheus.Counter
+
+	portUtilizationWarned atomic.Bool
+
+	// Serializes relay associations, so that the node relay limit is not exceeded by concurrent ones
+	relayLock sync.Mutex
+
+	handlersLock          sync.Mutex
+	handlers              map[string]*whipHandler
+	offers                map[string]string           // resource ids keyed by offer key, to answer POST retries
+	negotiating           int                         // sessions whose offer is being answered, not in handlers yet
+	negotiatingStreamKeys map[string]int              // negotiating sessions keyed by stream key
+	ended                 uint64                      // sessions removed from handlers since the server started
+	deletions             map[string]*pendingDeletion // keyed by stream key
+	endedSessions         map[string]*whipHandler     // sessions ended less than ended_session_ttl ago, keyed by resource id
+	shuttingDown          bool
+	stopping              bool // no new session is accepted, Stop is called once the current ones end
+	draining              bool
+	maintenance           bool
 }
 
 func NewWHIPServer(rpcClient rpc.IngressHandlerClient) *WHIPServer {
 	return &WHIPServer{
 		rpcClient: rpcClient,
 		handlers:  make(map[string]*whipHandler),
+		offers:    make(map[string]string),
+
+		negotiatingStreamKeys: make(map[string]int),
+		deletions:             make(map[string]*pendingDeletion),
+		endedSessions:         make(map[string]*whipHandler),
 	}
 }
 
+// pendingDeletion is a session deleted by its client, closed once the grace period expires
+type pendingDeletion struct {
+	resourceId string
+	timer      *time.Timer
+}
+
+// SetAnswerBuilder replaces the default generation of the SDP answers. It must be called before Start.
+func (s *WHIPServer) SetAnswerBuilder(b AnswerBuilder) {
+	s.answerBuilder = b
+}
+
 func (s *WHIPServer) Start(
 	conf *config.Config,
-	onPublish func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error) *stats.LocalMediaStatsGatherer, func(error), error),
+	onPublish func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer, func(summary *types.SessionSummary, err error), error),
 	healthHandlers HealthHandlers,
-) error {
+) (err error) {
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
+	// A failed start is undone, so that it can be retried without the collectors being already registered
+	var registered []prometheus.Collector
+	register := func(c prometheus.Collector) error {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+		registered = append(registered, c)
+		return nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		s.cancel()
+		if s.pcPool != nil {
+			s.pcPool.Close()
+		}
+		for _, n := range s.sessionHooks {
+			n.Stop()
+		}
+		for _, c := range registered {
+			prometheus.Unregister(c)
+		}
+	}()
+
 	logger.Infow("starting WHIP server")
 
+	s.SetMaintenance(conf.WHIP.Maintenance)
+
 	if onPublish == nil {
 		return psrpc.NewErrorf(psrpc.Internal, "no onPublish callback provided")
 	}
@@ -83,160 +197,381 @@ func (s *WHIPServer) Start(
 	s.onPublish = onPublish
 	s.conf = conf
 
-	var err error
 	s.webRTCConfig, err = rtcconfig.NewWebRTCConfig(&conf.RTCConfig, conf.Development)
 	if err != nil {
 		return err
 	}
+	if !conf.WHIP.LANOnly && !isReachableBehindNAT(&conf.RTCConfig, s.webRTCConfig) {
+		if conf.WHIP.NoICEServers == config.WHIPNoICEServersFail {
+			logger.Errorw("no ICE server configured and no public address advertised, refusing to start", nil, "nodeIP", conf.RTCConfig.NodeIP)
+			return errors.ErrNoICEServers
+		}
+		logger.Warnw("no ICE server configured and no public address advertised, clients behind NAT will likely fail to connect. Set whip lan_only if clients are on the network of the node", nil, "nodeIP", conf.RTCConfig.NodeIP)
+	}
+
+	if conf.WHIP.PeerConnectionPoolSize > 0 {
+		s.pcPool = newPeerConnectionPool(s.webRTCConfig, &conf.WHIP, conf.WHIP.PeerConnectionPoolSize)
+	}
+	if conf.WHIP.MaxStreamKeysPerIP > 0 {
+		s.keyLimiter = newStreamKeyLimiter(conf.WHIP.MaxStreamKeysPerIP, conf.WHIP.StreamKeysPerIPWindow)
+	}
+	if conf.WHIP.SessionTokenSecret != "" {
+		s.sessionTokens = newSessionTokens(conf.WHIP.SessionTokenSecret, conf.WHIP.SessionTokenTTL)
+	}
+	if conf.WHIP.LogSampleRate > 1 {
+		s.logSampler = newLogSampler(conf.WHIP.LogSampleRate)
+	}
+	s.sessionHooks = newSessionNotifiers(conf)
+	if conf.WHIP.ReaperInterval > 0 {
+		go s.runReaper(conf.WHIP.ReaperInterval)
+	}
+	s.promSSRCCollisions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_ssrc_collisions",
+		ConstLabels: stats.NodeLabels(conf),
+	})
+	if err := register(s.promSSRCCollisions); err != nil {
+		return err
+	}
+	s.promTimeToFirstFrame = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_time_to_first_frame_seconds",
+		ConstLabels: stats.NodeLabels(conf),
+		Buckets:     []float64{0.25, 0.5, 1, 1.5, 2, 3, 5, 7.5, 10, 15, 30},
+	}, []string{"app", "codec"})
+	if err := register(s.promTimeToFirstFrame); err != nil {
+		return err
+	}
+	s.promICEGathering = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_ice_gathering_duration_seconds",
+		ConstLabels: stats.NodeLabels(conf),
+		Buckets:     []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 3, 5},
+	}, []string{"app"})
+	if err := register(s.promICEGathering); err != nil {
+		return err
+	}
+	s.promStuckNegotiations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_stuck_negotiations",
+		ConstLabels: stats.NodeLabels(conf),
+	}, []string{"phase"})
+	if err := register(s.promStuckNegotiations); err != nil {
+		return err
+	}
+	s.promActiveSessions = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_active_sessions",
+		ConstLabels: stats.NodeLabels(conf),
+	}, func() float64 { return float64(s.SessionCount()) })
+	if err := register(s.promActiveSessions); err != nil {
+		return err
+	}
+	s.promNegotiation = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_negotiation_duration_seconds",
+		ConstLabels: stats.NodeLabels(conf),
+		Buckets:     []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 30},
+	}, []string{"app", "result"})
+	if err := register(s.promNegotiation); err != nil {
+		return err
+	}
+	s.promRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_requests",
+		ConstLabels: stats.NodeLabels(conf),
+	}, []string{"method", "status"})
+	if err := register(s.promRequests); err != nil {
+		return err
+	}
+	s.promICERestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_ice_restarts",
+		ConstLabels: stats.NodeLabels(conf),
+	})
+	if err := register(s.promICERestarts); err != nil {
+		return err
+	}
+	if conf.RTCConfig.ICEPortRangeStart != 0 {
+		logger.Infow("WHIP media UDP port range", "portRangeStart", conf.RTCConfig.ICEPortRangeStart, "portRangeEnd", conf.RTCConfig.ICEPortRangeEnd,
+			"fallbackPortRangeStart", conf.WHIP.FallbackPortRangeStart, "fallbackPortRangeEnd", conf.WHIP.FallbackPortRangeEnd, "maxConcurrentSessions", conf.WHIP.MaxConcurrentSessions)
+
+		s.promPortUtilization = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "livekit",
+			Subsystem:   "ingress",
+			Name:        "whip_port_utilization",
+			ConstLabels: stats.NodeLabels(conf),
+		}, s.getPortUtilization)
+		if err := register(s.promPortUtilization); err != nil {
+			return err
+		}
+	}
+	if conf.WHIP.HealthFailureRateThreshold > 0 {
+		s.negotiations = newNegotiationTracker(conf.WHIP.HealthWindow, conf.WHIP.HealthFailureRateThreshold, conf.WHIP.HealthMinNegotiations)
+	}
 
 	r := mux.NewRouter()
 
-	r.HandleFunc("/{app}", func(w http.ResponseWriter, r *http.Request) {
+	handlePost := func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		defer func() {
-			s.handleError(err, w)
+			s.handleError(err, w, r)
 		}()
 
-		bearer := r.Header.Get("Authorization")
-		// OBS adds the 'Bearer' prefix as expected, but some other clients do not
-		streamKey := strings.TrimPrefix(bearer, "Bearer ")
+		streamKey, source := s.getStreamKey(r)
+		logger.Debugw("read WHIP stream key", "source", source)
 
 		err = s.handleNewWhipClient(w, r, streamKey)
-	}).Methods("POST")
-
-	r.HandleFunc("/{app}/{stream_key}", func(w http.ResponseWriter, r *http.Request) {
-		var err error
-		defer func() {
-			s.handleError(err, w)
-		}()
-
-		streamKey := mux.Vars(r)["stream_key"]
+	}
+	r.HandleFunc("/{app}", handlePost).Methods("POST")
+	r.HandleFunc("/{app}/{stream_key}", handlePost).Methods("POST")
 
-		err = s.handleNewWhipClient(w, r, streamKey)
-	}).Methods("POST")
+	r.HandleFunc("/{app}", s.handleSessionPreflight).Methods("OPTIONS")
 
-	r.HandleFunc("/{app}", func(w http.ResponseWriter, r *http.Request) {
-		setCORSHeaders(w, r, false)
-		w.WriteHeader(http.StatusNoContent)
-	}).Methods("OPTIONS")
+	r.HandleFunc("/{app}/{stream_key}", s.handleSessionPreflight).Methods("OPTIONS")
 
-	r.HandleFunc("/{app}/{stream_key}", func(w http.ResponseWriter, r *http.Request) {
-		setCORSHeaders(w, r, false)
-		w.WriteHeader(http.StatusNoContent)
-	}).Methods("OPTIONS")
+	if conf.WHIP.AllowReconnect {
+		// Reconnection with a new offer, the session of the resource is replaced
+		r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
+			s.handleError(s.handleNewWhipClient(w, r, mux.Vars(r)["stream_key"]), w, r)
+		}).Methods("PUT")
+	}
 
 	// End
 	r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		defer func() {
-			s.handleError(err, w)
+			s.handleError(err, w, r)
 		}()
 
 		vars := mux.Vars(r)
 		streamKey := vars["stream_key"]
 		resourceID := vars["resource_id"]
+		reqLogger := requestLogger(r.Context(), logger.GetLogger())
+
+		reqLogger.Infow("handling WHIP delete request", "resourceID", resourceID)
 
-		logger.Infow("handling WHIP delete request", "resourceID", resourceID)
+		if err = s.checkApp(vars["app"]); err != nil {
+			return
+		}
 
 		req := &rpc.DeleteWHIPResourceRequest{
 			ResourceId: resourceID,
 			StreamKey:  streamKey,
 		}
 
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		s.setAllowOrigin(w, r)
+
+		if err = s.checkSessionToken(r, streamKey, resourceID); err != nil {
+			return
+		}
+
+		// The summary is only available for sessions on this node, and must be gathered before the session is closed
+		var summary *types.SessionSummary
+		if s.conf.WHIP.DeleteSummary && strings.Contains(r.Header.Get("Accept"), "application/json") {
+			summary = s.getSessionSummary(resourceID)
+		}
 
-		_, err = s.rpcClient.DeleteWHIPResource(s.ctx, resourceID, req, psrpc.WithRequestTimeout(5*time.Second))
+		if s.deferDeletion(streamKey, resourceID) {
+			reqLogger.Infow("deferring WHIP session deletion", "streamKey", streamKey, "resourceID", resourceID, "gracePeriod", s.conf.WHIP.DeleteGracePeriod)
+			if summary != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(newDeleteSummary(summary))
+			}
+			return
+		}
+
+		// The handler owning the session subscribes to the resource topic on the message bus, so the
+		// RPC reaches it when the request lands on another node. No response means no node owns it.
+		start := time.Now()
+		_, err = s.rpcClient.DeleteWHIPResource(withRequestIDMetadata(s.ctx, r.Context()), resourceID, req, psrpc.WithRequestTimeout(s.conf.WHIP.GetRPCTimeout()))
+		s.logSlowRPC("DeleteWHIPResource", resourceID, time.Since(start))
 		if err == psrpc.ErrNoResponse {
 			err = errors.ErrIngressNotFound
 		}
+
+		if err == nil && summary != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(newDeleteSummary(summary))
+		}
 	}).Methods("DELETE")
 
-	// Trickle, ICE Restart unimplemented for now
+	// ICE restarts with If-Match: *, Trickle-ICE otherwise
 	r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		streamKey := vars["stream_key"]
 		resourceID := vars["resource_id"]
+		reqLogger := requestLogger(r.Context(), logger.GetLogger())
 
-		logger.Infow("handling ICE Restart request", "resourceID", resourceID)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		reqLogger.Infow("handling ICE Restart request", "resourceID", resourceID)
+		s.setAllowOrigin(w, r)
 
-		if r.Header.Get("If-Match") != "*" {
-			logger.Infow("WHIP client attempted Trickle-ICE", "streamKey", streamKey, "resourceID", resourceID)
-			w.WriteHeader(http.StatusNoContent)
-			// _, _ = w.Write([]byte("WHIP Trickle-ICE not supported"))
+		if err := s.checkApp(vars["app"]); err != nil {
+			s.handleError(err, w, r)
 			return
 		}
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Infow("WHIP ICE Restart failed to read body", "error", err, "streamKey", streamKey, "resourceID", resourceID)
-			s.handleError(errors.ErrInvalidWHIPRestartRequest, w)
+		if err := s.checkSessionToken(r, streamKey, resourceID); err != nil {
+			s.handleError(err, w, r)
+			return
+		}
+
+		// Only sent to the clients owning the session
+		if migrationURL := s.getMigrationURL(vars["app"], streamKey, resourceID); migrationURL != "" {
+			reqLogger.Infow("sending migration hint to WHIP client", "streamKey", streamKey, "resourceID", resourceID, "migrationURL", migrationURL)
+			w.Header().Set(migrateToHeader, migrationURL)
+			addExposedHeaders(w, "ETag", migrateToHeader)
+		}
+
+		if !s.iceRestartEnabled() {
+			reqLogger.Infow("WHIP client attempted ICE Restart or Trickle-ICE while disabled", "streamKey", streamKey, "resourceID", resourceID)
+			w.Header().Set("Allow", "GET, OPTIONS, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte("WHIP ICE restart and Trickle-ICE not supported"))
 			return
 		}
-		// Only extract the ufrag/pwd and candidates from the request
+
+		// The body is processed line by line and bounded, as some clients send a large number of candidates.
 		//
 		// https://www.ietf.org/archive/id/draft-ietf-wish-whip-14.html#name-ice-restarts
-		logger.Infow("WHIP ICE Restart request", "body", string(body))
-		userFragment, password, err := ScherbanExtractDetails(string(body))
+		restart := r.Header.Get("If-Match") == "*"
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !restart {
+			// Only the sessions handled by this node can be validated
+			if etag, ok := s.getSessionETag(resourceID); ok && !etagMatches(ifMatch, etag) {
+				reqLogger.Infow("WHIP PATCH request for another ICE session", "streamKey", streamKey, "resourceID", resourceID, "ifMatch", ifMatch, "etag", etag)
+				s.handleError(errors.ErrETagMismatch, w, r)
+				return
+			}
+		}
+		reqLogger.Infow("WHIP PATCH request", "streamKey", streamKey, "resourceID", resourceID, "iceRestart", restart, "contentLength", r.ContentLength)
+		body := http.MaxBytesReader(w, r.Body, s.conf.WHIP.MaxSDPFragSize)
+		frag, err := scanSDPFrag(body, s.conf.WHIP.MaxTrickleCandidates)
+		if errors.Is(err, errors.ErrSDPFragTooLarge) {
+			reqLogger.Infow("WHIP PATCH request too large", "streamKey", streamKey, "resourceID", resourceID, "maxSize", s.conf.WHIP.MaxSDPFragSize, "maxCandidates", s.conf.WHIP.MaxTrickleCandidates)
+			s.handleError(err, w, r)
+			return
+		}
 		if err != nil {
-			logger.Infow("WHIP ICE Restart failed to unmarshal SDP", "error", err, "streamKey", streamKey, "resourceID", resourceID)
-			s.handleError(errors.ErrInvalidWHIPRestartRequest, w)
+			reqLogger.Infow("WHIP PATCH request failed to parse sdpfrag", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+			s.handleError(errors.ErrInvalidWHIPRestartRequest, w, r)
+			return
+		}
+
+		if !restart {
+			if len(frag.candidates) == 0 {
+				reqLogger.Infow("WHIP Trickle-ICE request without candidates", "streamKey", streamKey, "resourceID", resourceID)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if err := s.trickleICE(r.Context(), streamKey, resourceID, frag); err != nil {
+				s.handleError(err, w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		// Only the ufrag/pwd are used for ICE restarts
+		userFragment, password := frag.ufrag, frag.pwd
 		if userFragment == "" || password == "" {
-			logger.Infow("WHIP ICE Restart failed to extract ice-ufrag/ice-pwd", "error", err, "streamKey", streamKey, "resourceID", resourceID)
-			s.handleError(errors.ErrInvalidWHIPRestartRequest, w)
+			reqLogger.Infow("WHIP ICE Restart failed to extract ice-ufrag/ice-pwd", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+			s.handleError(errors.ErrInvalidWHIPRestartRequest, w, r)
 			return
 		}
 
-		logger.Infow("Extracted Fragment and Password", "streamKey", streamKey, "resourceID", resourceID, "ufrag", userFragment, "password", password)
+		// The password is a credential of the ICE session, not logged
+		reqLogger.Infow("Extracted Fragment and Password", "streamKey", streamKey, "resourceID", resourceID, "ufrag", userFragment)
 
-		resp, err := s.rpcClient.ICERestartWHIPResource(s.ctx, resourceID, &rpc.ICERestartWHIPResourceRequest{
+		start := time.Now()
+		resp, err := s.rpcClient.ICERestartWHIPResource(withRequestIDMetadata(s.ctx, r.Context()), resourceID, &rpc.ICERestartWHIPResourceRequest{
 			UserFragment: userFragment,
 			Password:     password,
 			ResourceId:   resourceID,
 			StreamKey:    streamKey,
-		}, psrpc.WithRequestTimeout(5*time.Second))
+		}, psrpc.WithRequestTimeout(s.conf.WHIP.GetRPCTimeout()))
+		s.logSlowRPC("ICERestartWHIPResource", resourceID, time.Since(start))
 		if err == psrpc.ErrNoResponse {
-			s.handleError(errors.ErrIngressNotFound, w)
-			logger.Infow("WHIP ICE Restart failed no such session", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+			s.handleError(errors.ErrIngressNotFound, w, r)
+			reqLogger.Infow("WHIP ICE Restart failed no such session", "error", err, "streamKey", streamKey, "resourceID", resourceID)
 			return
 		}
 
 		if err != nil {
-			logger.Infow("WHIP ICE Restart failed", "error", err, "streamKey", streamKey, "resourceID", resourceID)
-			s.handleError(err, w)
+			reqLogger.Infow("WHIP ICE Restart failed", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+			s.handleError(err, w, r)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/trickle-ice-sdpfrag")
-		w.Header().Set("ETag", fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(resp.TrickleIceSdpfrag))))
+		if etag, ok := s.getSessionETag(resourceID); ok {
+			w.Header().Set("ETag", etag)
+		} else {
+			// The generation of sessions handled by other nodes is unknown
+			w.Header().Set("ETag", fmt.Sprintf(`"%08x"`, crc32.ChecksumIEEE([]byte(resp.TrickleIceSdpfrag))))
+		}
+		s.setSessionToken(w, streamKey, resourceID)
+		if s.promICERestarts != nil {
+			s.promICERestarts.Inc()
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(resp.TrickleIceSdpfrag))
 
 	}).Methods("PATCH")
 
 	r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
-		setCORSHeaders(w, r, true)
+		s.setCORSHeaders(w, r, true)
 		w.WriteHeader(http.StatusNoContent)
 	}).Methods("OPTIONS")
 
+	// Session state, for clients to learn why their session ended
+	r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		s.setAllowOrigin(w, r)
+		s.handleError(s.handleSessionStatus(w, r, vars["app"], vars["stream_key"], vars["resource_id"]), w, r)
+	}).Methods("GET")
+
 	// Expose the health endpoints on the WHIP server as well to make
 	// deployment as a k8s ingress more straightforward
 	for path, handler := range healthHandlers {
 		r.HandleFunc(path, handler).Methods("GET")
 	}
 
-	hs := &http.Server{
-		Addr:         fmt.Sprintf(":%d", conf.WHIPPort),
-		Handler:      r,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	s.httpServer = &http.Server{
+		Addr:           fmt.Sprintf(":%d", conf.WHIPPort),
+		Handler:        withRequestID(withRequestMetrics(s.promRequests, withMaxHeaderCount(conf.WHIP.MaxHeaderCount, withResponseHeaders(conf.WHIP.ExtraResponseHeaders, r)))),
+		ReadTimeout:    conf.WHIP.Timeouts.HTTPRead,
+		WriteTimeout:   conf.WHIP.Timeouts.HTTPWrite,
+		MaxHeaderBytes: conf.WHIP.MaxHeaderBytes,
+	}
+
+	if conf.WHIP.TLSCertFile != "" {
+		tlsConfig, err := newTLSConfig(&conf.WHIP)
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
 	}
 
 	go func() {
-		err := hs.ListenAndServe()
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			logger.Infow("serving WHIP over HTTPS", "port", conf.WHIPPort, "minTLSVersion", conf.WHIP.TLSMinVersion)
+			// The certificate is already loaded in the TLS config
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
 		if err != http.ErrServerClosed {
 			logger.Errorw("WHIP server start failed", err)
 		}
@@ -255,168 +590,1847 @@ func (s *WHIPServer) CloseHandler(resourceId string) {
 	}
 }
 
-func (s *WHIPServer) Stop() {
-	s.cancel()
-}
+// deferDeletion schedules the closing of a session handled by this node after the delete grace period.
+// It returns false if the session should be deleted right away.
+func (s *WHIPServer) deferDeletion(streamKey string, resourceId string) bool {
+	if s.conf.WHIP.DeleteGracePeriod <= 0 {
+		return false
+	}
 
-func (s *WHIPServer) AssociateRelay(resourceId string, kind types.StreamKind, token string, w io.WriteCloser) error {
 	s.handlersLock.Lock()
-	h, ok := s.handlers[resourceId]
-	s.handlersLock.Unlock()
-	if ok && h != nil {
-		err := h.AssociateRelay(kind, token, w)
-		if err != nil {
-			return err
+	defer s.handlersLock.Unlock()
+
+	if _, ok := s.handlers[resourceId]; !ok || s.shuttingDown {
+		return false
+	}
+	if d, ok := s.deletions[streamKey]; ok {
+		if d.resourceId == resourceId {
+			// Repeated DELETE, keep the original deadline
+			return true
 		}
-	} else {
-		return errors.ErrIngressNotFound
+		// A single session per stream key may be pending
+		d.timer.Stop()
+		go s.CloseHandler(d.resourceId)
 	}
 
-	return nil
+	d := &pendingDeletion{resourceId: resourceId}
+	d.timer = time.AfterFunc(s.conf.WHIP.DeleteGracePeriod, func() {
+		s.handlersLock.Lock()
+		if s.deletions[streamKey] == d {
+			delete(s.deletions, streamKey)
+		}
+		s.handlersLock.Unlock()
+
+		logger.Infow("closing deleted WHIP session after grace period", "streamKey", streamKey, "resourceID", resourceId)
+		s.CloseHandler(resourceId)
+	})
+	s.deletions[streamKey] = d
+
+	return true
 }
 
-func (s *WHIPServer) DissociateRelay(resourceId string, kind types.StreamKind) {
+// closePendingDeletion closes right away the session pending deletion for a stream key, if any, so that
+// a reconnecting client does not have to wait for the grace period to expire
+func (s *WHIPServer) closePendingDeletion(streamKey string) {
 	s.handlersLock.Lock()
-	h, ok := s.handlers[resourceId]
+	d, ok := s.deletions[streamKey]
+	if ok {
+		delete(s.deletions, streamKey)
+	}
 	s.handlersLock.Unlock()
-	if ok && h != nil {
-		h.DissociateRelay(kind)
+
+	if ok && d.timer.Stop() {
+		logger.Infow("closing deleted WHIP session on reconnection", "streamKey", streamKey, "resourceID", d.resourceId)
+		s.CloseHandler(d.resourceId)
 	}
 }
 
-func (s *WHIPServer) IsIdle() bool {
+// clearPendingDeletion forgets the deletion of a session that ended on its own during the grace period
+func (s *WHIPServer) clearPendingDeletion(streamKey string, resourceId string) {
 	s.handlersLock.Lock()
 	defer s.handlersLock.Unlock()
 
-	return len(s.handlers) == 0
-}
-
-func (s *WHIPServer) handleError(err error, w http.ResponseWriter) {
-	var psrpcErr psrpc.Error
-	switch {
-	case errors.As(err, &psrpcErr):
-		w.WriteHeader(psrpcErr.ToHttp())
-		_, _ = w.Write([]byte(psrpcErr.Error()))
-	case err == nil:
-		// Nothing, we already responded
-	default:
-		logger.Debugw("whip request failed", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if d, ok := s.deletions[streamKey]; ok && d.resourceId == resourceId {
+		d.timer.Stop()
+		delete(s.deletions, streamKey)
 	}
 }
 
-func (s *WHIPServer) handleNewWhipClient(w http.ResponseWriter, r *http.Request, streamKey string) error {
-	// TODO return ETAG header
-
-	vars := mux.Vars(r)
-	app := vars["app"]
+func (s *WHIPServer) Stop() {
+	s.handlersLock.Lock()
+	s.shuttingDown = true
+	deletions := s.deletions
+	s.deletions = make(map[string]*pendingDeletion)
+	s.handlersLock.Unlock()
 
-	sdpOffer := bytes.Buffer{}
+	for _, d := range deletions {
+		if d.timer.Stop() {
+			s.CloseHandler(d.resourceId)
+		}
+	}
 
-	_, err := io.Copy(&sdpOffer, r.Body)
-	if err != nil {
-		return err
+	if s.httpServer != nil {
+		ctx, done := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		if err := s.httpServer.Shutdown(ctx); err != nil {
+			logger.Infow("WHIP HTTP server shutdown incomplete", "error", err)
+		}
+		done()
 	}
 
-	logger.Debugw("new whip request", "streamKey", streamKey, "sdpOffer", sdpOffer.String(), "userAgent", r.Header.Get("User-Agent"))
+	if s.pcPool != nil {
+		s.pcPool.Close()
+	}
 
-	resourceId, sdp, err := s.createStream(streamKey, sdpOffer.String())
-	if err != nil {
-		return err
+	// The sessions end once the context is canceled, and report it to the session webhook before it is stopped
+	ending := s.getSessionDoneChans()
+	s.cancel()
+	if len(s.sessionHooks) > 0 {
+		waitSessionEnds(ending, sessionEndReportTimeout)
+	}
+	for _, n := range s.sessionHooks {
+		n.Stop()
 	}
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Location, ETag")
-	w.Header().Set("Content-Type", "application/sdp")
-	w.Header().Set("Location", fmt.Sprintf("/%s/%s/%s", app, streamKey, resourceId))
-	w.Header().Set("ETag", fmt.Sprintf("%08x", crc32.ChecksumIEEE(sdpOffer.Bytes())))
-	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write([]byte(sdp))
 
-	return nil
+	if s.promPortUtilization != nil {
+		prometheus.Unregister(s.promPortUtilization)
+	}
+	if s.promSSRCCollisions != nil {
+		prometheus.Unregister(s.promSSRCCollisions)
+	}
+	if s.promTimeToFirstFrame != nil {
+		prometheus.Unregister(s.promTimeToFirstFrame)
+	}
+	if s.promICEGathering != nil {
+		prometheus.Unregister(s.promICEGathering)
+	}
+	if s.promStuckNegotiations != nil {
+		prometheus.Unregister(s.promStuckNegotiations)
+	}
+	if s.promActiveSessions != nil {
+		prometheus.Unregister(s.promActiveSessions)
+	}
+	if s.promNegotiation != nil {
+		prometheus.Unregister(s.promNegotiation)
+	}
+	if s.promRequests != nil {
+		prometheus.Unregister(s.promRequests)
+	}
+	if s.promICERestarts != nil {
+		prometheus.Unregister(s.promICERestarts)
+	}
 }
 
-func (s *WHIPServer) createStream(streamKey string, sdpOffer string) (string, string, error) {
-	ctx, done := context.WithTimeout(s.ctx, sdpResponseTimeout)
-	defer done()
-
-	resourceId := utils.NewGuid(utils.WHIPResourcePrefix)
+// getSessionHook returns the notifier of the session webhook of the app, nil if there is none
+func (s *WHIPServer) getSessionHook(app string) *sessionNotifier {
+	if n, ok := s.sessionHooks[app]; ok {
+		return n
+	}
+	return s.sessionHooks[""]
+}
 
-	h := NewWHIPHandler(s.webRTCConfig)
+func (s *WHIPServer) getSessionDoneChans() []chan struct{} {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
 
-	p, ready, ended, err := s.onPublish(streamKey, resourceId, h)
-	if err != nil {
-		return "", "", err
+	done := make([]chan struct{}, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		if h != nil && h.done != nil {
+			done = append(done, h.done)
+		}
 	}
+	return done
+}
 
-	sdpResponse, err := h.Init(ctx, p, sdpOffer)
-	if err != nil {
-		ready(nil, err)
-		return "", "", err
+// waitSessionEnds waits for the sessions to be removed and their end reported, for at most the timeout
+func waitSessionEnds(done []chan struct{}, timeout time.Duration) {
+	deadline := time.After(timeout)
+	for i, d := range done {
+		select {
+		case <-d:
+		case <-deadline:
+			logger.Infow("WHIP sessions did not report their end before shutdown", "pending", len(done)-i)
+			return
+		}
 	}
+}
 
-	go func() {
-		ctx, done := context.WithTimeout(s.ctx, sessionStartTimeout)
-		defer done()
+// Drain starts advertising the migration URL to the clients of the sessions on this node, if one is configured,
+// so that they can reconnect elsewhere before their session is closed
+func (s *WHIPServer) Drain() {
+	s.handlersLock.Lock()
+	s.draining = true
+	s.handlersLock.Unlock()
 
-		var err error
-		var mimeTypes map[types.StreamKind]string
-		if ready != nil {
-			defer func() {
-				stats := ready(mimeTypes, err)
-				if stats != nil {
-					h.SetMediaStatsGatherer(stats)
-				}
+	st := s.GetSessionStates()
+	logger.Infow("draining WHIP server", "negotiating", st.Negotiating, "connecting", st.Connecting, "connected", st.Connected, "migrationURLTemplate", s.conf.WHIP.MigrationURLTemplate)
+}
 
-				if err != nil {
-					s.handlersLock.Lock()
-					delete(s.handlers, resourceId)
-					s.handlersLock.Unlock()
-				}
-			}()
-		}
+// StopWithDrain rejects new sessions with 503 and waits for the current ones to end, or for ctx to expire, before
+// stopping the server. The clients are sent the migration URL in the meantime, as after Drain.
+func (s *WHIPServer) StopWithDrain(ctx context.Context) {
+	s.handlersLock.Lock()
+	s.stopping = true
+	s.draining = true
+	s.handlersLock.Unlock()
 
-		s.handlersLock.Lock()
-		s.handlers[resourceId] = h
-		s.handlersLock.Unlock()
+	st := s.GetSessionStates()
+	logger.Infow("stopping WHIP server once sessions end", "negotiating", st.Negotiating, "connecting", st.Connecting, "connected", st.Connected)
 
-		mimeTypes, err = h.Start(ctx)
-		if err != nil {
-			return
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+loop:
+	for !s.IsIdle() {
+		select {
+		case <-ctx.Done():
+			logger.Infow("WHIP server drain timed out, stopping remaining sessions", "sessionCount", s.SessionCount())
+			break loop
+		case <-ticker.C:
 		}
+	}
 
-		logger.Infow("all tracks ready")
+	s.Stop()
+}
 
-		go func() {
-			var err error
-			defer func() {
-				s.handlersLock.Lock()
-				delete(s.handlers, resourceId)
-				s.handlersLock.Unlock()
+// Draining returns true once Drain or StopWithDrain was called
+func (s *WHIPServer) Draining() bool {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
 
-				if err != nil {
-					logger.Warnw("WHIP session failed", err, "streamKey", streamKey, "resourceID", resourceId)
-				}
+	return s.draining
+}
 
-				if ended != nil {
-					ended(err)
-				}
-			}()
+func (s *WHIPServer) isStopping() bool {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
 
-			err = h.WaitForSessionEnd(s.ctx)
-		}()
-	}()
+	return s.stopping || s.shuttingDown
+}
+
+// SetMaintenance toggles the maintenance mode. New sessions are rejected with 503 while it is enabled,
+// existing ones are not affected.
+func (s *WHIPServer) SetMaintenance(enabled bool) {
+	s.handlersLock.Lock()
+	changed := s.maintenance != enabled
+	s.maintenance = enabled
+	s.handlersLock.Unlock()
 
-	return resourceId, sdpResponse, nil
+	if changed {
+		logger.Infow("WHIP maintenance mode updated", "enabled", enabled)
+	}
 }
 
-func setCORSHeaders(w http.ResponseWriter, r *http.Request, resourceEndpoint bool) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	if resourceEndpoint {
-		w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS, DELETE")
+func (s *WHIPServer) InMaintenance() bool {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return s.maintenance
+}
+
+func (s *WHIPServer) AssociateRelay(resourceId string, kind types.StreamKind, token string, w io.WriteCloser) error {
+	s.relayLock.Lock()
+	defer s.relayLock.Unlock()
+
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+	if ok && h != nil {
+		// Replacing the writer of a relayed kind does not add a relay. relayLock keeps the count
+		// from growing until the association is done
+		if maxRelays := s.conf.WHIP.MaxRelays; maxRelays > 0 && w != nil && !h.IsRelayed(kind) {
+			if count := s.RelayCount(); count >= maxRelays {
+				logger.Warnw("rejecting relay association, node relay limit reached", nil, "resourceID", resourceId, "kind", kind, "relays", count, "maxRelays", maxRelays)
+				return errors.ErrRelayLimitReached
+			}
+		}
+
+		err := h.AssociateRelay(kind, token, w)
+		if err != nil {
+			return err
+		}
 	} else {
-		w.Header().Set("Accept-Post", "application/sdp")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Expose-Headers", "Location, ETag")
+		return errors.ErrIngressNotFound
+	}
+
+	return nil
+}
+
+// GetHeaderExtensions returns the RTP header extensions negotiated for a track of a local session
+func (s *WHIPServer) GetHeaderExtensions(resourceId string, kind types.StreamKind) []types.HeaderExtension {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+	if !ok || h == nil {
+		return nil
+	}
+
+	return h.GetHeaderExtensions()[kind]
+}
+
+// GetSenderReport returns the last RTP to NTP timestamp mapping received for a track of a session on this node
+func (s *WHIPServer) GetSenderReport(resourceId string, kind types.StreamKind) (types.SenderReport, bool) {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+	if !ok || h == nil {
+		return types.SenderReport{}, false
+	}
+
+	return h.GetSenderReport(kind)
+}
+
+// waitForSessionEnd waits for a session of this node to end, and returns whether it did and the reason it
+// ended, nil for a normal end
+func (s *WHIPServer) waitForSessionEnd(ctx context.Context, resourceId string) (bool, error) {
+	s.handlersLock.Lock()
+	h := s.handlers[resourceId]
+	if h == nil {
+		h = s.endedSessions[resourceId]
+	}
+	s.handlersLock.Unlock()
+	if h == nil {
+		return false, nil
+	}
+
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return false, nil
+	}
+
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return true, h.endErr
+}
+
+func (s *WHIPServer) DissociateRelay(resourceId string, kind types.StreamKind) {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+	if ok && h != nil {
+		h.DissociateRelay(kind)
+	}
+}
+
+// trickleICE forwards the candidates of a Trickle-ICE request to the handler of the session
+func (s *WHIPServer) trickleICE(reqCtx context.Context, streamKey string, resourceID string, frag *sdpFrag) error {
+	reqLogger := requestLogger(reqCtx, logger.GetLogger())
+	candidates := make([]string, 0, len(frag.candidates))
+	for _, c := range frag.candidates {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, string(b))
+	}
+
+	start := time.Now()
+	_, err := s.rpcClient.ICERestartWHIPResource(withRequestIDMetadata(s.ctx, reqCtx), resourceID, &rpc.ICERestartWHIPResourceRequest{
+		UserFragment: frag.ufrag,
+		Password:     frag.pwd,
+		ResourceId:   resourceID,
+		StreamKey:    streamKey,
+		Candidates:   candidates,
+	}, psrpc.WithRequestTimeout(s.conf.WHIP.GetRPCTimeout()))
+	s.logSlowRPC("ICERestartWHIPResource", resourceID, time.Since(start))
+	if err == psrpc.ErrNoResponse {
+		reqLogger.Infow("WHIP Trickle-ICE failed no such session", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+		return errors.ErrIngressNotFound
+	}
+	if err != nil {
+		reqLogger.Infow("WHIP Trickle-ICE failed", "error", err, "streamKey", streamKey, "resourceID", resourceID)
+		return err
+	}
+
+	return nil
+}
+
+// addHandler fails once Stop was called, as the handler would otherwise outlive the server
+func (s *WHIPServer) addHandler(resourceId string, h *whipHandler) error {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return s.addHandlerLocked(resourceId, h)
+}
+
+func (s *WHIPServer) addHandlerLocked(resourceId string, h *whipHandler) error {
+	if s.shuttingDown {
+		return errors.ErrServerShuttingDown
+	}
+	s.handlers[resourceId] = h
+	s.offers[h.offerKey] = resourceId
+
+	return nil
+}
+
+// removeHandler must be called with handlersLock held
+func (s *WHIPServer) removeHandler(resourceId string, h *whipHandler) {
+	if cur, ok := s.handlers[resourceId]; !ok || cur != h {
+		// Already removed, as by the reaper
+		return
+	}
+	delete(s.handlers, resourceId)
+	if s.offers[h.offerKey] == resourceId {
+		delete(s.offers, h.offerKey)
+	}
+	s.ended++
+}
+
+// addEndedSessionLocked keeps the ended session for ended_session_ttl, so that its client can read why it
+// ended. It must be called with handlersLock held.
+func (s *WHIPServer) addEndedSessionLocked(resourceId string, h *whipHandler, err error) {
+	h.endErr = err
+	s.endedSessions[resourceId] = h
+
+	time.AfterFunc(s.conf.WHIP.EndedSessionTTL, func() {
+		s.handlersLock.Lock()
+		defer s.handlersLock.Unlock()
+
+		if s.endedSessions[resourceId] == h {
+			delete(s.endedSessions, resourceId)
+		}
+	})
+}
+
+// startNegotiation counts a session as negotiating until completeNegotiation or endNegotiation is called.
+// It fails if the stream key already has as many sessions as allowed, the session pending deletion and the
+// session of replacedId, reconnected by the new one, not counting.
+func (s *WHIPServer) startNegotiation(streamKey string, replacedId string) error {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	if maxSessions := s.conf.WHIP.MaxSessionsPerStreamKey; maxSessions > 0 {
+		count := s.negotiatingStreamKeys[streamKey]
+		for resourceId, h := range s.handlers {
+			if d, ok := s.deletions[streamKey]; ok && d.resourceId == resourceId {
+				continue
+			}
+			if resourceId == replacedId {
+				continue
+			}
+			if h != nil && h.streamKey == streamKey {
+				count++
+			}
+		}
+		if count >= maxSessions {
+			logger.Infow("rejecting WHIP session, stream key session limit reached", "streamKey", streamKey, "sessionCount", count, "maxSessions", maxSessions)
+			return errors.ErrTooManyStreams
+		}
+	}
+
+	s.negotiating++
+	s.negotiatingStreamKeys[streamKey]++
+
+	return nil
+}
+
+// completeNegotiation adds the handler of a negotiated session, which stops being counted as negotiating
+func (s *WHIPServer) completeNegotiation(resourceId string, h *whipHandler) error {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	s.endNegotiationLocked(h.streamKey)
+	return s.addHandlerLocked(resourceId, h)
+}
+
+// endNegotiation stops counting a session that failed negotiating
+func (s *WHIPServer) endNegotiation(streamKey string) {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	s.endNegotiationLocked(streamKey)
+}
+
+func (s *WHIPServer) endNegotiationLocked(streamKey string) {
+	s.negotiating--
+	if s.negotiatingStreamKeys[streamKey]--; s.negotiatingStreamKeys[streamKey] <= 0 {
+		delete(s.negotiatingStreamKeys, streamKey)
+	}
+}
+
+// getRetriedSession returns the session handled by this node that was created from the same offer
+// to the same stream key, or nil if there is none
+func (s *WHIPServer) getRetriedSession(streamKey string, sdpOffer string) *whipHandler {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	resourceId, ok := s.offers[getOfferKey(streamKey, sdpOffer)]
+	if !ok {
+		return nil
+	}
+	return s.handlers[resourceId]
+}
+
+// getSessionETag returns the ETag of a session handled by this node
+func (s *WHIPServer) getSessionETag(resourceId string) (string, bool) {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+
+	if !ok || h == nil {
+		return "", false
+	}
+	return h.ETag(), true
+}
+
+func getOfferKey(streamKey string, sdpOffer string) string {
+	sum := sha256.Sum256([]byte(sdpOffer))
+	return streamKey + "/" + hex.EncodeToString(sum[:])
+}
+
+// etagMatches returns true if the If-Match field value lists the entity-tag. Unquoted values
+// sent by some clients are accepted
+func etagMatches(ifMatch string, etag string) bool {
+	for _, v := range strings.Split(ifMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || `"`+v+`"` == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSession starts a WHIP session from an SDP offer without going through the HTTP layer, for
+// trusted internal callers such as RPC handlers. The session follows the same lifecycle and app allowlist
+// as the ones created by a POST request. It returns the resource ID and the SDP answer.
+func (s *WHIPServer) CreateSession(app string, streamKey string, sdpOffer string) (string, string, error) {
+	receivedAt := time.Now()
+
+	if err := s.checkApp(app); err != nil {
+		return "", "", err
+	}
+	if s.isStopping() {
+		return "", "", errors.ErrServerShuttingDown
+	}
+	if s.InMaintenance() {
+		return "", "", errors.ErrMaintenance
+	}
+	if err := s.checkSessionLimit(); err != nil {
+		return "", "", err
+	}
+	if err := s.checkAppSessionLimit(app); err != nil {
+		return "", "", err
+	}
+
+	targetLatency, err := s.getTargetLatency("")
+	if err != nil {
+		return "", "", err
+	}
+
+	sampled := s.logSampler.Sample()
+	if sampled {
+		logger.Debugw("new whip session request", "app", app, "streamKey", streamKey, "sdpOffer", sdpOffer)
+	}
+
+	resourceId, sdpAnswer, _, _, err := s.createStream(s.ctx, app, streamKey, sdpOffer, targetLatency, receivedAt, nil)
+	if err != nil {
+		logger.Infow("whip session request failed", "error", err, "app", app, "streamKey", streamKey, "sdpOffer", sdpOffer)
+		return "", "", err
+	}
+	if sampled {
+		logger.Debugw("whip session request succeeded", "app", app, "streamKey", streamKey, "resourceID", resourceId)
+	}
+
+	return resourceId, sdpAnswer, nil
+}
+
+func (s *WHIPServer) checkSessionLimit() error {
+	maxSessions := s.conf.WHIP.MaxConcurrentSessions
+	if maxSessions <= 0 {
+		return nil
+	}
+
+	s.handlersLock.Lock()
+	count := len(s.handlers)
+	s.handlersLock.Unlock()
+
+	if count >= maxSessions {
+		logger.Infow("rejecting WHIP session, node session limit reached", "sessionCount", count, "maxSessions", maxSessions)
+		return errors.ErrSessionLimitReached
+	}
+
+	return nil
+}
+
+func (s *WHIPServer) checkAppSessionLimit(app string) error {
+	maxSessions := s.conf.WHIP.GetMaxSessions(app)
+	if maxSessions <= 0 {
+		return nil
+	}
+
+	if count := s.getAppSessionCount(app); count >= maxSessions {
+		logger.Infow("rejecting WHIP session, app session limit reached", "app", app, "sessionCount", count, "maxSessions", maxSessions)
+		return errors.ErrAppSessionLimitReached
+	}
+
+	return nil
+}
+
+// getSessionSummary returns the summary of a session on this node, nil if there is none
+func (s *WHIPServer) getSessionSummary(resourceId string) *types.SessionSummary {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+	if !ok || h == nil {
+		return nil
+	}
+
+	ctx, done := context.WithTimeout(s.ctx, s.conf.WHIP.GetRPCTimeout())
+	defer done()
+
+	return h.GetSessionSummary(ctx)
+}
+
+// sessionStatus is the response to GET requests on a session resource
+type sessionStatus struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	sessionStateStarting = "starting"
+	sessionStateActive   = "active"
+	sessionStateEnded    = "ended"
+)
+
+// handleSessionStatus responds with the state of the session, 200 while it runs and 410 with the reason it
+// ended, if any, for ended_session_ttl once it ended. Only the sessions of this node are known.
+func (s *WHIPServer) handleSessionStatus(w http.ResponseWriter, r *http.Request, app string, streamKey string, resourceId string) error {
+	if err := s.checkApp(app); err != nil {
+		return err
+	}
+	if err := s.checkSessionToken(r, streamKey, resourceId); err != nil {
+		return err
+	}
+
+	status := s.getSessionStatus(app, streamKey, resourceId)
+	if status == nil {
+		return errors.ErrIngressNotFound
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.State == sessionStateEnded {
+		w.WriteHeader(http.StatusGone)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+
+	return nil
+}
+
+func (s *WHIPServer) getSessionStatus(app string, streamKey string, resourceId string) *sessionStatus {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	// A reconnected session replaces the ended one under the same resource id
+	if h := s.handlers[resourceId]; h != nil {
+		if h.app != app || h.streamKey != streamKey {
+			return nil
+		}
+		if !h.started.Load() {
+			return &sessionStatus{State: sessionStateStarting}
+		}
+		return &sessionStatus{State: sessionStateActive}
+	}
+
+	h := s.endedSessions[resourceId]
+	if h == nil || h.app != app || h.streamKey != streamKey {
+		return nil
+	}
+
+	status := &sessionStatus{State: sessionStateEnded}
+	if h.endErr != nil {
+		status.Error = h.endErr.Error()
+	}
+	return status
+}
+
+// getAppSessionCount returns the number of sessions of the app. Sessions still negotiating
+// are not in the handler map yet and are not counted
+func (s *WHIPServer) getAppSessionCount(app string) int {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	var count int
+	for _, h := range s.handlers {
+		if h.app == app {
+			count++
+		}
+	}
+
+	return count
+}
+
+// getPortUtilization returns the fraction of the ICE port ranges used by the sessions on the most used local address.
+// Each host candidate of a session holds a port on its address.
+func (s *WHIPServer) getPortUtilization() float64 {
+	capacity := int(s.conf.RTCConfig.ICEPortRangeEnd) - int(s.conf.RTCConfig.ICEPortRangeStart) + 1
+	if s.conf.WHIP.FallbackPortRangeStart != 0 {
+		capacity += int(s.conf.WHIP.FallbackPortRangeEnd) - int(s.conf.WHIP.FallbackPortRangeStart) + 1
+	}
+	if capacity <= 0 {
+		return 0
+	}
+
+	counts := make(map[string]int)
+	var used int
+
+	s.handlersLock.Lock()
+	for _, h := range s.handlers {
+		for _, ip := range h.hostCandidateIPs {
+			counts[ip]++
+			used = max(used, counts[ip])
+		}
+	}
+	s.handlersLock.Unlock()
+
+	return float64(used) / float64(capacity)
+}
+
+// checkPortUtilization warns once each time the port utilization crosses portUtilizationWarningThreshold
+func (s *WHIPServer) checkPortUtilization() {
+	if s.conf.RTCConfig.ICEPortRangeStart == 0 {
+		return
+	}
+
+	utilization := s.getPortUtilization()
+	if utilization < portUtilizationWarningThreshold {
+		s.portUtilizationWarned.Store(false)
+		return
+	}
+	if !s.portUtilizationWarned.Swap(true) {
+		logger.Warnw("WHIP media UDP port range nearly exhausted", nil, "utilization", utilization,
+			"portRangeStart", s.conf.RTCConfig.ICEPortRangeStart, "portRangeEnd", s.conf.RTCConfig.ICEPortRangeEnd)
+	}
+}
+
+func (s *WHIPServer) isShuttingDown() bool {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return s.shuttingDown
+}
+
+func (s *WHIPServer) iceRestartEnabled() bool {
+	return s.conf.WHIP.EnableICERestart == nil || *s.conf.WHIP.EnableICERestart
+}
+
+// SessionCount returns the number of sessions on this node. Sessions still negotiating are not counted
+func (s *WHIPServer) SessionCount() int {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return len(s.handlers)
+}
+
+// RelayCount returns the number of relays associated across all sessions on this node
+func (s *WHIPServer) RelayCount() int {
+	s.handlersLock.Lock()
+	handlers := make([]*whipHandler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		if h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+	s.handlersLock.Unlock()
+
+	var count int
+	for _, h := range handlers {
+		count += h.RelayCount()
+	}
+
+	return count
+}
+
+// ListSessions describes the sessions of this node, sessions still negotiating not being listed
+func (s *WHIPServer) ListSessions() []SessionDescription {
+	// The handlers describe themselves after the lock is released, not to delay the negotiations
+	s.handlersLock.Lock()
+	handlers := make([]*whipHandler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		if h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+	s.handlersLock.Unlock()
+
+	sessions := make([]SessionDescription, 0, len(handlers))
+	for _, h := range handlers {
+		sessions = append(sessions, h.Describe())
+	}
+	slices.SortFunc(sessions, func(a, b SessionDescription) int { return strings.Compare(a.ResourceID, b.ResourceID) })
+
+	return sessions
+}
+
+// SessionStates are the sessions of a node by lifecycle state
+type SessionStates struct {
+	Negotiating int    // Offer received, not answered yet
+	Connecting  int    // Answered, ICE and DTLS not connected yet
+	Connected   int    // ICE and DTLS connected, including the sessions waiting for their tracks
+	Ended       uint64 // Sessions ended since the server started
+}
+
+// GetSessionStates returns the number of sessions of this node in each lifecycle state
+func (s *WHIPServer) GetSessionStates() SessionStates {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	st := SessionStates{
+		Negotiating: s.negotiating,
+		Ended:       s.ended,
+	}
+	for _, h := range s.handlers {
+		if h != nil && h.IsConnected() {
+			st.Connected++
+		} else {
+			st.Connecting++
+		}
+	}
+
+	return st
+}
+
+// IsIdle returns true once no session is negotiating, connecting or connected
+func (s *WHIPServer) IsIdle() bool {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	return len(s.handlers) == 0 && s.negotiating == 0
+}
+
+func (s *WHIPServer) logSlowRPC(method string, resourceID string, elapsed time.Duration) {
+	timeout := s.conf.WHIP.GetRPCTimeout()
+	threshold := time.Duration(float64(timeout) * s.conf.WHIP.SlowRPCThreshold)
+	if elapsed > threshold {
+		logger.Warnw("slow WHIP RPC call", nil, "method", method, "resourceID", resourceID, "elapsed", elapsed, "timeout", timeout)
+	}
+}
+
+// errorResponse is the body of failed requests accepting JSON. The code is the stable one of the
+// X-Ingress-Error-Code header.
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *WHIPServer) handleError(err error, w http.ResponseWriter, r *http.Request) {
+	if err == nil {
+		// Nothing, we already responded
+		return
+	}
+
+	code := errors.WHIPErrorCode(err)
+	w.Header().Set(errorCodeHeader, code)
+	addExposedHeaders(w, errorCodeHeader)
+
+	var psrpcErr psrpc.Error
+	var status int
+	var message string
+	switch {
+	case errors.Is(err, errors.ErrRoomFull), errors.Is(err, errors.ErrAppSessionLimitReached):
+		errors.As(err, &psrpcErr)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.conf.WHIP.RoomFullRetryAfter.Seconds()))))
+		status, message = psrpcErr.ToHttp(), psrpcErr.Error()
+	case errors.Is(err, errors.ErrSessionLimitReached), errors.Is(err, errors.ErrNoAvailablePorts), errors.Is(err, errors.ErrMaintenance):
+		// The node itself cannot take the session, the client may retry sooner or later than for a full room
+		errors.As(err, &psrpcErr)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.conf.WHIP.UnavailableRetryAfter.Seconds()))))
+		status, message = psrpcErr.ToHttp(), psrpcErr.Error()
+	case errors.Is(err, errors.ErrSDPFragTooLarge):
+		status, message = http.StatusRequestEntityTooLarge, errors.ErrSDPFragTooLarge.Error()
+	case errors.Is(err, errors.ErrOfferTooLarge):
+		status, message = http.StatusRequestEntityTooLarge, errors.ErrOfferTooLarge.Error()
+	case errors.Is(err, errors.ErrHostNotAllowed):
+		status, message = http.StatusMisdirectedRequest, errors.ErrHostNotAllowed.Error()
+	case errors.As(err, &psrpcErr):
+		status, message = psrpcErr.ToHttp(), psrpcErr.Error()
+	default:
+		logger.Debugw("whip request failed", "error", err)
+		// The error is not returned to the client
+		status = http.StatusInternalServerError
+	}
+
+	if r != nil && acceptsJSON(r.Header.Get("Accept")) {
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+		return
+	}
+
+	w.WriteHeader(status)
+	if message != "" {
+		_, _ = w.Write([]byte(message))
+	}
+}
+
+func (s *WHIPServer) handleNewWhipClient(w http.ResponseWriter, r *http.Request, streamKey string) (err error) {
+	receivedAt := time.Now()
+	vars := mux.Vars(r)
+	app := vars["app"]
+	reqLogger := requestLogger(r.Context(), logger.GetLogger())
+
+	// Failures are always logged, successful requests only when sampled
+	sampled := s.logSampler.Sample()
+	var resourceId string
+	sdpOffer := bytes.Buffer{}
+	defer func() {
+		if err != nil {
+			reqLogger.Infow("whip request failed", "error", err, "app", app, "streamKey", streamKey, "sdpOffer", sdpOffer.String(), "userAgent", r.Header.Get("User-Agent"))
+		} else if sampled {
+			reqLogger.Debugw("whip request succeeded", "app", app, "streamKey", streamKey, "resourceID", resourceId)
+		}
+	}()
+
+	// Before any RPC, unknown apps are not worth more work
+	if err := s.checkApp(app); err != nil {
+		return err
+	}
+	if err := s.checkHost(r.Host); err != nil {
+		return err
+	}
+
+	if s.isStopping() {
+		return errors.ErrServerShuttingDown
+	}
+	if s.InMaintenance() {
+		return errors.ErrMaintenance
+	}
+
+	// Native clients do not send a preflight, so the origin is enforced on the POST itself
+	if err := s.checkOrigin(r.Header.Get("Origin")); err != nil {
+		return err
+	}
+
+	if s.keyLimiter != nil {
+		clientIP := getClientIP(r)
+		if count, ok := s.keyLimiter.Allow(clientIP, streamKey, time.Now()); !ok {
+			reqLogger.Infow("rejecting WHIP request, too many stream keys attempted", "clientIP", clientIP, "streamKeyCount", count)
+			return errors.ErrTooManyStreamKeys
+		}
+	}
+
+	// A reconnection replaces its session, it does not add one
+	reconnectedId := vars["resource_id"]
+	if reconnectedId != "" {
+		if err := s.checkSessionToken(r, streamKey, reconnectedId); err != nil {
+			return err
+		}
+	} else {
+		if err := s.checkSessionLimit(); err != nil {
+			return err
+		}
+		if err := s.checkAppSessionLimit(app); err != nil {
+			return err
+		}
+	}
+
+	maxOfferSize := s.conf.WHIP.GetMaxOfferSize()
+	if r.ContentLength > maxOfferSize {
+		// Clients waiting for 100 Continue do not send the body
+		reqLogger.Infow("WHIP request body too large", "contentLength", r.ContentLength, "maxSize", maxOfferSize)
+		return errors.ErrOfferTooLarge
+	}
+
+	s.sendContinue(w, r)
+
+	n, err := io.Copy(&sdpOffer, http.MaxBytesReader(w, r.Body, maxOfferSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		reqLogger.Infow("WHIP request body too large", "contentLength", r.ContentLength, "maxSize", maxOfferSize)
+		return errors.ErrOfferTooLarge
+	}
+	if r.ContentLength >= 0 && (n != r.ContentLength || errors.Is(err, io.ErrUnexpectedEOF)) {
+		// The offer is truncated, it would otherwise fail SDP parsing for no apparent reason
+		reqLogger.Infow("WHIP request body does not match Content-Length", "contentLength", r.ContentLength, "bodyLength", n, "userAgent", r.Header.Get("User-Agent"))
+		return errors.ErrContentLengthMismatch
+	}
+	if err != nil {
+		return psrpc.NewError(psrpc.InvalidArgument, fmt.Errorf("%w: %w", errors.ErrRequestBodyRead, err))
+	}
+
+	if sampled {
+		reqLogger.Debugw("new whip request", "streamKey", streamKey, "sdpOffer", sdpOffer.String(), "userAgent", r.Header.Get("User-Agent"))
+	}
+
+	targetLatency, err := s.getTargetLatency(r.Header.Get(targetLatencyHeader))
+	if err != nil {
+		return err
+	}
+
+	var sdp, etag string
+	var modifications []string
+	status := http.StatusCreated
+	var retried *whipHandler
+	if reconnectedId == "" {
+		// A reconnection always negotiates its offer, even one the session was created from
+		retried = s.getRetriedSession(streamKey, sdpOffer.String())
+	}
+	if h := retried; h != nil {
+		// The client did not get the answer of its first request, the session is not duplicated
+		reqLogger.Infow("WHIP request retried, returning the existing session", "streamKey", streamKey, "resourceID", h.resourceId)
+		resourceId, sdp, targetLatency, modifications, etag = h.resourceId, h.sdpAnswer, h.targetLatency, h.modifications, h.ETag()
+		status = http.StatusOK
+	} else {
+		var replaced *whipHandler
+		if reconnectedId != "" {
+			if replaced, err = s.getReplacedSession(app, streamKey, reconnectedId); err != nil {
+				return err
+			}
+			status = http.StatusOK
+		}
+
+		resourceId, sdp, targetLatency, modifications, err = s.createStream(r.Context(), app, streamKey, sdpOffer.String(), targetLatency, receivedAt, replaced)
+		if err != nil {
+			return err
+		}
+		etag = sessionETag(resourceId, 0)
+		if replaced != nil {
+			etag = sessionETag(resourceId, replaced.generation.Load()+1)
+		}
+	}
+	s.setAllowOrigin(w, r)
+	w.Header().Set("Content-Type", "application/sdp")
+	// The path elements are escaped, as they were decoded from the request path or read from a header
+	w.Header().Set("Location", fmt.Sprintf("/%s/%s/%s", url.PathEscape(app), url.PathEscape(streamKey), url.PathEscape(resourceId)))
+	addExposedHeaders(w, "Location", "ETag", "Link", targetLatencyHeader)
+	if whepURL := s.getWHEPURL(app, streamKey); whepURL != "" {
+		addExposedHeaders(w, "X-WHEP-URL")
+		w.Header().Set("X-WHEP-URL", whepURL)
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="alternate"; type="application/sdp"`, whepURL))
+	}
+	s.addICEServerLinks(w)
+	w.Header().Set("ETag", etag)
+	if targetLatency > 0 {
+		w.Header().Set(targetLatencyHeader, strconv.FormatInt(targetLatency.Milliseconds(), 10))
+	}
+	s.setSessionToken(w, streamKey, resourceId)
+	// The body must be the SDP answer, so the track summary is returned in a header
+	if acceptsJSON(r.Header.Get("Accept")) {
+		if tracks, err := getTracksHeader(sdpOffer.String(), sdp); err != nil {
+			reqLogger.Warnw("failed listing negotiated tracks", err, "resourceID", resourceId)
+		} else {
+			w.Header().Set(tracksHeader, tracks)
+			addExposedHeaders(w, tracksHeader)
+		}
+	}
+	if len(modifications) > 0 {
+		w.Header().Set(modificationsHeader, strings.Join(modifications, ", "))
+		addExposedHeaders(w, modificationsHeader)
+	}
+	// The response cannot wait for ICE to connect: the client needs the ICE credentials and candidates
+	// of the answer to start the connectivity checks, and nominates the candidate pair as the controlling
+	// agent. Clients learn that the session is live from its status or the session webhook instead.
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(sdp))
+
+	return nil
+}
+
+// getStreamKey returns the stream key of a session creation request and where it was read from. The Authorization
+// header takes precedence over the path, which takes precedence over the query parameter, for clients that cannot
+// set headers cross-origin.
+func (s *WHIPServer) getStreamKey(r *http.Request) (string, string) {
+	// OBS adds the 'Bearer' prefix as expected, but some other clients do not
+	if streamKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); streamKey != "" {
+		return streamKey, "header"
+	}
+	if streamKey := mux.Vars(r)["stream_key"]; streamKey != "" {
+		return streamKey, "path"
+	}
+	if param := s.conf.WHIP.StreamKeyQueryParam; param != "" {
+		if streamKey := r.URL.Query().Get(param); streamKey != "" {
+			return streamKey, "query"
+		}
+	}
+
+	return "", "none"
+}
+
+// setSessionToken returns a new session token for the resource, if enabled
+func (s *WHIPServer) setSessionToken(w http.ResponseWriter, streamKey string, resourceId string) {
+	if s.sessionTokens == nil {
+		return
+	}
+
+	w.Header().Set(sessionTokenHeader, s.sessionTokens.Issue(resourceId, streamKey, time.Now()))
+	addExposedHeaders(w, sessionTokenHeader)
+}
+
+// checkSessionToken verifies the session token of a request operating on a resource, if enabled
+func (s *WHIPServer) checkSessionToken(r *http.Request, streamKey string, resourceId string) error {
+	if s.sessionTokens == nil {
+		return nil
+	}
+
+	if !s.sessionTokens.Verify(r.Header.Get(sessionTokenHeader), resourceId, streamKey, time.Now()) {
+		logger.Infow("rejecting WHIP request with invalid session token", "method", r.Method, "streamKey", streamKey, "resourceID", resourceId, "hasToken", r.Header.Get(sessionTokenHeader) != "")
+		return errors.ErrInvalidSessionToken
+	}
+
+	return nil
+}
+
+// sendContinue sends 100 Continue right away to requests with Expect: 100-continue, and gives the client
+// body_read_timeout from then on to send the body. Go otherwise only sends it on the first body read, with
+// the time the client waited for it counted against the server read timeout.
+func (s *WHIPServer) sendContinue(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return
+	}
+
+	// The server writes 100 Continue on the first read of the body, even an empty one
+	_, _ = r.Body.Read(nil)
+
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(s.conf.WHIP.BodyReadTimeout)); err != nil {
+		logger.Debugw("failed setting WHIP request body read deadline", "error", err)
+	}
+}
+
+// handleSessionPreflight answers the CORS preflight of a session creation, rejecting the origins the POST would be rejected for
+func (s *WHIPServer) handleSessionPreflight(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if err := s.checkOrigin(origin); err != nil {
+			s.handleError(err, w, r)
+			return
+		}
+	}
+
+	s.setCORSHeaders(w, r, false)
+	s.addICEServerLinks(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkOrigin returns an error if a session creation request with this Origin header value is not allowed.
+// An empty origin is sent by native clients.
+func (s *WHIPServer) checkOrigin(origin string) error {
+	if origin == "" {
+		if s.conf.WHIP.RejectMissingOrigin {
+			logger.Infow("rejecting WHIP request without origin")
+			return errors.ErrOriginNotAllowed
+		}
+		return nil
+	}
+
+	if len(s.conf.WHIP.AllowedOrigins) > 0 && !slices.ContainsFunc(s.conf.WHIP.AllowedOrigins, func(o string) bool { return strings.EqualFold(o, origin) }) {
+		logger.Infow("rejecting WHIP request from origin not allowed", "origin", origin)
+		return errors.ErrOriginNotAllowed
+	}
+
+	return nil
+}
+
+// checkApp rejects the apps that are not allowed with 404, as if their endpoint did not exist
+func (s *WHIPServer) checkApp(app string) error {
+	if len(s.conf.WHIP.AllowedApps) == 0 || slices.Contains(s.conf.WHIP.AllowedApps, app) {
+		return nil
+	}
+
+	return errors.ErrAppNotAllowed
+}
+
+// checkHost returns an error if host names are restricted and the request Host is not one of them.
+// Entries without a port match any port, and entries starting with "*." match any subdomain.
+func (s *WHIPServer) checkHost(host string) error {
+	if len(s.conf.WHIP.AllowedHosts) == 0 {
+		return nil
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, allowed := range s.conf.WHIP.AllowedHosts {
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, hostname) {
+			return nil
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasPrefix(suffix, ".") && len(hostname) > len(suffix) &&
+			strings.EqualFold(hostname[len(hostname)-len(suffix):], suffix) {
+			return nil
+		}
+	}
+
+	logger.Infow("rejecting WHIP request for host not allowed", "host", host)
+	return errors.ErrHostNotAllowed
+}
+
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getTargetLatency returns the requested latency target in milliseconds clamped to the configured
+// bounds, 0 if there is none. The configured default is used if requested is empty.
+func (s *WHIPServer) getTargetLatency(requested string) (time.Duration, error) {
+	targetLatency := s.conf.WHIP.TargetLatency
+	if requested != "" {
+		ms, err := strconv.ParseUint(requested, 10, 32)
+		if err != nil {
+			return 0, errors.ErrInvalidTargetLatency
+		}
+		targetLatency = time.Duration(ms) * time.Millisecond
+	}
+
+	if targetLatency <= 0 {
+		return 0, nil
+	}
+
+	return min(max(targetLatency, s.conf.WHIP.MinTargetLatency), s.conf.WHIP.MaxTargetLatency), nil
+}
+
+// getTracksHeader returns the tracksHeader value for the tracks negotiated by the answer
+func getTracksHeader(offer string, answer string) (string, error) {
+	parsedOffer := &sdp.SessionDescription{}
+	if err := parsedOffer.UnmarshalString(offer); err != nil {
+		return "", err
+	}
+	parsedAnswer := &sdp.SessionDescription{}
+	if err := parsedAnswer.UnmarshalString(answer); err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(getNegotiatedTracks(parsedOffer, parsedAnswer))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// createStream negotiates a new session. The negotiation is abandoned if reqCtx is done, as the client
+// hung up, but the session outlives the request once the answer is returned. receivedAt is the time the
+// request was received, for the time to first frame metric. A session reconnecting replaced takes over its
+// resource id and ingress once negotiated, replaced is left untouched if the negotiation fails.
+func (s *WHIPServer) createStream(reqCtx context.Context, app string, streamKey string, sdpOffer string, targetLatency time.Duration, receivedAt time.Time, replaced *whipHandler) (string, string, time.Duration, []string, error) {
+	ctx, done := context.WithTimeout(reqCtx, s.conf.WHIP.GetSDPResponseTimeout(app))
+	defer done()
+	stop := context.AfterFunc(s.ctx, done)
+	defer stop()
+
+	if s.isShuttingDown() {
+		return "", "", 0, nil, errors.ErrServerShuttingDown
+	}
+
+	resourceId := utils.NewGuid(utils.WHIPResourcePrefix)
+	var replacedId string
+	if replaced != nil {
+		resourceId, replacedId = replaced.resourceId, replaced.resourceId
+	}
+
+	if err := s.startNegotiation(streamKey, replacedId); err != nil {
+		return "", "", 0, nil, err
+	}
+	negotiating := true
+	defer func() {
+		if negotiating {
+			s.endNegotiation(streamKey)
+		}
+	}()
+
+	// The replaced session may be the one pending deletion, it is only closed once the new one is negotiated
+	if replaced == nil {
+		s.closePendingDeletion(streamKey)
+	}
+
+	h := NewWHIPHandler(s.webRTCConfig, s.pcPool, s.answerBuilder, app)
+	h.resourceId = resourceId
+	if replaced != nil {
+		// ETags of the replaced session do not match the new one
+		h.generation.Store(replaced.generation.Load() + 1)
+	}
+	h.streamKey = streamKey
+	h.offerKey = getOfferKey(streamKey, sdpOffer)
+	h.requestReceivedAt = receivedAt
+	h.connectionLabel = expandStatsLabelTemplate(s.conf.WHIP.StatsLabelTemplate, app, streamKey, resourceId)
+	if s.promTimeToFirstFrame != nil {
+		h.onTimeToFirstFrame = func(mimeType string, d time.Duration) {
+			s.promTimeToFirstFrame.WithLabelValues(app, mimeType).Observe(d.Seconds())
+		}
+	}
+
+	// Apps may have their own session webhook
+	sessionHook := s.getSessionHook(app)
+	// Carries the request id into the logs of the session goroutine, which outlives the request
+	sessionLogger := requestLogger(reqCtx, logger.GetLogger()).WithValues("app", app, "streamKey", streamKey, "resourceID", resourceId)
+
+	var p *params.Params
+	var ready func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer
+	var ended func(summary *types.SessionSummary, err error)
+	if replaced != nil {
+		// The ingress is not published again, so that the participant keeps its identity
+		p, ended = replaced.params, replaced.onEnded
+	} else {
+		var err error
+		p, ready, ended, err = s.onPublish(streamKey, resourceId, &resourceRPCHandler{s: s, resourceId: resourceId, h: h})
+		if err != nil {
+			return "", "", 0, nil, classifyPublishError(err)
+		}
+		if p == nil {
+			// The handler cannot run without its parameters, fail the negotiation rather than panic
+			sessionLogger.Errorw("onPublish returned no parameters", nil)
+			if ready != nil {
+				ready(nil, nil, nil, errors.ErrMissingPublishParams)
+			}
+			return "", "", 0, nil, errors.ErrMissingPublishParams
+		}
+	}
+	h.onEnded = ended
+
+	// A failed reconnection leaves the replaced session, and the ingress it publishes, untouched
+	failPublish := func(err error) {
+		if replaced == nil {
+			ready(nil, nil, nil, err)
+		}
+	}
+
+	if reqCtx.Err() != nil {
+		// The client hung up while the session was being published
+		sessionLogger.Infow("client disconnected before WHIP negotiation")
+		failPublish(errors.ErrClientDisconnected)
+		return "", "", 0, nil, errors.ErrClientDisconnected
+	}
+
+	stopWatch := watchPhase(sessionLogger, "init", s.conf.WHIP.GetSDPResponseTimeout(app)+s.conf.WHIP.WatchdogGracePeriod, s.onStuckNegotiation)
+	initStart := time.Now()
+	sdpResponse, err := h.Init(ctx, p, sdpOffer, targetLatency)
+	stopWatch()
+	if err != nil && reqCtx.Err() != nil {
+		sessionLogger.Infow("client disconnected during WHIP negotiation", "error", err)
+		err = errors.ErrClientDisconnected
+	}
+	if s.promNegotiation != nil {
+		s.promNegotiation.WithLabelValues(app, negotiationResult(ctx, err)).Observe(time.Since(initStart).Seconds())
+	}
+	h.sdpAnswer = sdpResponse
+	if h.ssrcCollisions > 0 && s.promSSRCCollisions != nil {
+		s.promSSRCCollisions.Add(float64(h.ssrcCollisions))
+	}
+	if h.iceGathering > 0 && s.promICEGathering != nil {
+		s.promICEGathering.WithLabelValues(app).Observe(h.iceGathering.Seconds())
+	}
+	if err != nil {
+		s.recordNegotiation(err)
+		failPublish(err)
+		return "", "", 0, nil, err
+	}
+
+	if replaced != nil {
+		// ICE restarts and reconnections share the limits of the resource
+		if replaced.renegotiations != nil {
+			h.renegotiations = replaced.renegotiations
+		}
+		if err = s.handOver(replaced, h); err != nil {
+			h.Close()
+			return "", "", 0, nil, err
+		}
+
+		// The ingress was made ready by the replaced session, the new one only reports it ending if it fails to start
+		ready = func(_ map[types.StreamKind]string, _ map[types.StreamKind]string, _ map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer {
+			if err != nil {
+				if ended != nil {
+					ended(nil, err)
+				}
+				event := newSessionEvent(SessionEventEnded, h, s.conf)
+				event.Error = err.Error()
+				sessionHook.Notify(event)
+			}
+
+			replaced.trackLock.Lock()
+			defer replaced.trackLock.Unlock()
+
+			return replaced.stats
+		}
+	}
+
+	// The session goroutine ends the negotiation once the handler is added
+	negotiating = false
+	go func() {
+		ctx, done := context.WithTimeout(s.ctx, s.conf.WHIP.GetSessionStartTimeout(app))
+		defer done()
+
+		var err error
+		var mimeTypes, trackLabels map[types.StreamKind]string
+		var headerExtensions map[types.StreamKind][]types.HeaderExtension
+		if ready != nil {
+			defer func() {
+				stats := ready(mimeTypes, trackLabels, headerExtensions, err)
+				if stats != nil {
+					h.SetMediaStatsGatherer(stats)
+				}
+
+				if err != nil {
+					s.handlersLock.Lock()
+					s.removeHandler(resourceId, h)
+					s.addEndedSessionLocked(resourceId, h, err)
+					s.handlersLock.Unlock()
+					close(h.done)
+				}
+			}()
+		}
+
+		if err = s.completeNegotiation(resourceId, h); err != nil {
+			sessionLogger.Infow("not starting WHIP session, server shutting down")
+			h.Close()
+			return
+		}
+		s.checkPortUtilization()
+
+		stopWatch := watchPhase(sessionLogger, "start", s.conf.WHIP.GetSessionStartTimeout(app)+s.conf.WHIP.WatchdogGracePeriod, s.onStuckNegotiation)
+		mimeTypes, trackLabels, err = h.Start(ctx)
+		stopWatch()
+		s.recordNegotiation(err)
+		if err != nil {
+			return
+		}
+		headerExtensions = h.GetHeaderExtensions()
+
+		h.started.Store(true)
+		sessionLogger.Infow("all tracks ready")
+		if replaced == nil {
+			// A reconnection continues the session of the resource, already reported as started
+			sessionHook.Notify(newSessionEvent(SessionEventStarted, h, s.conf))
+		}
+
+		go func() {
+			var err error
+			defer func() {
+				s.handlersLock.Lock()
+				handedOver := h.handedOver.Load()
+				s.removeHandler(resourceId, h)
+				if !handedOver {
+					s.addEndedSessionLocked(resourceId, h, err)
+				}
+				s.handlersLock.Unlock()
+
+				if handedOver {
+					// The session replacing this one reports the end of the ingress
+					sessionLogger.Infow("WHIP session replaced on reconnection")
+					close(h.done)
+					return
+				}
+				s.clearPendingDeletion(streamKey, resourceId)
+
+				if err != nil {
+					sessionLogger.Warnw("WHIP session failed", err)
+				} else {
+					sessionLogger.Infow("WHIP session ended normally")
+				}
+
+				var summary *types.SessionSummary
+				if ended != nil || sessionHook != nil {
+					summary = h.GetSessionSummary(s.ctx)
+				}
+
+				// Reports the end reason, nil for a normal end. The handler process of a transcoded session
+				// reports it from the relays instead
+				if ended != nil {
+					ended(summary, err)
+				}
+				if sessionHook != nil {
+					event := newSessionEvent(SessionEventEnded, h, s.conf)
+					event.DurationSeconds = summary.Duration.Seconds()
+					if err != nil {
+						event.Error = err.Error()
+					}
+					sessionHook.Notify(event)
+				}
+				close(h.done)
+			}()
+
+			err = h.WaitForSessionEnd(s.ctx)
+		}()
+	}()
+
+	return resourceId, sdpResponse, h.targetLatency, h.modifications, nil
+}
+
+// runReaper periodically closes the sessions that did not start in time, until the server is stopped
+func (s *WHIPServer) runReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.reapStuckSessions(now)
+		}
+	}
+}
+
+// reapStuckSessions closes the sessions that did not start within the grace period past their session
+// start timeout, as when a handler ignores its context, and returns how many were closed
+func (s *WHIPServer) reapStuckSessions(now time.Time) int {
+	s.handlersLock.Lock()
+	var stuck []*whipHandler
+	for resourceId, h := range s.handlers {
+		if h == nil || h.started.Load() {
+			continue
+		}
+		if now.Sub(h.createdAt) > s.conf.WHIP.GetSessionStartTimeout(h.app)+s.conf.WHIP.ReaperGracePeriod {
+			stuck = append(stuck, h)
+			s.removeHandler(resourceId, h)
+		}
+	}
+	s.handlersLock.Unlock()
+
+	for _, h := range stuck {
+		logger.Warnw("closing WHIP session that never started", nil, "app", h.app, "streamKey", h.streamKey, "resourceID", h.resourceId, "age", now.Sub(h.createdAt))
+		h.Close()
+	}
+
+	return len(stuck)
+}
+
+// getReplacedSession returns the session of a resource reconnected by its client with a new offer. Only the
+// sessions of this node can be replaced, as the offer is not forwarded to other nodes.
+func (s *WHIPServer) getReplacedSession(app string, streamKey string, resourceId string) (*whipHandler, error) {
+	s.handlersLock.Lock()
+	h := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+
+	if h == nil || h.app != app || h.streamKey != streamKey {
+		return nil, errors.ErrIngressNotFound
+	}
+	// The handler process of a transcoded session reads from the relays of the session, and would end with them
+	if *h.params.EnableTranscoding {
+		return nil, errors.ErrReconnectTranscoding
+	}
+	// The ingress is only published once the session started, there is nothing to take over before
+	if !h.started.Load() {
+		return nil, errors.ErrReconnectNotStarted
+	}
+	if h.renegotiations != nil {
+		if err := h.checkRenegotiation(); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// handOver replaces the session of a reconnected resource with h, negotiated from the new offer, and closes it.
+// The end of the replaced session is not reported, h takes over its ingress. It fails if the replaced session
+// ended in the meantime.
+func (s *WHIPServer) handOver(replaced *whipHandler, h *whipHandler) error {
+	s.handlersLock.Lock()
+	if s.handlers[replaced.resourceId] != replaced {
+		s.handlersLock.Unlock()
+		return errors.ErrIngressNotFound
+	}
+	replaced.handedOver.Store(true)
+	s.handlers[replaced.resourceId] = h
+	if s.offers[replaced.offerKey] == replaced.resourceId {
+		delete(s.offers, replaced.offerKey)
+	}
+	s.handlersLock.Unlock()
+
+	logger.Infow("replacing WHIP session on reconnection", "streamKey", replaced.streamKey, "resourceID", replaced.resourceId)
+	// The pending deletion would otherwise close the new session
+	s.clearPendingDeletion(replaced.streamKey, replaced.resourceId)
+	replaced.Close()
+
+	return nil
+}
+
+func (s *WHIPServer) onStuckNegotiation(phase string) {
+	if s.promStuckNegotiations != nil {
+		s.promStuckNegotiations.WithLabelValues(phase).Inc()
+	}
+}
+
+// Healthy returns false when the failure rate of the recent negotiations is above the configured threshold,
+// along with the failure rate
+func (s *WHIPServer) Healthy() (bool, float64) {
+	if s.negotiations == nil {
+		return true, 0
+	}
+
+	return s.negotiations.Healthy(time.Now())
+}
+
+func (s *WHIPServer) recordNegotiation(err error) {
+	if s.negotiations == nil {
+		return
+	}
+	if err != nil && !isNegotiationFailure(err) {
+		return
+	}
+
+	s.negotiations.Record(err != nil, time.Now())
+}
+
+// negotiationResult labels the negotiation metric, timeouts of the SDP response being worth alerting on
+func negotiationResult(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errors.ErrClientDisconnected):
+		return "canceled"
+	case errors.Is(err, errors.ErrICEGatheringTimeout), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// classifyPublishError tells apart capacity errors of the room from the ones of this node, as only
+// the former are worth retrying shortly
+func classifyPublishError(err error) error {
+	if errors.Is(err, errors.ErrServerCapacityExceeded) {
+		return err
+	}
+
+	var psrpcErr psrpc.Error
+	if errors.As(err, &psrpcErr) && psrpcErr.Code() == psrpc.ResourceExhausted {
+		return errors.ErrRoomFull
+	}
+
+	return err
+}
+
+func (s *WHIPServer) getWHEPURL(app string, streamKey string) string {
+	return expandURLTemplate(s.conf.WHIP.WHEPURLTemplate, app, streamKey)
+}
+
+// getMigrationURL returns the URL the client of a session on this node should reconnect to,
+// or an empty string if the node is not draining
+func (s *WHIPServer) getMigrationURL(app string, streamKey string, resourceId string) string {
+	if s.conf.WHIP.MigrationURLTemplate == "" {
+		return ""
+	}
+
+	s.handlersLock.Lock()
+	_, local := s.handlers[resourceId]
+	draining := s.draining
+	s.handlersLock.Unlock()
+
+	// Sessions on other nodes are not affected
+	if !draining || !local {
+		return ""
+	}
+
+	return expandURLTemplate(s.conf.WHIP.MigrationURLTemplate, app, streamKey)
+}
+
+func expandURLTemplate(template string, app string, streamKey string) string {
+	if template == "" {
+		return ""
+	}
+
+	return strings.NewReplacer(
+		"{app}", url.PathEscape(app),
+		"{stream_key}", url.PathEscape(streamKey),
+	).Replace(template)
+}
+
+// isReachableBehindNAT returns true if the WebRTC configuration has ICE servers, for the node to gather
+// server reflexive or relay candidates, or advertises a public address to clients
+func isReachableBehindNAT(rtcConf *rtcconfig.RTCConfig, webRTCConfig *rtcconfig.WebRTCConfig) bool {
+	if len(webRTCConfig.Configuration.ICEServers) > 0 {
+		return true
+	}
+
+	addrs := webRTCConfig.NAT1To1IPs
+	if len(addrs) == 0 && rtcConf.NodeIP != "" && (rtcConf.UseExternalIP || !rtcConf.NodeIPAutoGenerated) {
+		// The node IP is advertised when set explicitly, or when no external IP was found
+		addrs = []string{rtcConf.NodeIP}
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandStatsLabelTemplate returns the connection label of a session's stats. The stream key is redacted
+// as stats are exported to systems that must not be able to publish.
+func expandStatsLabelTemplate(template string, app string, streamKey string, resourceId string) string {
+	return strings.NewReplacer(
+		"{app}", app,
+		"{stream_key}", utils.RedactIdentifier(streamKey),
+		"{resource_id}", resourceId,
+	).Replace(template)
+}
+
+// newTLSConfig loads the configured certificate, so that a missing or invalid one fails at startup
+func newTLSConfig(conf *config.WHIPConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "failed loading whip tls certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   conf.GetTLSMinVersion(),
+	}, nil
+}
+
+// withMaxHeaderCount rejects requests with more header values than the limit with 431
+func withMaxHeaderCount(maxCount int, next http.Handler) http.Handler {
+	if maxCount <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var count int
+		for _, v := range r.Header {
+			count += len(v)
+		}
+		if count > maxCount {
+			logger.Infow("rejecting WHIP request, too many headers", "headerCount", count, "maxHeaderCount", maxCount)
+			w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRequestMetrics counts the POST, DELETE and PATCH requests by response status, whether the response
+// is written by handleError or by the handler itself
+func withRequestMetrics(requests *prometheus.CounterVec, next http.Handler) http.Handler {
+	if requests == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodDelete, http.MethodPatch:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		requests.WithLabelValues(r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder keeps the final status of a response, ignoring 100 Continue
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 && code >= http.StatusOK {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, to set the body read deadline
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withResponseHeaders adds the headers to all responses before the handler runs, so that
+// handlers still have the final say over any header they set themselves
+func withResponseHeaders(headers map[string]string, next http.Handler) http.Handler {
+	if len(headers) == 0 {
+		return next
+	}
+
+	extra := make(http.Header)
+	for k, v := range headers {
+		if slices.ContainsFunc(reservedResponseHeaders, func(h string) bool { return strings.EqualFold(h, k) }) {
+			logger.Warnw("ignoring reserved extra response header", nil, "header", k)
+			continue
+		}
+		extra.Set(k, v)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range extra {
+			w.Header()[k] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setAllowOrigin allows any origin if cors_origins is empty. Otherwise the request origin is only
+// allowed if it is listed, no Access-Control-Allow-Origin being returned to the others
+func (s *WHIPServer) setAllowOrigin(w http.ResponseWriter, r *http.Request) {
+	if len(s.conf.WHIP.CORSOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin != "" && slices.ContainsFunc(s.conf.WHIP.CORSOrigins, func(o string) bool { return strings.EqualFold(o, origin) }) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// setCORSHeaders answers a preflight, consistently with the Access-Control-Allow-Origin of the actual request
+func (s *WHIPServer) setCORSHeaders(w http.ResponseWriter, r *http.Request, resourceEndpoint bool) {
+	s.setAllowOrigin(w, r)
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+	if resourceEndpoint {
+		methods := "GET, PATCH, OPTIONS, DELETE"
+		if !s.iceRestartEnabled() {
+			methods = "GET, OPTIONS, DELETE"
+		}
+		if s.conf.WHIP.AllowReconnect {
+			methods += ", PUT"
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+	} else {
+		w.Header().Set("Accept-Post", "application/sdp")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		addExposedHeaders(w, "Location", "ETag", "Link", targetLatencyHeader)
+	}
+}
+
+// addExposedHeaders adds the headers to the ones browsers expose to the page, keeping the ones already
+// exposed, such as the request id set by withRequestID
+func addExposedHeaders(w http.ResponseWriter, headers ...string) {
+	var exposed []string
+	if current := w.Header().Get("Access-Control-Expose-Headers"); current != "" {
+		exposed = strings.Split(current, ", ")
+	}
+	for _, h := range headers {
+		if !slices.Contains(exposed, h) {
+			exposed = append(exposed, h)
+		}
+	}
+	w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+}
+
+// addICEServerLinks advertises the ICE servers of the node to the client, so that clients behind NAT can use
+// the same TURN servers
+func (s *WHIPServer) addICEServerLinks(w http.ResponseWriter) {
+	if !s.conf.WHIP.AdvertiseICEServers || s.webRTCConfig == nil {
+		return
+	}
+
+	for _, link := range getICEServerLinks(s.webRTCConfig.Configuration.ICEServers) {
+		w.Header().Add("Link", link)
 	}
 }
@@ -0,0 +1,1265 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/stats"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/rpc"
+	"github.com/livekit/psrpc"
+)
+
+// Signatures of onPublish and of the callbacks it returns
+type onPublishFunc = func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, readyFunc, endedFunc, error)
+type readyFunc = func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer
+type endedFunc = func(summary *types.SessionSummary, err error)
+
+func newTestWHIPServer(onPublish onPublishFunc) *WHIPServer {
+	s := NewWHIPServer(nil)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.conf = &config.Config{ServiceConfig: &config.ServiceConfig{}}
+	s.webRTCConfig = &rtcconfig.WebRTCConfig{}
+	s.onPublish = onPublish
+
+	return s
+}
+
+// newTestWHIPServerWithError returns a server failing every publish with err, after calling published if not nil
+func newTestWHIPServerWithError(err error, published func(resourceId string)) *WHIPServer {
+	return newTestWHIPServer(func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, readyFunc, endedFunc, error) {
+		if published != nil {
+			published(resourceId)
+		}
+		return nil, nil, nil, err
+	})
+}
+
+func TestStopRacesPost(t *testing.T) {
+	var stopped atomic.Bool
+	var publishedAfterStop atomic.Int32
+
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		if stopped.Load() {
+			publishedAfterStop.Add(1)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+			w := httptest.NewRecorder()
+			s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+
+			assert.Contains(t, []int{http.StatusNotFound, http.StatusServiceUnavailable}, w.Code)
+		}()
+	}
+
+	s.Stop()
+	wg.Wait()
+	stopped.Store(true)
+
+	req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+	w := httptest.NewRecorder()
+	s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Zero(t, publishedAfterStop.Load())
+}
+
+func TestStopRacesHandlerInsert(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	var added sync.Map
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			resourceId := fmt.Sprintf("resource_%d", i)
+			if err := s.addHandler(resourceId, NewWHIPHandler(s.webRTCConfig, nil, nil, "")); err != nil {
+				assert.ErrorIs(t, err, errors.ErrServerShuttingDown)
+				return
+			}
+			added.Store(resourceId, true)
+		}(i)
+	}
+
+	s.Stop()
+	wg.Wait()
+
+	require.ErrorIs(t, s.addHandler("resource_after_stop", NewWHIPHandler(s.webRTCConfig, nil, nil, "")), errors.ErrServerShuttingDown)
+
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+	for resourceId := range s.handlers {
+		_, ok := added.Load(resourceId)
+		require.True(t, ok)
+	}
+	require.NotContains(t, s.handlers, "resource_after_stop")
+}
+
+func TestAppSessionLimit(t *testing.T) {
+	// Requests reaching onPublish passed the app limit
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	s.conf.WHIP.MaxSessionsPerApp = 1
+	s.conf.WHIP.Apps = map[string]config.WHIPAppConfig{
+		"large": {MaxSessions: 2},
+	}
+	s.conf.WHIP.RoomFullRetryAfter = 5 * time.Second
+
+	post := func(app string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+app+"/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": app})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	require.NoError(t, s.addHandler("resource_small", NewWHIPHandler(s.webRTCConfig, nil, nil, "small")))
+	require.NoError(t, s.addHandler("resource_large", NewWHIPHandler(s.webRTCConfig, nil, nil, "large")))
+
+	w := post("small")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	// Other apps are not affected
+	require.Equal(t, http.StatusNotFound, post("large").Code)
+	require.Equal(t, http.StatusNotFound, post("other").Code)
+
+	require.NoError(t, s.addHandler("resource_large_2", NewWHIPHandler(s.webRTCConfig, nil, nil, "large")))
+	require.Equal(t, http.StatusServiceUnavailable, post("large").Code)
+
+	// Ending a session frees a slot
+	s.handlersLock.Lock()
+	delete(s.handlers, "resource_small")
+	s.handlersLock.Unlock()
+	require.Equal(t, http.StatusNotFound, post("small").Code)
+}
+
+func TestMigrationURL(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.MigrationURLTemplate = "https://other.example.com/{app}/{stream_key}"
+
+	require.NoError(t, s.addHandler("resource_local", NewWHIPHandler(s.webRTCConfig, nil, nil, "live")))
+
+	require.Empty(t, s.getMigrationURL("live", "key", "resource_local"))
+
+	s.Drain()
+	require.Equal(t, "https://other.example.com/live/key", s.getMigrationURL("live", "key", "resource_local"))
+	// Sessions on other nodes are not migrated by this one
+	require.Empty(t, s.getMigrationURL("live", "key", "resource_remote"))
+}
+
+func TestGetEffectiveConfig(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.RTCConfig.ICEPortRangeStart = 50000
+	s.conf.RTCConfig.ICEPortRangeEnd = 60000
+	s.webRTCConfig.Configuration.ICEServers = []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478"}, Username: "user", Credential: "secret"},
+	}
+
+	c := s.GetEffectiveConfig()
+	require.NotNil(t, c)
+	require.Equal(t, uint32(50000), c.ICEPortRangeStart)
+	require.Equal(t, []EffectiveICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478"}, Username: "***", Credential: "***"},
+	}, c.ICEServers)
+}
+
+func TestMaxHeaderCount(t *testing.T) {
+	h := withMaxHeaderCount(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	send := func(count int) int {
+		req := httptest.NewRequest(http.MethodPost, "/live", nil)
+		for i := 0; i < count; i++ {
+			req.Header.Add("X-Test", fmt.Sprint(i))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNoContent, send(3))
+	require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, send(4))
+}
+
+func TestMaintenance(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	s.conf.WHIP.RoomFullRetryAfter = time.Second
+	s.conf.WHIP.UnavailableRetryAfter = 5 * time.Second
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	s.SetMaintenance(true)
+	w := post()
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "5", w.Header().Get("Retry-After"))
+	_, _, err := s.CreateSession("live", "key", "v=0")
+	require.ErrorIs(t, err, errors.ErrMaintenance)
+
+	s.SetMaintenance(false)
+	require.Equal(t, http.StatusNotFound, post().Code)
+}
+
+func TestOriginEnforcement(t *testing.T) {
+	// Requests reaching onPublish passed the origin checks
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	s.conf.WHIP.AllowedOrigins = []string{"https://studio.example.com"}
+
+	// POST without a preflight, as sent by native clients
+	post := func(origin string) int {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w.Code
+	}
+	preflight := func(origin string) int {
+		req := httptest.NewRequest(http.MethodOptions, "/live/key", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		s.handleSessionPreflight(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, post(""))
+	require.Equal(t, http.StatusNotFound, post("https://STUDIO.example.com"))
+	require.Equal(t, http.StatusForbidden, post("https://evil.example.com"))
+
+	require.Equal(t, http.StatusNoContent, preflight("https://studio.example.com"))
+	require.Equal(t, http.StatusForbidden, preflight("https://evil.example.com"))
+
+	s.conf.WHIP.RejectMissingOrigin = true
+	require.Equal(t, http.StatusForbidden, post(""))
+	require.Equal(t, http.StatusNotFound, post("https://studio.example.com"))
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	conf := &config.WHIPConfig{
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSMinVersion: config.WHIPTLSVersion13,
+	}
+	tlsConfig, err := newTLSConfig(conf)
+	require.NoError(t, err)
+	require.Len(t, tlsConfig.Certificates, 1)
+	require.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+
+	conf.TLSMinVersion = config.WHIPTLSVersion12
+	tlsConfig, err = newTLSConfig(conf)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+
+	conf.TLSKeyFile = filepath.Join(dir, "missing.pem")
+	_, err = newTLSConfig(conf)
+	require.Error(t, err)
+}
+
+func TestCORSOrigins(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	// A retried POST succeeds without negotiation
+	h := &whipHandler{resourceId: "WH_cors", offerKey: getOfferKey("key", "v=0"), sdpAnswer: "v=0"}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+
+	allowOrigin := func(method string, origin string) (string, http.Header) {
+		req := httptest.NewRequest(method, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		if method == http.MethodOptions {
+			s.handleSessionPreflight(w, req)
+		} else {
+			s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		}
+		return w.Header().Get("Access-Control-Allow-Origin"), w.Header()
+	}
+
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		origin, _ := allowOrigin(method, "https://studio.example.com")
+		require.Equal(t, "*", origin)
+	}
+
+	s.conf.WHIP.CORSOrigins = []string{"https://studio.example.com"}
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		origin, header := allowOrigin(method, "https://studio.example.com")
+		require.Equal(t, "https://studio.example.com", origin, method)
+		require.Equal(t, "Origin", header.Get("Vary"))
+
+		origin, _ = allowOrigin(method, "https://other.example.com")
+		require.Empty(t, origin, method)
+	}
+
+	_, header := allowOrigin(http.MethodOptions, "https://studio.example.com")
+	require.Equal(t, "7200", header.Get("Access-Control-Max-Age"))
+}
+
+func TestHostEnforcement(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+
+	post := func(host string) int {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		req.Host = host
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, post("other.example.net"))
+
+	s.conf.WHIP.AllowedHosts = []string{"whip.example.com", "*.ingress.example.com", "localhost:8080"}
+	require.Equal(t, http.StatusNotFound, post("whip.example.com"))
+	require.Equal(t, http.StatusNotFound, post("WHIP.example.com:443"))
+	require.Equal(t, http.StatusNotFound, post("eu.ingress.example.com"))
+	require.Equal(t, http.StatusNotFound, post("localhost:8080"))
+	require.Equal(t, http.StatusMisdirectedRequest, post("localhost:9090"))
+	require.Equal(t, http.StatusMisdirectedRequest, post("ingress.example.com"))
+	require.Equal(t, http.StatusMisdirectedRequest, post("other.example.net"))
+	require.Equal(t, http.StatusMisdirectedRequest, post(""))
+}
+
+func TestNilPublishParams(t *testing.T) {
+	var readyErr error
+	s := newTestWHIPServer(func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, readyFunc, endedFunc, error) {
+		return nil, func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer {
+			readyErr = err
+			return nil
+		}, nil, nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+	req = mux.SetURLVars(req, map[string]string{"app": "live"})
+	w := httptest.NewRecorder()
+	s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.ErrorIs(t, readyErr, errors.ErrMissingPublishParams)
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+
+	post := func(contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	w := post(100)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Content-Length")
+
+	require.Equal(t, http.StatusNotFound, post(3).Code)
+	// Chunked requests have no Content-Length
+	require.Equal(t, http.StatusNotFound, post(-1).Code)
+}
+
+func TestOfferSizeLimit(t *testing.T) {
+	var published atomic.Int32
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		published.Add(1)
+	})
+	s.conf.WHIP.MaxOfferSize = 16
+
+	post := func(body string, contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	large := "v=0\r\n" + strings.Repeat("a=x\r\n", 10)
+	w := post(large, int64(len(large)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Equal(t, "offer_too_large", w.Header().Get(errorCodeHeader))
+	// Chunked requests are cut at the limit
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(large, -1).Code)
+	require.Zero(t, published.Load())
+
+	require.Equal(t, http.StatusNotFound, post("v=0", 3).Code)
+	require.Equal(t, int32(1), published.Load())
+
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+	require.Empty(t, s.handlers)
+}
+
+func TestAllowedApps(t *testing.T) {
+	var published atomic.Int32
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		published.Add(1)
+	})
+	s.conf.WHIP.AllowedApps = []string{"live"}
+
+	post := func(app string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+app+"/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": app})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	w := post("other")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "app_not_allowed", w.Header().Get(errorCodeHeader))
+	require.Zero(t, published.Load())
+
+	require.Equal(t, http.StatusNotFound, post("live").Code)
+	require.Equal(t, int32(1), published.Load())
+
+	// Programmatic sessions are held to the same allowlist
+	_, _, err := s.CreateSession("other", "key", "v=0")
+	require.ErrorIs(t, err, errors.ErrAppNotAllowed)
+	require.Equal(t, int32(1), published.Load())
+
+	// All apps are accepted without an allowlist
+	s.conf.WHIP.AllowedApps = nil
+	require.NoError(t, s.checkApp("other"))
+}
+
+func TestRelayAssociationLimit(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	h := NewWHIPHandler(s.webRTCConfig, nil, nil, "live")
+	h.logger = logger.GetLogger()
+	h.params = &params.Params{
+		Config:     &config.Config{ServiceConfig: &config.ServiceConfig{WHIP: config.WHIPConfig{MaxRelaysPerSession: 1}}},
+		RelayToken: "token",
+	}
+	for _, kind := range []types.StreamKind{types.Audio, types.Video} {
+		h.trackHandlers[WhipTrackDescription{Kind: kind, Quality: livekit.VideoQuality_HIGH}] = &RelayWhipTrackHandler{
+			relaySink: NewRelayMediaSink(logger.GetLogger(), nil),
+		}
+	}
+	require.NoError(t, s.addHandler("resource", h))
+
+	_, pw := io.Pipe()
+	require.ErrorIs(t, s.AssociateRelay("resource", types.Video, "wrong", pw), errors.ErrInvalidRelayToken)
+	require.NoError(t, s.AssociateRelay("resource", types.Video, "token", pw))
+	require.ErrorIs(t, s.AssociateRelay("resource", types.Audio, "token", pw), errors.ErrTooManyRelays)
+
+	// The writer of an associated kind can be replaced, and is counted once
+	_, pw2 := io.Pipe()
+	require.NoError(t, s.AssociateRelay("resource", types.Video, "token", pw2))
+	require.Equal(t, 1, h.RelayCount())
+
+	s.DissociateRelay("resource", types.Video)
+	require.Equal(t, 0, h.RelayCount())
+	s.DissociateRelay("resource", types.Video)
+	require.Equal(t, 0, h.RelayCount())
+	require.NoError(t, s.AssociateRelay("resource", types.Audio, "token", pw))
+	require.Equal(t, 1, h.RelayCount())
+}
+
+func TestNodeRelayLimit(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.MaxRelays = 1
+
+	for _, resourceId := range []string{"r1", "r2"} {
+		h := NewWHIPHandler(s.webRTCConfig, nil, nil, "live")
+		h.logger = logger.GetLogger()
+		h.params = &params.Params{
+			Config:     &config.Config{ServiceConfig: &config.ServiceConfig{WHIP: config.WHIPConfig{MaxRelaysPerSession: 2}}},
+			RelayToken: "token",
+		}
+		h.trackHandlers[WhipTrackDescription{Kind: types.Video, Quality: livekit.VideoQuality_HIGH}] = &RelayWhipTrackHandler{
+			relaySink: NewRelayMediaSink(logger.GetLogger(), nil),
+		}
+		require.NoError(t, s.addHandler(resourceId, h))
+	}
+
+	_, pw := io.Pipe()
+	require.NoError(t, s.AssociateRelay("r1", types.Video, "token", pw))
+	require.Equal(t, 1, s.RelayCount())
+	// r2 is under its per-session limit
+	require.ErrorIs(t, s.AssociateRelay("r2", types.Video, "token", pw), errors.ErrRelayLimitReached)
+	// Replacing the writer of r1 does not count against the node limit
+	_, pw2 := io.Pipe()
+	require.NoError(t, s.AssociateRelay("r1", types.Video, "token", pw2))
+	require.Equal(t, 1, s.RelayCount())
+
+	s.DissociateRelay("r1", types.Video)
+	require.Equal(t, 0, s.RelayCount())
+	require.NoError(t, s.AssociateRelay("r2", types.Video, "token", pw))
+}
+
+func TestDeleteGracePeriod(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	pending := func(streamKey string) bool {
+		s.handlersLock.Lock()
+		defer s.handlersLock.Unlock()
+		_, ok := s.deletions[streamKey]
+		return ok
+	}
+
+	// Nil handlers stand for local sessions, closing them is a no-op
+	s.handlers["r1"] = nil
+	s.handlers["r2"] = nil
+
+	require.False(t, s.deferDeletion("key", "r1"))
+
+	s.conf.WHIP.DeleteGracePeriod = 50 * time.Millisecond
+	require.False(t, s.deferDeletion("key", "unknown"))
+	require.False(t, pending("key"))
+
+	require.True(t, s.deferDeletion("key", "r1"))
+	require.True(t, s.deferDeletion("key", "r1"))
+	require.True(t, pending("key"))
+	require.Eventually(t, func() bool { return !pending("key") }, time.Second, 10*time.Millisecond)
+
+	require.True(t, s.deferDeletion("key", "r1"))
+	s.closePendingDeletion("key")
+	require.False(t, pending("key"))
+
+	require.True(t, s.deferDeletion("key", "r1"))
+	s.clearPendingDeletion("key", "r2")
+	require.True(t, pending("key"))
+	s.clearPendingDeletion("key", "r1")
+	require.False(t, pending("key"))
+
+	require.True(t, s.deferDeletion("key", "r1"))
+	require.True(t, s.deferDeletion("key", "r2"))
+	s.handlersLock.Lock()
+	require.Equal(t, "r2", s.deletions["key"].resourceId)
+	s.handlersLock.Unlock()
+
+	s.Stop()
+	require.False(t, pending("key"))
+	require.False(t, s.deferDeletion("key", "r1"))
+}
+
+func TestNodeSessionLimit(t *testing.T) {
+	var published atomic.Int32
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		published.Add(1)
+	})
+	s.conf.WHIP.MaxConcurrentSessions = 2
+	s.conf.WHIP.RoomFullRetryAfter = time.Second
+	s.conf.WHIP.UnavailableRetryAfter = 5 * time.Second
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	s.handlers["r1"] = nil
+	require.Equal(t, http.StatusNotFound, post().Code)
+	require.Equal(t, int32(1), published.Load())
+
+	s.handlers["r2"] = nil
+	w := post()
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "5", w.Header().Get("Retry-After"))
+	require.Equal(t, int32(1), published.Load())
+}
+
+func TestExpectContinue(t *testing.T) {
+	var offers atomic.Int32
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		offers.Add(1)
+	})
+	s.conf.WHIP.BodyReadTimeout = 200 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = mux.SetURLVars(r, map[string]string{"app": "live"})
+		s.handleError(s.handleNewWhipClient(w, r, "key"), w, r)
+	}))
+	defer srv.Close()
+
+	post := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		_, err = conn.Write([]byte("POST /live/key HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/sdp\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n"))
+		require.NoError(t, err)
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("body sent after 100 Continue", func(t *testing.T) {
+		conn, br := post(t)
+
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusContinue, resp.StatusCode)
+
+		_, err = conn.Write([]byte("v=0"))
+		require.NoError(t, err)
+
+		resp, err = http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.Equal(t, int32(1), offers.Load())
+	})
+
+	t.Run("body never sent", func(t *testing.T) {
+		_, br := post(t)
+
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusContinue, resp.StatusCode)
+
+		start := time.Now()
+		resp, err = http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Equal(t, int32(1), offers.Load())
+	})
+
+	t.Run("rejected before the body", func(t *testing.T) {
+		s.SetMaintenance(true)
+		defer s.SetMaintenance(false)
+
+		_, br := post(t)
+
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
+func TestErrorCodes(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	for _, test := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.ErrInvalidWHIPOfferReason("missing ice-pwd"), http.StatusBadRequest, "invalid_offer"},
+		{errors.ErrDuplicateTrack, http.StatusNotAcceptable, "too_many_tracks"},
+		{errors.ErrRoomFull, http.StatusTooManyRequests, "room_full"},
+		{errors.ErrTooManyStreamKeys, http.StatusTooManyRequests, "stream_key_rate_limited"},
+		{errors.ErrHostNotAllowed, http.StatusMisdirectedRequest, "host_not_allowed"},
+		{psrpc.NewErrorf(psrpc.NotFound, "remote failure"), http.StatusNotFound, "not_found"},
+		{io.ErrUnexpectedEOF, http.StatusInternalServerError, "internal"},
+	} {
+		t.Run(test.code, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/live/key", nil)
+			w := httptest.NewRecorder()
+			s.handleError(test.err, w, req)
+
+			require.Equal(t, test.status, w.Code)
+			require.Equal(t, test.code, w.Header().Get(errorCodeHeader))
+			require.NotContains(t, w.Header().Get("Content-Type"), "application/json")
+
+			req.Header.Set("Accept", "application/sdp, application/json")
+			w = httptest.NewRecorder()
+			s.handleError(test.err, w, req)
+
+			require.Equal(t, test.status, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			var body errorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			require.Equal(t, test.code, body.Code)
+			require.NotEmpty(t, body.Message)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.handleError(nil, w, httptest.NewRequest(http.MethodPost, "/live/key", nil))
+	require.Empty(t, w.Header().Get(errorCodeHeader))
+}
+
+func TestIsReachableBehindNAT(t *testing.T) {
+	stun := &rtcconfig.WebRTCConfig{Configuration: webrtc.Configuration{ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}}}}
+	require.True(t, isReachableBehindNAT(&rtcconfig.RTCConfig{}, stun))
+
+	require.False(t, isReachableBehindNAT(&rtcconfig.RTCConfig{UseICELite: true, NodeIP: "10.0.0.1", NodeIPAutoGenerated: true}, &rtcconfig.WebRTCConfig{}))
+	require.False(t, isReachableBehindNAT(&rtcconfig.RTCConfig{NodeIP: "192.168.1.10"}, &rtcconfig.WebRTCConfig{}))
+	require.True(t, isReachableBehindNAT(&rtcconfig.RTCConfig{NodeIP: "203.0.113.10"}, &rtcconfig.WebRTCConfig{}))
+	require.True(t, isReachableBehindNAT(&rtcconfig.RTCConfig{UseExternalIP: true, NodeIP: "10.0.0.1"}, &rtcconfig.WebRTCConfig{NAT1To1IPs: []string{"203.0.113.10"}}))
+}
+
+func TestExpandStatsLabelTemplate(t *testing.T) {
+	require.Equal(t, "live/{sk_...jkl}/WH_abc", expandStatsLabelTemplate(config.DefaultWHIPStatsLabelTemplate, "live", "sk_abcdefghijkl", "WH_abc"))
+	require.Equal(t, "ingress-WH_abc", expandStatsLabelTemplate("ingress-{resource_id}", "live", "sk_abcdefghijkl", "WH_abc"))
+}
+
+func TestPostRetry(t *testing.T) {
+	var published atomic.Int32
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		published.Add(1)
+	})
+
+	h := &whipHandler{
+		resourceId: "WH_retry",
+		offerKey:   getOfferKey("key", "v=0"),
+		sdpAnswer:  "v=0 answer",
+	}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+
+	post := func(offer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader(offer))
+		req = mux.SetURLVars(req, map[string]string{"app": "live"})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+		return w
+	}
+
+	w := post("v=0")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "v=0 answer", w.Body.String())
+	require.Equal(t, "/live/key/WH_retry", w.Header().Get("Location"))
+	require.Equal(t, sessionETag("WH_retry", 0), w.Header().Get("ETag"))
+	require.Equal(t, int32(0), published.Load())
+
+	// The ETag follows the ICE restarts of the session
+	h.generation.Add(1)
+	w = post("v=0")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, sessionETag("WH_retry", 1), w.Header().Get("ETag"))
+
+	// Another offer is a new session
+	require.Equal(t, http.StatusNotFound, post("v=0\r\n").Code)
+	require.Equal(t, int32(1), published.Load())
+
+	s.handlersLock.Lock()
+	s.removeHandler(h.resourceId, h)
+	s.handlersLock.Unlock()
+	require.Equal(t, http.StatusNotFound, post("v=0").Code)
+	require.Equal(t, int32(2), published.Load())
+}
+
+func TestSessionStates(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	connected := &whipHandler{iceConnected: make(chan struct{})}
+	close(connected.iceConnected)
+	connecting := &whipHandler{iceConnected: make(chan struct{})}
+	require.NoError(t, s.addHandler("WH_connected", connected))
+	require.NoError(t, s.addHandler("WH_connecting", connecting))
+	require.NoError(t, s.startNegotiation("key", ""))
+
+	require.Equal(t, SessionStates{Negotiating: 1, Connecting: 1, Connected: 1}, s.GetSessionStates())
+	require.False(t, s.IsIdle())
+
+	s.handlersLock.Lock()
+	s.removeHandler("WH_connected", connected)
+	s.removeHandler("WH_connecting", connecting)
+	s.handlersLock.Unlock()
+
+	// Sessions still negotiating are not in the handlers map yet
+	require.Equal(t, SessionStates{Negotiating: 1, Ended: 2}, s.GetSessionStates())
+	require.False(t, s.IsIdle())
+
+	s.endNegotiation("key")
+	require.True(t, s.IsIdle())
+}
+
+func TestReconnect(t *testing.T) {
+	published := make(chan string, 1)
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, func(resourceId string) {
+		published <- resourceId
+	})
+
+	transcoding := false
+	h := &whipHandler{resourceId: "WH_1", app: "live", streamKey: "key", offerKey: getOfferKey("key", "v=0"), done: make(chan struct{}), logger: logger.GetLogger()}
+	h.params = &params.Params{Config: &config.Config{ServiceConfig: &config.ServiceConfig{}}, IngressInfo: &livekit.IngressInfo{EnableTranscoding: &transcoding}}
+	h.renegotiations = newRenegotiationLimiter(0, 1)
+	require.NoError(t, s.addHandler(h.resourceId, h))
+
+	put := func(streamKey string, resourceId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/live/"+streamKey+"/"+resourceId, strings.NewReader("v=0"))
+		req = mux.SetURLVars(req, map[string]string{"app": "live", "stream_key": streamKey, "resource_id": resourceId})
+		w := httptest.NewRecorder()
+		s.handleError(s.handleNewWhipClient(w, req, streamKey), w, req)
+		return w
+	}
+
+	// The session is only replaced with the stream key it was created with
+	require.Equal(t, http.StatusNotFound, put("other", "WH_1").Code)
+	require.Equal(t, http.StatusNotFound, put("key", "WH_unknown").Code)
+	// Nor before its ingress is published
+	require.Equal(t, http.StatusServiceUnavailable, put("key", "WH_1").Code)
+	h.started.Store(true)
+
+	transcoding = true
+	require.Equal(t, http.StatusNotImplemented, put("key", "WH_1").Code)
+	transcoding = false
+
+	// The reconnection counts against the renegotiation limit of the session. The offer the session was
+	// created from is negotiated again rather than answered as a retried POST
+	_, ok := h.renegotiations.Allow(time.Now())
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, put("key", "WH_1").Code)
+
+	// The ingress is never published again, and the session is left untouched by the failed reconnections
+	require.Empty(t, published)
+	s.handlersLock.Lock()
+	require.Equal(t, h, s.handlers["WH_1"])
+	s.handlersLock.Unlock()
+	require.False(t, h.handedOver.Load())
+}
+
+func TestHandOver(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	replaced := &whipHandler{resourceId: "WH_1", app: "live", streamKey: "key", offerKey: "offer"}
+	require.NoError(t, s.addHandler(replaced.resourceId, replaced))
+	s.deletions["key"] = &pendingDeletion{resourceId: "WH_1", timer: time.NewTimer(time.Hour)}
+
+	h := &whipHandler{resourceId: "WH_1", app: "live", streamKey: "key", offerKey: "new_offer"}
+	require.NoError(t, s.handOver(replaced, h))
+	require.True(t, replaced.handedOver.Load())
+
+	s.handlersLock.Lock()
+	require.Equal(t, h, s.handlers["WH_1"])
+	require.NotContains(t, s.offers, "offer")
+	require.NotContains(t, s.deletions, "key")
+	s.handlersLock.Unlock()
+
+	// RPCs of the resource reach the new session
+	ihs := &resourceRPCHandler{s: s, resourceId: "WH_1", h: replaced}
+	require.Equal(t, h, ihs.getHandler())
+
+	// A session that ended during the negotiation cannot be taken over
+	require.ErrorIs(t, s.handOver(replaced, &whipHandler{resourceId: "WH_1"}), errors.ErrIngressNotFound)
+}
+
+func TestReapStuckSessions(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.ReaperGracePeriod = time.Minute
+
+	now := time.Now()
+	stuck := &whipHandler{resourceId: "WH_stuck", createdAt: now.Add(-2 * time.Minute)}
+	recent := &whipHandler{resourceId: "WH_recent", createdAt: now}
+	started := &whipHandler{resourceId: "WH_started", createdAt: now.Add(-2 * time.Minute)}
+	started.started.Store(true)
+	for _, h := range []*whipHandler{stuck, recent, started} {
+		require.NoError(t, s.addHandler(h.resourceId, h))
+	}
+
+	require.Equal(t, 1, s.reapStuckSessions(now))
+
+	s.handlersLock.Lock()
+	_, ok := s.handlers["WH_stuck"]
+	require.False(t, ok)
+	require.Len(t, s.handlers, 2)
+	// The session goroutine removing the reaped handler again is a no-op
+	s.removeHandler("WH_stuck", stuck)
+	s.handlersLock.Unlock()
+	require.Equal(t, uint64(1), s.GetSessionStates().Ended)
+
+	require.Zero(t, s.reapStuckSessions(now))
+}
+
+func TestSessionStatus(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.EndedSessionTTL = time.Minute
+
+	get := func(streamKey string, resourceId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/live/"+streamKey+"/"+resourceId, nil)
+		w := httptest.NewRecorder()
+		s.handleError(s.handleSessionStatus(w, req, "live", streamKey, resourceId), w, req)
+		return w
+	}
+
+	h := &whipHandler{app: "live", streamKey: "key", resourceId: "WH_status"}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+	w := get("key", "WH_status")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"state":"starting"}`, w.Body.String())
+	h.started.Store(true)
+	require.JSONEq(t, `{"state":"active"}`, get("key", "WH_status").Body.String())
+	// The resource is not found with another stream key
+	require.Equal(t, http.StatusNotFound, get("other", "WH_status").Code)
+
+	s.handlersLock.Lock()
+	s.removeHandler(h.resourceId, h)
+	s.addEndedSessionLocked(h.resourceId, h, errors.ErrIngressNotFound)
+	s.handlersLock.Unlock()
+	w = get("key", "WH_status")
+	require.Equal(t, http.StatusGone, w.Code)
+	var status sessionStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, sessionStateEnded, status.State)
+	require.Equal(t, errors.ErrIngressNotFound.Error(), status.Error)
+
+	// Ended sessions are forgotten after the TTL
+	s.conf.WHIP.EndedSessionTTL = time.Millisecond
+	s.handlersLock.Lock()
+	s.addEndedSessionLocked(h.resourceId, h, nil)
+	s.handlersLock.Unlock()
+	require.Eventually(t, func() bool {
+		return get("key", "WH_status").Code == http.StatusNotFound
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWaitForSessionEnd(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.EndedSessionTTL = time.Minute
+
+	// Ends the session the way the session goroutine of createStream does
+	end := func(h *whipHandler, err error) {
+		s.handlersLock.Lock()
+		s.removeHandler(h.resourceId, h)
+		s.addEndedSessionLocked(h.resourceId, h, err)
+		s.handlersLock.Unlock()
+		close(h.done)
+	}
+
+	ended, _ := s.waitForSessionEnd(context.Background(), "WH_unknown")
+	require.False(t, ended)
+
+	h := &whipHandler{resourceId: "WH_ended", done: make(chan struct{})}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+	// The wait is bounded, for sessions outliving their relays
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ended, _ = s.waitForSessionEnd(ctx, h.resourceId)
+	require.False(t, ended)
+
+	result := make(chan error, 1)
+	go func() {
+		ended, err := s.waitForSessionEnd(context.Background(), h.resourceId)
+		assert.True(t, ended)
+		result <- err
+	}()
+	end(h, nil)
+	require.NoError(t, <-result)
+
+	// A failure is reported with its reason, unlike a normal end
+	h = &whipHandler{resourceId: "WH_failed", done: make(chan struct{})}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+	end(h, errors.ErrSourceNotReady)
+	ended, err := s.waitForSessionEnd(context.Background(), h.resourceId)
+	require.True(t, ended)
+	require.ErrorIs(t, err, errors.ErrSourceNotReady)
+	require.NotEqual(t, types.FormatSessionEnd(types.NewSessionEnd(nil)), types.FormatSessionEnd(types.NewSessionEnd(err)))
+}
+
+func TestStopWithDrain(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	h := &whipHandler{}
+	require.NoError(t, s.addHandler("WH_drain", h))
+
+	stopped := make(chan struct{})
+	go func() {
+		s.StopWithDrain(context.Background())
+		close(stopped)
+	}()
+
+	require.Eventually(t, s.Draining, time.Second, 10*time.Millisecond)
+	req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+	req = mux.SetURLVars(req, map[string]string{"app": "live"})
+	w := httptest.NewRecorder()
+	s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	select {
+	case <-stopped:
+		t.Fatal("stopped with a session left")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, s.ctx.Err())
+
+	s.handlersLock.Lock()
+	s.removeHandler("WH_drain", h)
+	s.handlersLock.Unlock()
+	<-stopped
+	require.Error(t, s.ctx.Err())
+
+	// The drain is bounded by the context
+	s = newTestWHIPServer(nil)
+	require.NoError(t, s.addHandler("WH_drain", h))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.StopWithDrain(ctx)
+	require.Error(t, s.ctx.Err())
+}
+
+func TestClientDisconnectDuringNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var resourceID string
+	readyErr := make(chan error, 1)
+	s := newTestWHIPServer(func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, readyFunc, endedFunc, error) {
+		resourceID = resourceId
+		// The client hangs up while the session is being published
+		cancel()
+		ready := func(mimeTypes map[types.StreamKind]string, trackLabels map[types.StreamKind]string, headerExtensions map[types.StreamKind][]types.HeaderExtension, err error) *stats.LocalMediaStatsGatherer {
+			readyErr <- err
+			return nil
+		}
+		return &params.Params{}, ready, nil, nil
+	})
+	defer s.Stop()
+
+	req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0")).WithContext(ctx)
+	req = mux.SetURLVars(req, map[string]string{"app": "live"})
+	w := httptest.NewRecorder()
+	require.ErrorIs(t, s.handleNewWhipClient(w, req, "key"), errors.ErrClientDisconnected)
+	require.ErrorIs(t, <-readyErr, errors.ErrClientDisconnected)
+
+	s.handlersLock.Lock()
+	_, ok := s.handlers[resourceID]
+	s.handlersLock.Unlock()
+	require.False(t, ok)
+	require.Zero(t, s.GetSessionStates().Negotiating)
+}
+
+func TestStreamKeySessionLimit(t *testing.T) {
+	s := newTestWHIPServerWithError(errors.ErrIngressNotFound, nil)
+	s.conf.WHIP.MaxSessionsPerStreamKey = 2
+
+	h := &whipHandler{streamKey: "key"}
+	require.NoError(t, s.addHandler("WH_1", h))
+	require.NoError(t, s.startNegotiation("key", ""))
+	require.ErrorIs(t, s.startNegotiation("key", ""), errors.ErrTooManyStreams)
+	require.NoError(t, s.startNegotiation("other", ""))
+
+	req := httptest.NewRequest(http.MethodPost, "/live/key", strings.NewReader("v=0"))
+	req = mux.SetURLVars(req, map[string]string{"app": "live"})
+	w := httptest.NewRecorder()
+	s.handleError(s.handleNewWhipClient(w, req, "key"), w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "stream_key_session_limit_reached", w.Header().Get(errorCodeHeader))
+
+	// The deleted session is replaced by the reconnection
+	s.deletions["key"] = &pendingDeletion{resourceId: "WH_1", timer: time.NewTimer(time.Hour)}
+	require.NoError(t, s.startNegotiation("key", ""))
+	s.endNegotiation("key")
+
+	s.endNegotiation("key")
+	s.handlersLock.Lock()
+	delete(s.deletions, "key")
+	s.removeHandler("WH_1", h)
+	s.handlersLock.Unlock()
+	require.NoError(t, s.startNegotiation("key", ""))
+	require.NoError(t, s.startNegotiation("key", ""))
+}
+
+func TestRequestMetrics(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"method", "status"})
+	h := withRequestMetrics(requests, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/created":
+			w.WriteHeader(http.StatusContinue)
+			w.WriteHeader(http.StatusCreated)
+		case "/body":
+			_, _ = w.Write([]byte("ok"))
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/created"},
+		{http.MethodPost, "/unavailable"},
+		{http.MethodPost, "/unavailable"},
+		{http.MethodDelete, "/body"},
+		{http.MethodPatch, "/none"},
+		{http.MethodOptions, "/none"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	require.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(http.MethodPost, "201")))
+	require.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues(http.MethodPost, "503")))
+	require.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(http.MethodDelete, "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(http.MethodPatch, "200")))
+	require.Equal(t, 4, testutil.CollectAndCount(requests))
+}
+
+func TestGetStreamKey(t *testing.T) {
+	s := newTestWHIPServer(nil)
+	s.conf.WHIP.StreamKeyQueryParam = config.DefaultWHIPStreamKeyQueryParam
+
+	getStreamKey := func(header string, pathKey string, query string) (string, string) {
+		req := httptest.NewRequest(http.MethodPost, "/live"+query, nil)
+		vars := map[string]string{"app": "live"}
+		if pathKey != "" {
+			vars["stream_key"] = pathKey
+		}
+		req = mux.SetURLVars(req, vars)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return s.getStreamKey(req)
+	}
+
+	for _, c := range []struct {
+		name           string
+		header         string
+		pathKey        string
+		query          string
+		expectedKey    string
+		expectedSource string
+	}{
+		{name: "bearer", header: "Bearer header_key", expectedKey: "header_key", expectedSource: "header"},
+		{name: "no bearer prefix", header: "header_key", expectedKey: "header_key", expectedSource: "header"},
+		{name: "path", pathKey: "path_key", expectedKey: "path_key", expectedSource: "path"},
+		{name: "query", query: "?token=query_key", expectedKey: "query_key", expectedSource: "query"},
+		{name: "header over path and query", header: "Bearer header_key", pathKey: "path_key", query: "?token=query_key", expectedKey: "header_key", expectedSource: "header"},
+		{name: "path over query", pathKey: "path_key", query: "?token=query_key", expectedKey: "path_key", expectedSource: "path"},
+		{name: "other param", query: "?key=query_key", expectedKey: "", expectedSource: "none"},
+		{name: "none", expectedKey: "", expectedSource: "none"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			key, source := getStreamKey(c.header, c.pathKey, c.query)
+			require.Equal(t, c.expectedKey, key)
+			require.Equal(t, c.expectedSource, source)
+		})
+	}
+
+	s.conf.WHIP.StreamKeyQueryParam = "key"
+	key, source := getStreamKey("", "", "?key=query_key")
+	require.Equal(t, "query_key", key)
+	require.Equal(t, "query", source)
+}
+
+func TestListSessions(t *testing.T) {
+	s := newTestWHIPServer(nil)
+
+	connected := &whipHandler{resourceId: "WH_2", app: "live", streamKey: "key2", iceConnected: make(chan struct{}), relays: map[types.StreamKind]bool{types.Video: true}, requestReceivedAt: time.Now().Add(-time.Minute)}
+	close(connected.iceConnected)
+	require.NoError(t, s.addHandler("WH_2", connected))
+	require.NoError(t, s.addHandler("WH_1", &whipHandler{resourceId: "WH_1", app: "live", streamKey: "key1", iceConnected: make(chan struct{})}))
+	s.handlers["WH_remote"] = nil
+
+	sessions := s.ListSessions()
+	require.Len(t, sessions, 2)
+	require.Equal(t, SessionDescription{ResourceID: "WH_1", App: "live", StreamKey: "key1", State: "connecting", MimeTypes: map[types.StreamKind]string{}}, sessions[0])
+	require.Equal(t, "connected", sessions[1].State)
+	require.Equal(t, 1, sessions[1].Relays)
+	require.GreaterOrEqual(t, sessions[1].UptimeSeconds, 60.0)
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := sessionETag("WH_abc", 2)
+	require.NotEqual(t, sessionETag("WH_abc", 1), etag)
+
+	require.True(t, etagMatches(etag, etag))
+	require.True(t, etagMatches(strings.Trim(etag, `"`), etag))
+	require.True(t, etagMatches(`"other", W/`+etag, etag))
+	require.False(t, etagMatches(sessionETag("WH_abc", 1), etag))
+	require.False(t, etagMatches(`"other"`, etag))
+}
+
+func TestStartRetryAfterRegisterFailure(t *testing.T) {
+	conf := &config.Config{ServiceConfig: &config.ServiceConfig{}, InternalConfig: &config.InternalConfig{NodeID: "NE_start_failure"}}
+	conf.WHIP.LANOnly = true
+
+	// Taken by another collector, so that Start fails after registering the first ones
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_requests",
+		ConstLabels: stats.NodeLabels(conf),
+	}, []string{"method", "status"})
+	require.NoError(t, prometheus.Register(requests))
+	defer prometheus.Unregister(requests)
+
+	s := NewWHIPServer(nil)
+	err := s.Start(conf, func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, readyFunc, endedFunc, error) {
+		return nil, nil, nil, errors.ErrIngressNotFound
+	}, nil)
+	require.Error(t, err)
+	require.Error(t, s.ctx.Err())
+
+	// The collectors registered before the failure are not left behind
+	collisions := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "whip_ssrc_collisions",
+		ConstLabels: stats.NodeLabels(conf),
+	})
+	require.NoError(t, prometheus.Register(collisions))
+	prometheus.Unregister(collisions)
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionTokens issues and verifies the tokens proving ownership of a WHIP resource. Tokens are signed
+// with a secret shared by all the nodes, so that any node can verify them without session state.
+type sessionTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newSessionTokens(secret string, ttl time.Duration) *sessionTokens {
+	return &sessionTokens{
+		secret: []byte(secret),
+		ttl:    ttl,
+	}
+}
+
+// Issue returns a token for the resource, valid for the TTL
+func (t *sessionTokens) Issue(resourceId string, streamKey string, now time.Time) string {
+	expiry := strconv.FormatInt(now.Add(t.ttl).Unix(), 10)
+	return expiry + "." + base64.RawURLEncoding.EncodeToString(t.sign(resourceId, streamKey, expiry))
+}
+
+// Verify returns true if the token was issued for the resource and has not expired
+func (t *sessionTokens) Verify(token string, resourceId string, streamKey string, now time.Time) bool {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(decoded, t.sign(resourceId, streamKey, expiry))
+}
+
+func (t *sessionTokens) sign(resourceId string, streamKey string, expiry string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(resourceId + "\n" + streamKey + "\n" + expiry))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTokens(t *testing.T) {
+	tokens := newSessionTokens("0123456789abcdef0123456789abcdef", time.Minute)
+	now := time.Now()
+
+	token := tokens.Issue("WH_resource", "key", now)
+	require.True(t, tokens.Verify(token, "WH_resource", "key", now))
+	require.True(t, tokens.Verify(token, "WH_resource", "key", now.Add(59*time.Second)))
+
+	require.False(t, tokens.Verify(token, "WH_resource", "key", now.Add(time.Minute)), "expired")
+	require.False(t, tokens.Verify(token, "WH_other", "key", now), "other resource")
+	require.False(t, tokens.Verify(token, "WH_resource", "other", now), "other stream key")
+	require.False(t, tokens.Verify("", "WH_resource", "key", now), "missing")
+
+	expiry, signature, _ := strings.Cut(token, ".")
+	require.False(t, tokens.Verify(strings.Replace(token, expiry, expiry+"0", 1), "WH_resource", "key", now), "extended expiry")
+	require.False(t, tokens.Verify(expiry+"."+signature[1:], "WH_resource", "key", now), "truncated signature")
+
+	other := newSessionTokens("fedcba9876543210fedcba9876543210", time.Minute)
+	require.False(t, other.Verify(token, "WH_resource", "key", now), "other secret")
+}
@@ -0,0 +1,251 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+const (
+	SessionEventStarted = "whip_session_started"
+	SessionEventEnded   = "whip_session_ended"
+
+	// Attempts to deliver an event, the delay between attempts doubling from the minimum to the maximum
+	sessionWebhookMaxAttempts  = 5
+	sessionWebhookRetryWaitMin = 500 * time.Millisecond
+	sessionWebhookRetryWaitMax = 8 * time.Second
+
+	sessionWebhookRequestTimeout = 10 * time.Second
+	// Time allowed to deliver the queued events when the server stops
+	sessionWebhookDrainTimeout  = 5 * time.Second
+	sessionWebhookTokenValidity = 5 * time.Minute
+	sessionWebhookEventPrefix   = "WHE_"
+)
+
+// SessionEvent is posted to the session webhook when a WHIP session has all its tracks ready, and when it ends
+type SessionEvent struct {
+	ID              string  `json:"id"`
+	Event           string  `json:"event"`
+	CreatedAt       int64   `json:"created_at"`
+	App             string  `json:"app"`
+	ResourceID      string  `json:"resource_id"`
+	StreamKey       string  `json:"stream_key"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	Region          string  `json:"region,omitempty"`
+	Cluster         string  `json:"cluster,omitempty"`
+}
+
+func newSessionEvent(event string, h *whipHandler, conf *config.Config) *SessionEvent {
+	return &SessionEvent{
+		ID:         utils.NewGuid(sessionWebhookEventPrefix),
+		Event:      event,
+		CreatedAt:  time.Now().Unix(),
+		App:        h.app,
+		ResourceID: h.resourceId,
+		StreamKey:  h.streamKey,
+		Region:     conf.Region,
+		Cluster:    conf.Cluster,
+	}
+}
+
+// sessionNotifier posts session events to a webhook URL, signed like the LiveKit server webhooks: the
+// Authorization header is a token of the API key, carrying the SHA-256 of the body. Events are delivered in
+// order by a single worker, retried with backoff, and dropped when the queue is full, so that a slow or
+// failing receiver never holds a session.
+type sessionNotifier struct {
+	url       string
+	apiKey    string
+	apiSecret string
+	client    *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock   sync.Mutex
+	queue  chan *SessionEvent
+	closed bool
+	done   chan struct{}
+}
+
+// newSessionNotifiers returns the notifiers of the apps with their own webhook URL or signing key, keyed by
+// app, and the notifier of the global webhook keyed by the empty app, if any
+func newSessionNotifiers(conf *config.Config) map[string]*sessionNotifier {
+	notifiers := make(map[string]*sessionNotifier)
+	if conf.WHIP.SessionWebhookURL != "" {
+		notifiers[""] = newSessionNotifier(conf.WHIP.SessionWebhookURL, conf.ApiKey, conf.ApiSecret, conf.WHIP.SessionWebhookQueueSize)
+	}
+
+	for app, appConf := range conf.WHIP.Apps {
+		url, apiKey, apiSecret := conf.WHIP.SessionWebhookURL, conf.ApiKey, conf.ApiSecret
+		if appConf.SessionWebhookURL != "" {
+			url = appConf.SessionWebhookURL
+		}
+		if appConf.SessionWebhookAPIKey != "" {
+			apiKey, apiSecret = appConf.SessionWebhookAPIKey, appConf.SessionWebhookAPISecret
+		}
+		if url == "" || (url == conf.WHIP.SessionWebhookURL && apiKey == conf.ApiKey) {
+			// No webhook, or the global one
+			continue
+		}
+		notifiers[app] = newSessionNotifier(url, apiKey, apiSecret, conf.WHIP.SessionWebhookQueueSize)
+	}
+
+	return notifiers
+}
+
+func newSessionNotifier(url string, apiKey string, apiSecret string, queueSize int) *sessionNotifier {
+	n := &sessionNotifier{
+		url:       url,
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		client:    &http.Client{Timeout: sessionWebhookRequestTimeout},
+		queue:     make(chan *SessionEvent, queueSize),
+		done:      make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	go n.run()
+
+	return n
+}
+
+// Notify queues the event without blocking
+func (n *sessionNotifier) Notify(event *SessionEvent) {
+	if n == nil {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		logger.Warnw("WHIP session webhook queue full, dropping event", nil, "event", event.Event, "resourceID", event.ResourceID)
+	}
+}
+
+// Stop delivers the queued events for a little while, then drops the remaining ones
+func (n *sessionNotifier) Stop() {
+	if n == nil {
+		return
+	}
+
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.lock.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(sessionWebhookDrainTimeout):
+		logger.Infow("WHIP session webhook events not delivered before shutdown", "pending", len(n.queue))
+		n.cancel()
+		<-n.done
+	}
+	n.cancel()
+}
+
+func (n *sessionNotifier) run() {
+	defer close(n.done)
+
+	for event := range n.queue {
+		if n.ctx.Err() != nil {
+			continue
+		}
+		if err := n.deliver(event); err != nil {
+			logger.Warnw("failed delivering WHIP session webhook", err, "event", event.Event, "resourceID", event.ResourceID, "url", n.url)
+		}
+	}
+}
+
+func (n *sessionNotifier) deliver(event *SessionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	wait := sessionWebhookRetryWaitMin
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		retryable, err = n.send(body)
+		if err == nil || !retryable || attempt == sessionWebhookMaxAttempts {
+			return err
+		}
+
+		logger.Debugw("retrying WHIP session webhook", "event", event.Event, "resourceID", event.ResourceID, "attempt", attempt, "error", err)
+		select {
+		case <-n.ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, sessionWebhookRetryWaitMax)
+	}
+}
+
+// send posts the event once, and returns whether a failure is worth retrying
+func (n *sessionNotifier) send(body []byte) (bool, error) {
+	sum := sha256.Sum256(body)
+	token, err := auth.NewAccessToken(n.apiKey, n.apiSecret).
+		SetValidFor(sessionWebhookTokenValidity).
+		SetSha256(base64.StdEncoding.EncodeToString(sum[:])).
+		ToJWT()
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", token)
+	// A custom type, so that receivers check the signature before parsing
+	req.Header.Set("Content-Type", "application/webhook+json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return false, nil
+}
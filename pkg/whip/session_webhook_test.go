@@ -0,0 +1,191 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/protocol/auth"
+)
+
+func TestSessionNotifier(t *testing.T) {
+	var attempts atomic.Int32
+	events := make(chan *SessionEvent, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and is retried
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "application/webhook+json", r.Header.Get("Content-Type"))
+
+		v, err := auth.ParseAPIToken(r.Header.Get("Authorization"))
+		require.NoError(t, err)
+		require.Equal(t, "key", v.APIKey())
+		claims, err := v.Verify("secret")
+		require.NoError(t, err)
+		sum := sha256.Sum256(body)
+		require.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), claims.Sha256)
+
+		var event SessionEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		events <- &event
+	}))
+	defer srv.Close()
+
+	n := newSessionNotifier(srv.URL, "key", "secret", 10)
+	h := &whipHandler{app: "live", resourceId: "WH_1", streamKey: "stream"}
+	conf := &config.Config{ServiceConfig: &config.ServiceConfig{Region: "eu-west", Cluster: "a"}}
+	n.Notify(newSessionEvent(SessionEventStarted, h, conf))
+	ended := newSessionEvent(SessionEventEnded, h, conf)
+	ended.Error = "failed"
+	n.Notify(ended)
+	n.Stop()
+
+	require.Len(t, events, 2)
+	started := <-events
+	require.Equal(t, SessionEventStarted, started.Event)
+	require.Equal(t, "WH_1", started.ResourceID)
+	require.Equal(t, "stream", started.StreamKey)
+	require.Equal(t, "eu-west", started.Region)
+	require.Equal(t, "a", started.Cluster)
+	require.Equal(t, SessionEventEnded, (<-events).Event)
+	require.Equal(t, int32(3), attempts.Load())
+
+	// Events are dropped once stopped
+	n.Notify(newSessionEvent(SessionEventStarted, h, conf))
+}
+
+func TestSessionEndReportedOnStop(t *testing.T) {
+	events := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SessionEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event.Event
+	}))
+	defer srv.Close()
+
+	s := newTestWHIPServer(nil)
+	s.sessionHooks = map[string]*sessionNotifier{"": newSessionNotifier(srv.URL, "key", "secret", 10)}
+	h := &whipHandler{app: "live", resourceId: "WH_1", streamKey: "stream", done: make(chan struct{})}
+	require.NoError(t, s.addHandler(h.resourceId, h))
+
+	// Like the session goroutine, the end is reported once the server context is canceled
+	go func() {
+		<-s.ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		s.handlersLock.Lock()
+		s.removeHandler(h.resourceId, h)
+		s.handlersLock.Unlock()
+		s.getSessionHook(h.app).Notify(newSessionEvent(SessionEventEnded, h, s.conf))
+		close(h.done)
+	}()
+
+	s.Stop()
+	require.Len(t, events, 1)
+	require.Equal(t, SessionEventEnded, <-events)
+}
+
+func TestPerAppSessionNotifiers(t *testing.T) {
+	type received struct {
+		apiKey string
+		event  SessionEvent
+	}
+	newReceiver := func(secrets map[string]string) (*httptest.Server, chan received) {
+		events := make(chan received, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			v, err := auth.ParseAPIToken(r.Header.Get("Authorization"))
+			require.NoError(t, err)
+			// Only the secret of the key verifies the event
+			claims, err := v.Verify(secrets[v.APIKey()])
+			require.NoError(t, err)
+			sum := sha256.Sum256(body)
+			require.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), claims.Sha256)
+
+			var event SessionEvent
+			require.NoError(t, json.Unmarshal(body, &event))
+			events <- received{apiKey: v.APIKey(), event: event}
+		}))
+		return srv, events
+	}
+
+	global, globalEvents := newReceiver(map[string]string{"key": "secret", "signed_key": "signed_secret"})
+	defer global.Close()
+	tenant, tenantEvents := newReceiver(map[string]string{"tenant_key": "tenant_secret"})
+	defer tenant.Close()
+
+	conf := &config.Config{ServiceConfig: &config.ServiceConfig{ApiKey: "key", ApiSecret: "secret"}}
+	conf.WHIP.SessionWebhookURL = global.URL
+	conf.WHIP.SessionWebhookQueueSize = 10
+	conf.WHIP.Apps = map[string]config.WHIPAppConfig{
+		"tenant":  {SessionWebhookURL: tenant.URL, SessionWebhookAPIKey: "tenant_key", SessionWebhookAPISecret: "tenant_secret"},
+		"signed":  {SessionWebhookAPIKey: "signed_key", SessionWebhookAPISecret: "signed_secret"},
+		"limited": {MaxSessions: 1},
+	}
+
+	s := newTestWHIPServer(nil)
+	s.sessionHooks = newSessionNotifiers(conf)
+	require.Len(t, s.sessionHooks, 3)
+	// Apps without a webhook of their own use the global one
+	require.Same(t, s.sessionHooks[""], s.getSessionHook("limited"))
+	require.Same(t, s.sessionHooks[""], s.getSessionHook("other"))
+
+	for _, app := range []string{"tenant", "signed", "other"} {
+		h := &whipHandler{app: app, resourceId: "WH_" + app}
+		s.getSessionHook(app).Notify(newSessionEvent(SessionEventStarted, h, conf))
+	}
+	for _, n := range s.sessionHooks {
+		n.Stop()
+	}
+
+	require.Len(t, tenantEvents, 1)
+	e := <-tenantEvents
+	require.Equal(t, "tenant_key", e.apiKey)
+	require.Equal(t, "tenant", e.event.App)
+
+	require.Len(t, globalEvents, 2)
+	byApp := make(map[string]string)
+	for range 2 {
+		e := <-globalEvents
+		byApp[e.event.App] = e.apiKey
+	}
+	require.Equal(t, map[string]string{"signed": "signed_key", "other": "key"}, byApp)
+}
+
+func TestNoSessionNotifiers(t *testing.T) {
+	// An app signing key alone does not enable the webhook
+	conf := &config.Config{ServiceConfig: &config.ServiceConfig{ApiKey: "key", ApiSecret: "secret"}}
+	conf.WHIP.Apps = map[string]config.WHIPAppConfig{"signed": {SessionWebhookAPIKey: "signed_key", SessionWebhookAPISecret: "signed_secret"}}
+	require.Empty(t, newSessionNotifiers(conf))
+
+	s := newTestWHIPServer(nil)
+	require.Nil(t, s.getSessionHook("signed"))
+}
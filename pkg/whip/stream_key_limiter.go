@@ -0,0 +1,80 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"sync"
+	"time"
+)
+
+// streamKeyLimiter tracks the distinct stream keys attempted by each source IP over a rolling
+// window, to reject clients scanning for valid keys
+type streamKeyLimiter struct {
+	maxKeys int
+	window  time.Duration
+
+	lock      sync.Mutex
+	attempts  map[string]map[string]time.Time // ip -> stream key -> last attempt
+	lastSweep time.Time
+}
+
+func newStreamKeyLimiter(maxKeys int, window time.Duration) *streamKeyLimiter {
+	return &streamKeyLimiter{
+		maxKeys:  maxKeys,
+		window:   window,
+		attempts: make(map[string]map[string]time.Time),
+	}
+}
+
+// Allow records an attempt and returns the number of distinct keys attempted by the IP in the
+// window, and whether the attempt is within the limit. Retrying a key already attempted is always allowed.
+func (l *streamKeyLimiter) Allow(ip string, streamKey string, now time.Time) (int, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) > l.window {
+		for k, keys := range l.attempts {
+			l.evict(k, keys, now)
+		}
+		l.lastSweep = now
+	}
+
+	keys := l.attempts[ip]
+	if keys == nil {
+		keys = make(map[string]time.Time)
+		l.attempts[ip] = keys
+	} else {
+		l.evict(ip, keys, now)
+	}
+
+	if _, ok := keys[streamKey]; !ok && len(keys) >= l.maxKeys {
+		return len(keys), false
+	}
+	keys[streamKey] = now
+	l.attempts[ip] = keys
+
+	return len(keys), true
+}
+
+func (l *streamKeyLimiter) evict(ip string, keys map[string]time.Time, now time.Time) {
+	for k, t := range keys {
+		if now.Sub(t) > l.window {
+			delete(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		delete(l.attempts, ip)
+	}
+}
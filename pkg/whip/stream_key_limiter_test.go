@@ -0,0 +1,48 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamKeyLimiter(t *testing.T) {
+	l := newStreamKeyLimiter(2, time.Minute)
+	now := time.Now()
+
+	_, ok := l.Allow("1.2.3.4", "key1", now)
+	require.True(t, ok)
+	_, ok = l.Allow("1.2.3.4", "key2", now)
+	require.True(t, ok)
+
+	count, ok := l.Allow("1.2.3.4", "key3", now)
+	require.False(t, ok)
+	require.Equal(t, 2, count)
+
+	// Retrying a known key and other IPs are not affected
+	_, ok = l.Allow("1.2.3.4", "key1", now.Add(30*time.Second))
+	require.True(t, ok)
+	_, ok = l.Allow("5.6.7.8", "key3", now)
+	require.True(t, ok)
+
+	// key2 expires first since key1 was retried
+	_, ok = l.Allow("1.2.3.4", "key3", now.Add(time.Minute+time.Second))
+	require.True(t, ok)
+	_, ok = l.Allow("1.2.3.4", "key4", now.Add(time.Minute+time.Second))
+	require.False(t, ok)
+}
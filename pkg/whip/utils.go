@@ -1,13 +1,24 @@
 package whip
 
 import (
-	"regexp"
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/server-sdk-go/v2/pkg/jitter"
+	"github.com/pion/dtls/v2"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/sdp/v3"
@@ -34,7 +45,7 @@ func createDepacketizer(track *webrtc.TrackRemote) (rtp.Depacketizer, error) {
 	return depacketizer, nil
 }
 
-func createJitterBuffer(track *webrtc.TrackRemote, logger logger.Logger, writePLI func(ssrc webrtc.SSRC)) (*jitter.Buffer, error) {
+func createJitterBuffer(track *webrtc.TrackRemote, logger logger.Logger, writePLI func(ssrc webrtc.SSRC), targetLatency time.Duration) (*jitter.Buffer, error) {
 	var maxLatency time.Duration
 	options := []jitter.Option{jitter.WithLogger(logger)}
 
@@ -60,6 +71,10 @@ func createJitterBuffer(track *webrtc.TrackRemote, logger logger.Logger, writePL
 		return nil, errors.ErrUnsupportedDecodeMimeType(track.Codec().MimeType)
 	}
 
+	if targetLatency > 0 {
+		maxLatency = targetLatency
+	}
+
 	clockRate := track.Codec().ClockRate
 
 	jb := jitter.NewBuffer(depacketizer, clockRate, maxLatency, options...)
@@ -91,16 +106,302 @@ func extractICEDetails(in []byte) (ufrag string, pwd string, err error) {
 	return
 }
 
+// validateICECredentials checks that the offer carries a single ice-ufrag/ice-pwd pair,
+// either at the session level or in the media sections
+func validateICECredentials(parsed *sdp.SessionDescription) error {
+	var ufrags, pwds []string
+	scanAttributes := func(attributes []sdp.Attribute) {
+		for _, a := range attributes {
+			switch a.Key {
+			case "ice-ufrag":
+				ufrags = append(ufrags, strings.TrimSpace(a.Value))
+			case "ice-pwd":
+				pwds = append(pwds, strings.TrimSpace(a.Value))
+			}
+		}
+	}
+
+	scanAttributes(parsed.Attributes)
+	for _, m := range parsed.MediaDescriptions {
+		scanAttributes(m.Attributes)
+	}
+
+	switch {
+	case len(ufrags) == 0 || slices.Contains(ufrags, ""):
+		return errors.ErrInvalidWHIPOfferReason("missing ice-ufrag")
+	case len(pwds) == 0 || slices.Contains(pwds, ""):
+		return errors.ErrInvalidWHIPOfferReason("missing ice-pwd")
+	case slices.ContainsFunc(ufrags, func(ufrag string) bool { return ufrag != ufrags[0] }):
+		return errors.ErrInvalidWHIPOfferReason("conflicting ice-ufrag values")
+	case slices.ContainsFunc(pwds, func(pwd string) bool { return pwd != pwds[0] }):
+		return errors.ErrInvalidWHIPOfferReason("conflicting ice-pwd values")
+	}
+
+	return nil
+}
+
+// getOfferedTrackLabels returns the msid track identifier of each offered media section,
+// falling back to the media stream identifier if the track identifier is missing
+func getOfferedTrackLabels(parsed *sdp.SessionDescription) map[types.StreamKind]string {
+	labels := make(map[types.StreamKind]string)
+
+	for _, m := range parsed.MediaDescriptions {
+		kind := types.StreamKind(m.MediaName.Media)
+		if (kind != types.Audio && kind != types.Video) || !isSendingMedia(parsed, m) {
+			continue
+		}
+
+		fields := strings.Fields(getMSID(m))
+		switch {
+		case len(fields) >= 2:
+			labels[kind] = fields[1]
+		case len(fields) == 1 && fields[0] != "-":
+			labels[kind] = fields[0]
+		}
+	}
+
+	return labels
+}
+
+// getMSID returns the msid attribute of the media section, or the legacy ssrc msid attribute
+func getMSID(m *sdp.MediaDescription) string {
+	var msid string
+	for _, a := range m.Attributes {
+		if a.Key == "msid" {
+			return a.Value
+		}
+		if a.Key == sdp.AttrKeySSRC && msid == "" {
+			// Legacy a=ssrc:<ssrc> msid:<stream id> <track id>
+			if _, v, ok := strings.Cut(a.Value, " msid:"); ok {
+				msid = v
+			}
+		}
+	}
+
+	return msid
+}
+
+// negotiatedTrack describes a media section accepted in the answer
+type negotiatedTrack struct {
+	Kind  types.StreamKind `json:"kind"`
+	Codec string           `json:"codec"`
+	Mid   string           `json:"mid"`
+	MSID  string           `json:"msid,omitempty"`
+}
+
+// getNegotiatedTracks returns the media sections the offerer sends that are accepted in the answer,
+// with the preferred codec of the answer and the msid of the offer
+func getNegotiatedTracks(offer *sdp.SessionDescription, answer *sdp.SessionDescription) []negotiatedTrack {
+	var tracks []negotiatedTrack
+	for i, m := range answer.MediaDescriptions {
+		kind := types.StreamKind(m.MediaName.Media)
+		if (kind != types.Audio && kind != types.Video) || m.MediaName.Port.Value == 0 || i >= len(offer.MediaDescriptions) {
+			continue
+		}
+		if !isSendingMedia(offer, offer.MediaDescriptions[i]) {
+			continue
+		}
+
+		t := negotiatedTrack{
+			Kind: kind,
+			MSID: getMSID(offer.MediaDescriptions[i]),
+		}
+		t.Mid, _ = m.Attribute(sdp.AttrKeyMID)
+		if len(m.MediaName.Formats) > 0 {
+			for _, a := range m.Attributes {
+				// a=rtpmap:<payload type> <encoding name>/<clock rate>
+				pt, rtpmap, ok := strings.Cut(a.Value, " ")
+				if a.Key == "rtpmap" && ok && pt == m.MediaName.Formats[0] {
+					name, _, _ := strings.Cut(rtpmap, "/")
+					t.Codec = string(kind) + "/" + name
+					break
+				}
+			}
+		}
+		tracks = append(tracks, t)
+	}
+
+	return tracks
+}
+
+// getAdvertisedBitrates returns the bitrate in bps advertised by the offer for each kind of media it
+// sends, from the b=TIAS or b=AS line of the media section, or else the sum of the max-br restrictions
+// of its simulcast rids. Media without an advertised bitrate is omitted.
+func getAdvertisedBitrates(parsed *sdp.SessionDescription) map[types.StreamKind]uint64 {
+	bitrates := make(map[types.StreamKind]uint64)
+	for _, m := range parsed.MediaDescriptions {
+		kind := types.StreamKind(m.MediaName.Media)
+		if (kind != types.Audio && kind != types.Video) || !isSendingMedia(parsed, m) {
+			continue
+		}
+
+		var bitrate uint64
+		for _, b := range m.Bandwidth {
+			switch b.Type {
+			case "TIAS":
+				bitrate = b.Bandwidth
+			case "AS":
+				if bitrate == 0 {
+					bitrate = b.Bandwidth * 1000
+				}
+			}
+		}
+		if bitrate == 0 {
+			for _, a := range m.Attributes {
+				// a=rid:<id> send <restriction>=<value>;...
+				fields := strings.Fields(a.Value)
+				if a.Key != "rid" || len(fields) < 3 || fields[1] != "send" {
+					continue
+				}
+				for _, r := range strings.Split(fields[2], ";") {
+					if v, ok := strings.CutPrefix(r, "max-br="); ok {
+						if br, err := strconv.ParseUint(v, 10, 64); err == nil {
+							bitrate += br
+						}
+					}
+				}
+			}
+		}
+
+		if bitrate > 0 {
+			bitrates[kind] += bitrate
+		}
+	}
+
+	return bitrates
+}
+
+// acceptsJSON returns true if the Accept header lists application/json
+func acceptsJSON(accept string) bool {
+	for _, v := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(v); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// getICEServerLinks returns the Link header values advertising ICE servers to WHIP clients, one per URL
+//
+// https://www.ietf.org/archive/id/draft-ietf-wish-whip-14.html#name-stun-turn-server-configurat
+func getICEServerLinks(servers []webrtc.ICEServer) []string {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	var links []string
+	for _, server := range servers {
+		for _, u := range server.URLs {
+			link := fmt.Sprintf(`<%s>; rel="ice-server"`, u)
+			if server.Username != "" {
+				link += fmt.Sprintf(`; username="%s"`, quote.Replace(server.Username))
+			}
+			// OAuth credentials are not supported by WHIP clients
+			if credential, ok := server.Credential.(string); ok && credential != "" {
+				link += fmt.Sprintf(`; credential="%s"; credential-type="password"`, quote.Replace(credential))
+			}
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
 func ScherbanExtractDetails(frag string) (ufrag string, pwd string, err error) {
-	ufragPattern := regexp.MustCompile(`(a=ice-ufrag:.*)`)
-	passPattern := regexp.MustCompile(`(a=ice-pwd.*)`)
-	ufrag = strings.TrimSpace(strings.ReplaceAll(ufragPattern.FindString(frag), "a=ice-ufrag:", ""))
-	pwd = strings.TrimSpace(strings.ReplaceAll(passPattern.FindString(frag), "a=ice-pwd:", ""))
+	return scanSDPFragICEDetails(strings.NewReader(frag), 0)
+}
+
+// sdpFrag is the content of a trickle-ice-sdpfrag body
+type sdpFrag struct {
+	ufrag      string
+	pwd        string
+	candidates []webrtc.ICECandidateInit
+}
+
+// scanSDPFragICEDetails reads the ice-ufrag and ice-pwd of a trickle-ice-sdpfrag body line by line,
+// failing with ErrSDPFragTooLarge after maxCandidates candidate lines. 0 for no candidate limit.
+func scanSDPFragICEDetails(r io.Reader, maxCandidates int) (ufrag string, pwd string, err error) {
+	frag, err := scanSDPFrag(r, maxCandidates)
+	if err != nil {
+		return "", "", err
+	}
 
-	if ufrag == "" || pwd == "" {
+	if frag.ufrag == "" || frag.pwd == "" {
 		err = errors.New("could not extract ICE details")
 	}
-	return
+	return frag.ufrag, frag.pwd, err
+}
+
+// scanSDPFrag reads the ICE credentials and candidates of a trickle-ice-sdpfrag body line by line,
+// with the media section of each candidate. It fails with ErrSDPFragTooLarge after maxCandidates
+// candidate lines, 0 for no candidate limit, and on malformed candidate lines.
+func scanSDPFrag(r io.Reader, maxCandidates int) (*sdpFrag, error) {
+	frag := &sdpFrag{}
+
+	var mid string
+	mLineIndex := -1
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(l, "a=ice-ufrag:"):
+			if frag.ufrag == "" {
+				frag.ufrag = strings.TrimSpace(strings.TrimPrefix(l, "a=ice-ufrag:"))
+			}
+		case strings.HasPrefix(l, "a=ice-pwd:"):
+			if frag.pwd == "" {
+				frag.pwd = strings.TrimSpace(strings.TrimPrefix(l, "a=ice-pwd:"))
+			}
+		case strings.HasPrefix(l, "m="):
+			mLineIndex++
+			mid = ""
+		case strings.HasPrefix(l, "a=mid:"):
+			mid = strings.TrimSpace(strings.TrimPrefix(l, "a=mid:"))
+		case strings.HasPrefix(l, "a=candidate:"):
+			if maxCandidates > 0 && len(frag.candidates) >= maxCandidates {
+				return nil, errors.ErrSDPFragTooLarge
+			}
+
+			// a=candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+			candidate := strings.TrimPrefix(l, "a=")
+			if fields := strings.Fields(candidate); len(fields) < 8 || fields[6] != "typ" {
+				return nil, fmt.Errorf("malformed candidate %q", candidate)
+			}
+
+			c := webrtc.ICECandidateInit{Candidate: candidate}
+			if mid != "" {
+				sdpMid := mid
+				c.SDPMid = &sdpMid
+			}
+			if mLineIndex >= 0 {
+				index := uint16(mLineIndex)
+				c.SDPMLineIndex = &index
+			}
+			frag.candidates = append(frag.candidates, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, bufio.ErrTooLong) {
+			return nil, errors.ErrSDPFragTooLarge
+		}
+		return nil, err
+	}
+
+	return frag, nil
+}
+
+// getICEUfrag returns the ice-ufrag of a session description, from the session level or else the first media section
+func getICEUfrag(parsed *sdp.SessionDescription) string {
+	if ufrag, ok := parsed.Attribute("ice-ufrag"); ok {
+		return strings.TrimSpace(ufrag)
+	}
+	for _, m := range parsed.MediaDescriptions {
+		if ufrag, ok := m.Attribute("ice-ufrag"); ok {
+			return strings.TrimSpace(ufrag)
+		}
+	}
+
+	return ""
 }
 
 func replaceICEDetails(in, ufrag, pwd string) (string, error) {
@@ -131,3 +432,884 @@ func replaceICEDetails(in, ufrag, pwd string) (string, error) {
 
 	return string(newRemoteDescription), nil
 }
+
+// filterRTCPFeedback removes the video rtcp-fb attributes not listed in supported, given as "<type> [<parameter>]"
+func filterRTCPFeedback(in string, supported []string) (string, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(in); err != nil {
+		return "", err
+	}
+
+	isSupported := func(value string) bool {
+		// <payload type> <type> [<parameter>]
+		_, fb, ok := strings.Cut(value, " ")
+		if !ok {
+			return false
+		}
+
+		fb = strings.Join(strings.Fields(fb), " ")
+		for _, s := range supported {
+			if strings.EqualFold(fb, s) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Media != "video" {
+			continue
+		}
+
+		attributes := m.Attributes[:0]
+		for _, a := range m.Attributes {
+			if a.Key == "rtcp-fb" && !isSupported(a.Value) {
+				logger.Debugw("removing unsupported RTCP feedback from offer", "rtcpFeedback", a.Value)
+				continue
+			}
+			attributes = append(attributes, a)
+		}
+		m.Attributes = attributes
+	}
+
+	out, err := parsed.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// preferCodec removes the other media codecs of the kind from the offer when the preferred one is offered,
+// so that the answer selects it. Retransmission and FEC formats of the preferred codec are kept.
+// It returns the offer unchanged if the preferred codec is not offered.
+func preferCodec(in string, kind types.StreamKind, mimeType string) (string, bool, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(in); err != nil {
+		return "", false, err
+	}
+
+	var changed bool
+	for _, m := range parsed.MediaDescriptions {
+		if types.StreamKind(m.MediaName.Media) != kind {
+			continue
+		}
+
+		names := make(map[string]string)
+		apts := make(map[string]string)
+		for _, a := range m.Attributes {
+			pt, value, ok := strings.Cut(a.Value, " ")
+			if !ok {
+				continue
+			}
+			switch a.Key {
+			case "rtpmap":
+				name, _, _ := strings.Cut(value, "/")
+				names[pt] = name
+			case "fmtp":
+				for _, param := range strings.Split(value, ";") {
+					if apt, ok := strings.CutPrefix(strings.TrimSpace(param), "apt="); ok {
+						apts[pt] = apt
+					}
+				}
+			}
+		}
+
+		isPreferred := func(pt string) bool {
+			name, ok := names[pt]
+			if !ok && pt == "8" {
+				// Static payload type
+				name = "PCMA"
+			}
+			return strings.EqualFold(string(kind)+"/"+name, mimeType)
+		}
+
+		if !slices.ContainsFunc(m.MediaName.Formats, isPreferred) {
+			continue
+		}
+
+		removed := make(map[string]bool)
+		for _, pt := range m.MediaName.Formats {
+			switch strings.ToLower(names[pt]) {
+			case "rtx", "red", "ulpfec", "flexfec-03":
+			default:
+				if !isPreferred(pt) {
+					removed[pt] = true
+				}
+			}
+		}
+		for pt, apt := range apts {
+			if removed[apt] {
+				removed[pt] = true
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+
+		m.MediaName.Formats = slices.DeleteFunc(m.MediaName.Formats, func(pt string) bool { return removed[pt] })
+		m.Attributes = slices.DeleteFunc(m.Attributes, func(a sdp.Attribute) bool {
+			switch a.Key {
+			case "rtpmap", "fmtp", "rtcp-fb":
+				pt, _, _ := strings.Cut(a.Value, " ")
+				return removed[pt]
+			}
+			return false
+		})
+		changed = true
+	}
+
+	if !changed {
+		return in, false, nil
+	}
+
+	out, err := parsed.Marshal()
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(out), true, nil
+}
+
+// getTURNServer returns the address of the TURN server relaying the media, favoring our own
+// relay candidate over the client's. It is empty for host and srflx connections.
+func getTURNServer(pair *webrtc.ICECandidatePair) string {
+	for _, c := range []*webrtc.ICECandidate{pair.Local, pair.Remote} {
+		if c != nil && c.Typ == webrtc.ICECandidateTypeRelay {
+			return c.Address
+		}
+	}
+
+	return ""
+}
+
+type audioFormat struct {
+	mimeType   string
+	sampleRate uint32
+	channels   uint32
+}
+
+// getOfferedAudioFormat returns the format of the first audio codec of the offer that we support,
+// which is the one that will be negotiated
+func getOfferedAudioFormat(parsed *sdp.SessionDescription) (audioFormat, bool) {
+	for _, m := range parsed.MediaDescriptions {
+		if types.StreamKind(m.MediaName.Media) != types.Audio || !isSendingMedia(parsed, m) {
+			continue
+		}
+
+		rtpmaps := make(map[string]string)
+		fmtps := make(map[string]string)
+		for _, a := range m.Attributes {
+			pt, value, ok := strings.Cut(a.Value, " ")
+			if !ok {
+				continue
+			}
+			switch a.Key {
+			case "rtpmap":
+				rtpmaps[pt] = value
+			case "fmtp":
+				fmtps[pt] = value
+			}
+		}
+
+		for _, pt := range m.MediaName.Formats {
+			rtpmap, ok := rtpmaps[pt]
+			if !ok && pt == "8" {
+				// Static payload type
+				rtpmap = "PCMA/8000"
+			}
+
+			// <encoding name>/<clock rate>[/<channels>]
+			parts := strings.Split(rtpmap, "/")
+			if len(parts) < 2 {
+				continue
+			}
+
+			var mimeType string
+			switch {
+			case strings.EqualFold(parts[0], "opus"):
+				mimeType = webrtc.MimeTypeOpus
+			case strings.EqualFold(parts[0], "PCMA"):
+				mimeType = webrtc.MimeTypePCMA
+			default:
+				continue
+			}
+
+			clockRate, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				continue
+			}
+
+			f := getAudioFormat(mimeType, uint32(clockRate), fmtps[pt])
+			if mimeType != webrtc.MimeTypeOpus && len(parts) > 2 {
+				if channels, err := strconv.ParseUint(parts[2], 10, 32); err == nil {
+					f.channels = uint32(channels)
+				}
+			}
+
+			return f, true
+		}
+	}
+
+	return audioFormat{}, false
+}
+
+// getAudioFormat uses the sender properties from the fmtp line for Opus, as its rtpmap
+// is always opus/48000/2 (https://datatracker.ietf.org/doc/html/rfc7587#section-7)
+func getAudioFormat(mimeType string, clockRate uint32, fmtp string) audioFormat {
+	f := audioFormat{
+		mimeType:   mimeType,
+		sampleRate: clockRate,
+		channels:   1,
+	}
+
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeOpus) {
+		return f
+	}
+
+	for _, p := range strings.Split(fmtp, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch k {
+		case "sprop-stereo":
+			if v == "1" {
+				f.channels = 2
+			}
+		case "sprop-maxcapturerate":
+			if rate, err := strconv.ParseUint(v, 10, 32); err == nil && rate > 0 && uint32(rate) < clockRate {
+				f.sampleRate = uint32(rate)
+			}
+		}
+	}
+
+	return f
+}
+
+// filterRTCPReducedSize only keeps a=rtcp-rsize in the answer media sections if enabled and
+// present in the matching offer media section
+func filterRTCPReducedSize(answer string, offer string, enabled bool) (string, error) {
+	var parsedAnswer, parsedOffer sdp.SessionDescription
+	if err := parsedAnswer.UnmarshalString(answer); err != nil {
+		return "", err
+	}
+	if err := parsedOffer.UnmarshalString(offer); err != nil {
+		return "", err
+	}
+
+	offered := make(map[string]bool)
+	for _, m := range parsedOffer.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		_, offered[mid] = m.Attribute(sdp.AttrKeyRTCPRsize)
+	}
+
+	for _, m := range parsedAnswer.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		if enabled && offered[mid] {
+			continue
+		}
+
+		attributes := m.Attributes[:0]
+		for _, a := range m.Attributes {
+			if a.Key != sdp.AttrKeyRTCPRsize {
+				attributes = append(attributes, a)
+			}
+		}
+		m.Attributes = attributes
+	}
+
+	out, err := parsedAnswer.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// filterExtmapAllowMixed only keeps a=extmap-allow-mixed in the answer if enabled and present in the offer,
+// at the session level or in any media section. Without it, the client must only use one-byte header extensions.
+func filterExtmapAllowMixed(answer string, offer string, enabled bool) (string, error) {
+	var parsedAnswer, parsedOffer sdp.SessionDescription
+	if err := parsedAnswer.UnmarshalString(answer); err != nil {
+		return "", err
+	}
+	if err := parsedOffer.UnmarshalString(offer); err != nil {
+		return "", err
+	}
+
+	_, offered := parsedOffer.Attribute(sdp.AttrKeyExtMapAllowMixed)
+	for _, m := range parsedOffer.MediaDescriptions {
+		if _, ok := m.Attribute(sdp.AttrKeyExtMapAllowMixed); ok {
+			offered = true
+		}
+	}
+	if enabled && offered {
+		return answer, nil
+	}
+
+	removeAttribute := func(attributes []sdp.Attribute) []sdp.Attribute {
+		res := attributes[:0]
+		for _, a := range attributes {
+			if a.Key != sdp.AttrKeyExtMapAllowMixed {
+				res = append(res, a)
+			}
+		}
+		return res
+	}
+
+	parsedAnswer.Attributes = removeAttribute(parsedAnswer.Attributes)
+	for _, m := range parsedAnswer.MediaDescriptions {
+		m.Attributes = removeAttribute(m.Attributes)
+	}
+
+	out, err := parsedAnswer.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// getAnswerModifications lists how the answer departs from the offer, e.g. "forced-recvonly" or "dropped-av1",
+// in a stable order. The local answer is the one generated by the media engine, before the answer builder ran.
+func getAnswerModifications(offer string, localAnswer string, answer string) ([]string, error) {
+	var parsedOffer, parsedLocalAnswer, parsedAnswer sdp.SessionDescription
+	if err := parsedOffer.UnmarshalString(offer); err != nil {
+		return nil, err
+	}
+	if err := parsedLocalAnswer.UnmarshalString(localAnswer); err != nil {
+		return nil, err
+	}
+	if err := parsedAnswer.UnmarshalString(answer); err != nil {
+		return nil, err
+	}
+
+	var res []string
+	add := func(m string) {
+		if !slices.Contains(res, m) {
+			res = append(res, m)
+		}
+	}
+
+	hasAttribute := func(parsed *sdp.SessionDescription, key string) bool {
+		if _, ok := parsed.Attribute(key); ok {
+			return true
+		}
+		for _, m := range parsed.MediaDescriptions {
+			if _, ok := m.Attribute(key); ok {
+				return true
+			}
+		}
+		return false
+	}
+	attributeValues := func(m *sdp.MediaDescription, key string, value func(string) string) map[string]bool {
+		values := make(map[string]bool)
+		for _, a := range m.Attributes {
+			if a.Key == key {
+				values[value(a.Value)] = true
+			}
+		}
+		return values
+	}
+	// Drops the leading payload type or extension id
+	withoutID := func(v string) string {
+		if _, rest, ok := strings.Cut(v, " "); ok {
+			return rest
+		}
+		return v
+	}
+	codecName := func(v string) string {
+		name, _, _ := strings.Cut(withoutID(v), "/")
+		return strings.ToLower(name)
+	}
+
+	for i, om := range parsedOffer.MediaDescriptions {
+		if i >= len(parsedAnswer.MediaDescriptions) {
+			break
+		}
+		am := parsedAnswer.MediaDescriptions[i]
+
+		offeredDirection := getDirection(om)
+		if am.MediaName.Port.Value == 0 && om.MediaName.Port.Value != 0 {
+			add("rejected-" + om.MediaName.Media)
+			continue
+		}
+		if answeredDirection := getDirection(am); offeredDirection != "sendonly" && answeredDirection == "recvonly" {
+			add("forced-recvonly")
+		}
+
+		answeredCodecs := attributeValues(am, "rtpmap", codecName)
+		offeredCodecs := attributeValues(om, "rtpmap", codecName)
+		names := make([]string, 0, len(offeredCodecs))
+		for name := range offeredCodecs {
+			if !answeredCodecs[name] {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			add("dropped-" + name)
+		}
+
+		answeredFeedback := attributeValues(am, "rtcp-fb", withoutID)
+		for fb := range attributeValues(om, "rtcp-fb", withoutID) {
+			if !answeredFeedback[fb] {
+				add("dropped-rtcp-fb")
+				break
+			}
+		}
+
+		answeredExtensions := attributeValues(am, "extmap", withoutID)
+		for ext := range attributeValues(om, "extmap", withoutID) {
+			if !answeredExtensions[ext] {
+				add("dropped-header-extensions")
+				break
+			}
+		}
+	}
+
+	if hasAttribute(&parsedOffer, sdp.AttrKeyRTCPRsize) && !hasAttribute(&parsedAnswer, sdp.AttrKeyRTCPRsize) {
+		add("no-rtcp-rsize")
+	}
+	if hasAttribute(&parsedOffer, sdp.AttrKeyExtMapAllowMixed) && !hasAttribute(&parsedAnswer, sdp.AttrKeyExtMapAllowMixed) {
+		add("no-extmap-allow-mixed")
+	}
+
+	bitrateCapped := len(parsedAnswer.Bandwidth) > 0
+	for _, m := range parsedAnswer.MediaDescriptions {
+		if len(m.Bandwidth) > 0 {
+			bitrateCapped = true
+		}
+	}
+	if bitrateCapped {
+		add("bitrate-capped")
+	}
+
+	localCandidates, candidates := getCandidateAddresses(&parsedLocalAnswer), getCandidateAddresses(&parsedAnswer)
+	if len(candidates) < len(localCandidates) {
+		add("filtered-candidates")
+	} else if !slices.Equal(candidates, localCandidates) {
+		add("reordered-candidates")
+	}
+
+	return res, nil
+}
+
+// getDirection returns the direction attribute of a media section, sendrecv if missing
+func getDirection(m *sdp.MediaDescription) string {
+	for _, a := range m.Attributes {
+		switch a.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return a.Key
+		}
+	}
+	return "sendrecv"
+}
+
+// getCandidateAddresses returns the transport, address and port of the candidates, in order
+func getCandidateAddresses(parsed *sdp.SessionDescription) []string {
+	var res []string
+	for _, m := range parsed.MediaDescriptions {
+		for _, a := range m.Attributes {
+			if !a.IsICECandidate() {
+				continue
+			}
+			fields := strings.Fields(a.Value)
+			if len(fields) < 6 {
+				continue
+			}
+			res = append(res, strings.Join([]string{fields[2], fields[4], fields[5]}, " "))
+		}
+	}
+	return res
+}
+
+// getInterfaceIPs returns the addresses of each of the named network interfaces
+func getInterfaceIPs(names []string) ([][]net.IP, error) {
+	ips := make([][]net.IP, 0, len(names))
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		var ifaceIPs []net.IP
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ifaceIPs = append(ifaceIPs, ipNet.IP)
+			}
+		}
+		ips = append(ips, ifaceIPs)
+	}
+
+	return ips, nil
+}
+
+// prioritizeCandidates sorts the candidates of each media section by the index of the first
+// address group matching their address, or related address for server reflexive and relay candidates.
+// The local preference part of the candidate priority is rewritten to match the order, since clients
+// pick the candidate pair to nominate from priorities and not from the order in the SDP.
+// Candidates matching no group are kept last, in their original order.
+func prioritizeCandidates(in string, preferred [][]net.IP) string {
+	if len(preferred) == 0 {
+		return in
+	}
+
+	return rankCandidates(in, len(preferred), func(fields []string) int {
+		var addrs []net.IP
+		if ip := net.ParseIP(fields[4]); ip != nil {
+			addrs = append(addrs, ip)
+		}
+		for i := 8; i+1 < len(fields); i += 2 {
+			if fields[i] == "raddr" {
+				if ip := net.ParseIP(fields[i+1]); ip != nil {
+					addrs = append(addrs, ip)
+				}
+			}
+		}
+
+		for i, group := range preferred {
+			for _, ip := range addrs {
+				if slices.ContainsFunc(group, ip.Equal) {
+					return i
+				}
+			}
+		}
+		return len(preferred)
+	})
+}
+
+// filterCandidateFamilies removes the candidates whose address is not of one of the IP families,
+// and sorts the others by the index of their family, rewriting the local preference as prioritizeCandidates.
+// Candidates with an unresolved address, such as mDNS host names, are kept last.
+func filterCandidateFamilies(in string, families []string) string {
+	if len(families) == 0 {
+		return in
+	}
+
+	return rankCandidates(in, len(families), func(fields []string) int {
+		ip := net.ParseIP(fields[4])
+		if ip == nil {
+			return len(families)
+		}
+
+		family := config.WHIPIPFamilyIPv6
+		if ip.To4() != nil {
+			family = config.WHIPIPFamilyIPv4
+		}
+		return slices.Index(families, family)
+	})
+}
+
+// rankCandidates sorts the candidates of each media section by the rank returned for their fields,
+// lowest first, and rewrites the local preference of their priority accordingly. Candidates with a
+// negative rank are removed, and malformed ones are kept with the worst rank.
+func rankCandidates(in string, worst int, rank func(fields []string) int) string {
+	lines := strings.SplitAfter(in, "\n")
+
+	type candidate struct {
+		line string
+		rank int
+	}
+
+	sortSection := func(indexes []int) {
+		candidates := make([]candidate, 0, len(indexes))
+		for _, i := range indexes {
+			line := strings.TrimRight(lines[i], "\r\n")
+			eol := lines[i][len(line):]
+
+			// a=candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+			fields := strings.Fields(line)
+			if len(fields) < 8 {
+				candidates = append(candidates, candidate{line: lines[i], rank: worst})
+				continue
+			}
+
+			r := rank(fields)
+			if r < 0 {
+				candidates = append(candidates, candidate{rank: r})
+				continue
+			}
+			if priority, err := strconv.ParseUint(fields[3], 10, 32); err == nil {
+				localPreference := uint64(0xFFFF - r)
+				fields[3] = strconv.FormatUint(priority&0xFF0000FF|localPreference<<8, 10)
+			}
+			candidates = append(candidates, candidate{line: strings.Join(fields, " ") + eol, rank: r})
+		}
+
+		// removed candidates sort first and are blanked in place of the first lines of the section
+		slices.SortStableFunc(candidates, func(a, b candidate) int {
+			return a.rank - b.rank
+		})
+		for j, i := range indexes {
+			lines[i] = candidates[j].line
+		}
+	}
+
+	var indexes []int
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "m="):
+			sortSection(indexes)
+			indexes = indexes[:0]
+		case strings.HasPrefix(l, "a=candidate:"):
+			indexes = append(indexes, i)
+		}
+	}
+	sortSection(indexes)
+
+	return strings.Join(lines, "")
+}
+
+// limitCandidates keeps the maxPerType highest priority candidates of each type in every media
+// section, in their original order, and returns the number of candidates removed. Malformed
+// candidates are kept. 0 for no limit.
+func limitCandidates(in string, maxPerType int) (string, int) {
+	if maxPerType <= 0 {
+		return in, 0
+	}
+
+	lines := strings.SplitAfter(in, "\n")
+	removed := 0
+
+	trimSection := func(indexes []int) {
+		type candidate struct {
+			index    int
+			priority uint64
+		}
+
+		byType := make(map[string][]candidate)
+		for _, i := range indexes {
+			// a=candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+			fields := strings.Fields(lines[i])
+			if len(fields) < 8 {
+				continue
+			}
+			priority, _ := strconv.ParseUint(fields[3], 10, 32)
+			byType[fields[7]] = append(byType[fields[7]], candidate{index: i, priority: priority})
+		}
+
+		for _, candidates := range byType {
+			if len(candidates) <= maxPerType {
+				continue
+			}
+			slices.SortStableFunc(candidates, func(a, b candidate) int {
+				return cmp.Compare(b.priority, a.priority)
+			})
+			for _, c := range candidates[maxPerType:] {
+				lines[c.index] = ""
+				removed++
+			}
+		}
+	}
+
+	var indexes []int
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "m="):
+			trimSection(indexes)
+			indexes = indexes[:0]
+		case strings.HasPrefix(l, "a=candidate:"):
+			indexes = append(indexes, i)
+		}
+	}
+	trimSection(indexes)
+
+	return strings.Join(lines, ""), removed
+}
+
+// getHostCandidateIPs returns the address of each UDP host candidate. Candidates are the same in all
+// the bundled media sections, so only the first one with candidates is used.
+func getHostCandidateIPs(parsed *sdp.SessionDescription) []string {
+	for _, m := range parsed.MediaDescriptions {
+		var ips []string
+		for _, a := range m.Attributes {
+			if a.Key != "candidate" {
+				continue
+			}
+
+			// <foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+			fields := strings.Fields(a.Value)
+			if len(fields) >= 8 && strings.EqualFold(fields[2], "udp") && fields[7] == "host" {
+				ips = append(ips, fields[4])
+			}
+		}
+		if len(ips) > 0 {
+			return ips
+		}
+	}
+
+	return nil
+}
+
+// getBundleLayout returns the mids of the BUNDLE group, nil if there is none, and a
+// "<mid>:<media>" description of each media section, in order
+func getBundleLayout(parsed *sdp.SessionDescription) ([]string, []string) {
+	var bundle []string
+	for _, a := range parsed.Attributes {
+		if a.Key != sdp.AttrKeyGroup {
+			continue
+		}
+		if fields := strings.Fields(a.Value); len(fields) > 0 && fields[0] == "BUNDLE" {
+			bundle = append([]string{}, fields[1:]...)
+			break
+		}
+	}
+
+	media := make([]string, 0, len(parsed.MediaDescriptions))
+	for _, m := range parsed.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		media = append(media, mid+":"+m.MediaName.Media)
+	}
+
+	return bundle, media
+}
+
+// getUnbundledMedia returns the mid:kind of the media sections not in the BUNDLE group. Rejected sections are ignored.
+func getUnbundledMedia(parsed *sdp.SessionDescription) []string {
+	bundle, _ := getBundleLayout(parsed)
+
+	var unbundled []string
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		if mid == "" || !slices.Contains(bundle, mid) {
+			unbundled = append(unbundled, mid+":"+m.MediaName.Media)
+		}
+	}
+
+	return unbundled
+}
+
+// getNonMuxedMedia returns the mid:kind of the media sections without a=rtcp-mux. Rejected sections are ignored.
+func getNonMuxedMedia(parsed *sdp.SessionDescription) []string {
+	var nonMuxed []string
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, ok := m.Attribute(sdp.AttrKeyRTCPMux); !ok {
+			mid, _ := m.Attribute(sdp.AttrKeyMID)
+			nonMuxed = append(nonMuxed, mid+":"+m.MediaName.Media)
+		}
+	}
+
+	return nonMuxed
+}
+
+// getInsecureMedia returns the mid:kind:profile of the media sections not using DTLS-SRTP, and whether the
+// offer has no DTLS fingerprint to authenticate the handshake with. Rejected sections are ignored.
+func getInsecureMedia(parsed *sdp.SessionDescription) ([]string, bool) {
+	_, hasFingerprint := parsed.Attribute("fingerprint")
+
+	var insecure []string
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, ok := m.Attribute("fingerprint"); ok {
+			hasFingerprint = true
+		}
+
+		protos := m.MediaName.Protos
+		secure := slices.Contains(protos, "TLS") || slices.Contains(protos, "DTLS")
+		if slices.Contains(protos, "RTP") && !slices.Contains(protos, "SAVPF") && !slices.Contains(protos, "SAVP") {
+			secure = false
+		}
+		if !secure {
+			mid, _ := m.Attribute(sdp.AttrKeyMID)
+			insecure = append(insecure, mid+":"+m.MediaName.Media+":"+strings.Join(protos, "/"))
+		}
+	}
+
+	return insecure, !hasFingerprint
+}
+
+// isSendingMedia returns true if the offerer sends media in the media section, from its
+// direction attribute or else the session level one. The default direction is sendrecv.
+func isSendingMedia(parsed *sdp.SessionDescription, m *sdp.MediaDescription) bool {
+	getDirection := func(attributes []sdp.Attribute) string {
+		for _, a := range attributes {
+			switch a.Key {
+			case sdp.AttrKeySendRecv, sdp.AttrKeySendOnly, sdp.AttrKeyRecvOnly, sdp.AttrKeyInactive:
+				return a.Key
+			}
+		}
+		return ""
+	}
+
+	direction := getDirection(m.Attributes)
+	if direction == "" {
+		direction = getDirection(parsed.Attributes)
+	}
+
+	switch direction {
+	case sdp.AttrKeyRecvOnly, sdp.AttrKeyInactive:
+		return false
+	default:
+		return true
+	}
+}
+
+// getSSRCCollisions returns the SSRCs announced in more than one media section, in increasing order
+func getSSRCCollisions(parsed *sdp.SessionDescription) []uint32 {
+	sections := make(map[uint32]int)
+	var collisions []uint32
+
+	for i, m := range parsed.MediaDescriptions {
+		ssrcs := make(map[uint32]bool)
+		for _, a := range m.Attributes {
+			switch a.Key {
+			case sdp.AttrKeySSRC:
+				// a=ssrc:<ssrc> <attribute>
+				v, _, _ := strings.Cut(a.Value, " ")
+				if ssrc, err := strconv.ParseUint(v, 10, 32); err == nil {
+					ssrcs[uint32(ssrc)] = true
+				}
+			case sdp.AttrKeySSRCGroup:
+				// a=ssrc-group:<semantics> <ssrc> ...
+				fields := strings.Fields(a.Value)
+				for _, v := range fields[min(1, len(fields)):] {
+					if ssrc, err := strconv.ParseUint(v, 10, 32); err == nil {
+						ssrcs[uint32(ssrc)] = true
+					}
+				}
+			}
+		}
+
+		for ssrc := range ssrcs {
+			if first, ok := sections[ssrc]; !ok {
+				sections[ssrc] = i
+			} else if first != i && !slices.Contains(collisions, ssrc) {
+				collisions = append(collisions, ssrc)
+			}
+		}
+	}
+
+	slices.Sort(collisions)
+	return collisions
+}
+
+var srtpProtectionProfiles = map[string]dtls.SRTPProtectionProfile{
+	config.SRTPAEADAES256GCM:       dtls.SRTP_AEAD_AES_256_GCM,
+	config.SRTPAEADAES128GCM:       dtls.SRTP_AEAD_AES_128_GCM,
+	config.SRTPAES128CMHMACSHA1_80: dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+}
+
+// getSRTPProtectionProfiles returns the DTLS profiles with the given names, in order of preference.
+// Names are validated with the configuration.
+func getSRTPProtectionProfiles(names []string) []dtls.SRTPProtectionProfile {
+	profiles := make([]dtls.SRTPProtectionProfile, 0, len(names))
+	for _, name := range names {
+		if profile, ok := srtpProtectionProfiles[name]; ok {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	return profiles
+}
@@ -0,0 +1,1033 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	monoOpusOffer = "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 8\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=fmtp:111 minptime=10;useinbandfec=1;sprop-maxcapturerate=16000\r\n" +
+		"a=rtpmap:8 PCMA/8000\r\n"
+
+	stereoOpusOffer = "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 109\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:109 opus/48000/2\r\n" +
+		"a=fmtp:109 minptime=10;stereo=1;sprop-stereo=1\r\n"
+
+	pcmaOffer = "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 0 8\r\n" +
+		"c=IN IP4 0.0.0.0\r\n"
+)
+
+func TestGetOfferedAudioFormat(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		offer    string
+		expected audioFormat
+	}{
+		{
+			name:     "mono",
+			offer:    monoOpusOffer,
+			expected: audioFormat{mimeType: webrtc.MimeTypeOpus, sampleRate: 16000, channels: 1},
+		},
+		{
+			name:     "stereo",
+			offer:    stereoOpusOffer,
+			expected: audioFormat{mimeType: webrtc.MimeTypeOpus, sampleRate: 48000, channels: 2},
+		},
+		{
+			name:     "static payload type",
+			offer:    pcmaOffer,
+			expected: audioFormat{mimeType: webrtc.MimeTypePCMA, sampleRate: 8000, channels: 1},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: test.offer}).Unmarshal()
+			require.NoError(t, err)
+
+			f, ok := getOfferedAudioFormat(parsed)
+			require.True(t, ok)
+			require.Equal(t, test.expected, f)
+		})
+	}
+}
+
+func TestValidateAudioFormat(t *testing.T) {
+	newHandler := func(conf config.WHIPConfig) *whipHandler {
+		return &whipHandler{
+			logger: logger.GetLogger(),
+			params: &params.Params{
+				Config: &config.Config{ServiceConfig: &config.ServiceConfig{WHIP: conf}},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		conf     config.WHIPConfig
+		offer    string
+		expected error
+	}{
+		{
+			name:  "any format allowed",
+			offer: stereoOpusOffer,
+		},
+		{
+			name:  "mono allowed",
+			conf:  config.WHIPConfig{AllowedAudioChannels: []uint32{1}},
+			offer: monoOpusOffer,
+		},
+		{
+			name:     "stereo rejected",
+			conf:     config.WHIPConfig{AllowedAudioChannels: []uint32{1}},
+			offer:    stereoOpusOffer,
+			expected: errors.ErrUnsupportedAudioFormat,
+		},
+		{
+			name:  "stereo allowed",
+			conf:  config.WHIPConfig{AllowedAudioSampleRates: []uint32{48000}, AllowedAudioChannels: []uint32{2}},
+			offer: stereoOpusOffer,
+		},
+		{
+			name:     "sample rate rejected",
+			conf:     config.WHIPConfig{AllowedAudioSampleRates: []uint32{48000}},
+			offer:    monoOpusOffer,
+			expected: errors.ErrUnsupportedAudioFormat,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHandler(test.conf)
+			err := h.validateAudioFormat(&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: test.offer})
+			if test.expected == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestFilterRTCPReducedSize(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtcp-rsize\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n"
+
+	answer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtcp-rsize\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtcp-rsize\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n"
+
+	hasRTCPReducedSize := func(t *testing.T, sdp string) []bool {
+		parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}).Unmarshal()
+		require.NoError(t, err)
+
+		var res []bool
+		for _, m := range parsed.MediaDescriptions {
+			_, ok := m.Attribute("rtcp-rsize")
+			res = append(res, ok)
+		}
+		return res
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		filtered, err := filterRTCPReducedSize(answer, offer, true)
+		require.NoError(t, err)
+		require.Equal(t, []bool{true, false}, hasRTCPReducedSize(t, filtered))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		filtered, err := filterRTCPReducedSize(answer, offer, false)
+		require.NoError(t, err)
+		require.Equal(t, []bool{false, false}, hasRTCPReducedSize(t, filtered))
+	})
+}
+
+func TestFilterExtmapAllowMixed(t *testing.T) {
+	template := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"%s" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n"
+
+	answer := fmt.Sprintf(template, "a=extmap-allow-mixed\r\n")
+
+	hasExtmapAllowMixed := func(t *testing.T, sdp string) bool {
+		parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}).Unmarshal()
+		require.NoError(t, err)
+
+		_, ok := parsed.Attribute("extmap-allow-mixed")
+		return ok
+	}
+
+	for _, test := range []struct {
+		name     string
+		offer    string
+		enabled  bool
+		expected bool
+	}{
+		{"offered and enabled", fmt.Sprintf(template, "a=extmap-allow-mixed\r\n"), true, true},
+		{"offered and disabled", fmt.Sprintf(template, "a=extmap-allow-mixed\r\n"), false, false},
+		{"not offered", fmt.Sprintf(template, ""), true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filtered, err := filterExtmapAllowMixed(answer, test.offer, test.enabled)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, hasExtmapAllowMixed(t, filtered))
+		})
+	}
+}
+
+func TestGetAnswerModifications(t *testing.T) {
+	header := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n"
+	template := "m=video 9 UDP/TLS/RTP/SAVPF %s\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"%s" +
+		"a=mid:0\r\n" +
+		"a=%s\r\n" +
+		"%s" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=rtcp-fb:96 nack\r\n" +
+		"%s"
+	candidates := "a=candidate:1 1 udp 2130706431 10.0.0.1 50000 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 192.168.0.1 50000 typ host\r\n"
+
+	offer := header + fmt.Sprintf(template, "96 97", "", "sendrecv", "a=rtcp-rsize\r\n",
+		"a=rtpmap:97 AV1/90000\r\na=rtcp-fb:96 transport-cc\r\n")
+	localAnswer := header + fmt.Sprintf(template, "96", "", "recvonly", "a=rtcp-rsize\r\n", candidates)
+
+	for _, test := range []struct {
+		name     string
+		offer    string
+		answer   string
+		expected []string
+	}{
+		{
+			name:     "honored",
+			offer:    header + fmt.Sprintf(template, "96", "", "sendonly", "a=rtcp-rsize\r\n", ""),
+			answer:   localAnswer,
+			expected: nil,
+		},
+		{
+			name:     "local answer",
+			offer:    offer,
+			answer:   localAnswer,
+			expected: []string{"forced-recvonly", "dropped-av1", "dropped-rtcp-fb"},
+		},
+		{
+			name:     "answer builder",
+			offer:    offer,
+			answer:   header + fmt.Sprintf(template, "96", "b=AS:2500\r\n", "recvonly", "", "a=candidate:2 1 udp 2130706431 192.168.0.1 50000 typ host\r\n"),
+			expected: []string{"forced-recvonly", "dropped-av1", "dropped-rtcp-fb", "no-rtcp-rsize", "bitrate-capped", "filtered-candidates"},
+		},
+		{
+			name:  "reordered candidates",
+			offer: header + fmt.Sprintf(template, "96", "", "sendonly", "a=rtcp-rsize\r\n", ""),
+			answer: header + fmt.Sprintf(template, "96", "", "recvonly", "a=rtcp-rsize\r\n",
+				"a=candidate:2 1 udp 2130706431 192.168.0.1 50000 typ host\r\na=candidate:1 1 udp 2130706431 10.0.0.1 50000 typ host\r\n"),
+			expected: []string{"reordered-candidates"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			modifications, err := getAnswerModifications(test.offer, localAnswer, test.answer)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, modifications)
+		})
+	}
+}
+
+func TestValidateICECredentials(t *testing.T) {
+	header := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n"
+	media := "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n"
+
+	for _, test := range []struct {
+		name  string
+		offer string
+		valid bool
+	}{
+		{
+			name:  "media level",
+			offer: header + media + "a=ice-ufrag:abcd\r\na=ice-pwd:0123456789abcdef012345\r\n",
+			valid: true,
+		},
+		{
+			name:  "session level",
+			offer: header + "a=ice-ufrag:abcd\r\na=ice-pwd:0123456789abcdef012345\r\n" + media,
+			valid: true,
+		},
+		{
+			name:  "missing ufrag",
+			offer: header + media + "a=ice-pwd:0123456789abcdef012345\r\n",
+		},
+		{
+			name:  "missing pwd",
+			offer: header + media + "a=ice-ufrag:abcd\r\n",
+		},
+		{
+			name:  "conflicting ufrag",
+			offer: header + "a=ice-ufrag:abcd\r\na=ice-pwd:0123456789abcdef012345\r\n" + media + "a=ice-ufrag:efgh\r\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: test.offer}).Unmarshal()
+			require.NoError(t, err)
+
+			err = validateICECredentials(parsed)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, errors.ErrInvalidWHIPOffer)
+			}
+		})
+	}
+}
+
+func TestGetOfferedTrackLabels(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=ssrc:1234 cname:abcd\r\n" +
+		"a=ssrc:1234 msid:stream microphone\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=msid:stream camera\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtcp-fb:96 nack\r\n" +
+		"a=rtcp-fb:96 transport-cc\r\n"
+
+	// The labels must survive the offer rewriting done before negotiation
+	filtered, err := filterRTCPFeedback(offer, config.DefaultWHIPRTCPFeedback)
+	require.NoError(t, err)
+
+	parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: filtered}).Unmarshal()
+	require.NoError(t, err)
+
+	require.Equal(t, map[types.StreamKind]string{
+		types.Audio: "microphone",
+		types.Video: "camera",
+	}, getOfferedTrackLabels(parsed))
+}
+
+func TestScanSDPFragICEDetails(t *testing.T) {
+	frag := "a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:0123456789abcdef012345\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host\r\n" +
+		"a=candidate:2 1 udp 1694498815 1.2.3.4 5000 typ srflx raddr 10.0.0.1 rport 5000\r\n"
+
+	ufrag, pwd, err := scanSDPFragICEDetails(strings.NewReader(frag), 2)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", ufrag)
+	require.Equal(t, "0123456789abcdef012345", pwd)
+
+	_, _, err = scanSDPFragICEDetails(strings.NewReader(frag), 1)
+	require.ErrorIs(t, err, errors.ErrSDPFragTooLarge)
+
+	w := httptest.NewRecorder()
+	_, _, err = scanSDPFragICEDetails(http.MaxBytesReader(w, io.NopCloser(strings.NewReader(frag)), 32), 0)
+	require.ErrorIs(t, err, errors.ErrSDPFragTooLarge)
+
+	_, _, err = scanSDPFragICEDetails(strings.NewReader("a=ice-ufrag:abcd\r\n"), 0)
+	require.Error(t, err)
+}
+
+func TestScanSDPFrag(t *testing.T) {
+	frag := "a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:0123456789abcdef012345\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:1\r\n" +
+		"a=candidate:2 1 udp 1694498815 1.2.3.4 5000 typ srflx raddr 10.0.0.1 rport 5000\r\n" +
+		"a=end-of-candidates\r\n"
+
+	parsed, err := scanSDPFrag(strings.NewReader(frag), 0)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", parsed.ufrag)
+	require.Equal(t, "0123456789abcdef012345", parsed.pwd)
+
+	mid0, mid1 := "0", "1"
+	index0, index1 := uint16(0), uint16(1)
+	require.Equal(t, []webrtc.ICECandidateInit{
+		{Candidate: "candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host", SDPMid: &mid0, SDPMLineIndex: &index0},
+		{Candidate: "candidate:2 1 udp 1694498815 1.2.3.4 5000 typ srflx raddr 10.0.0.1 rport 5000", SDPMid: &mid1, SDPMLineIndex: &index1},
+	}, parsed.candidates)
+
+	// Candidates without media section, as sent by clients bundling everything
+	parsed, err = scanSDPFrag(strings.NewReader("a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host\r\n"), 0)
+	require.NoError(t, err)
+	require.Len(t, parsed.candidates, 1)
+	require.Nil(t, parsed.candidates[0].SDPMid)
+	require.Nil(t, parsed.candidates[0].SDPMLineIndex)
+	require.Empty(t, parsed.ufrag)
+
+	_, err = scanSDPFrag(strings.NewReader("a=candidate:1 1 udp 2130706431 10.0.0.1\r\n"), 0)
+	require.Error(t, err)
+
+	_, err = scanSDPFrag(strings.NewReader(frag), 1)
+	require.ErrorIs(t, err, errors.ErrSDPFragTooLarge)
+}
+
+func TestPreferCodec(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 8\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtcp-fb:96 nack\r\n" +
+		"a=rtpmap:97 rtx/90000\r\n" +
+		"a=fmtp:97 apt=96\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f\r\n" +
+		"a=rtcp-fb:102 nack\r\n" +
+		"a=rtpmap:103 rtx/90000\r\n" +
+		"a=fmtp:103 apt=102\r\n"
+
+	getFormats := func(t *testing.T, sdp string, kind types.StreamKind) ([]string, []string) {
+		parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}).Unmarshal()
+		require.NoError(t, err)
+
+		for _, m := range parsed.MediaDescriptions {
+			if types.StreamKind(m.MediaName.Media) == kind {
+				var attributes []string
+				for _, a := range m.Attributes {
+					attributes = append(attributes, a.String())
+				}
+				return m.MediaName.Formats, attributes
+			}
+		}
+		return nil, nil
+	}
+
+	t.Run("preferred video codec offered", func(t *testing.T) {
+		out, offered, err := preferCodec(offer, types.Video, webrtc.MimeTypeH264)
+		require.NoError(t, err)
+		require.True(t, offered)
+
+		formats, attributes := getFormats(t, out, types.Video)
+		require.Equal(t, []string{"102", "103"}, formats)
+		for _, a := range attributes {
+			require.NotContains(t, a, ":96 ")
+			require.NotContains(t, a, ":97 ")
+		}
+
+		// Other kinds are untouched
+		formats, _ = getFormats(t, out, types.Audio)
+		require.Equal(t, []string{"111", "8"}, formats)
+	})
+
+	t.Run("preferred audio codec with static payload type", func(t *testing.T) {
+		out, offered, err := preferCodec(offer, types.Audio, webrtc.MimeTypePCMA)
+		require.NoError(t, err)
+		require.True(t, offered)
+
+		formats, _ := getFormats(t, out, types.Audio)
+		require.Equal(t, []string{"8"}, formats)
+	})
+
+	t.Run("preferred codec not offered", func(t *testing.T) {
+		out, offered, err := preferCodec(offer, types.Video, webrtc.MimeTypeVP9)
+		require.NoError(t, err)
+		require.False(t, offered)
+		require.Equal(t, offer, out)
+	})
+}
+
+func TestPrioritizeCandidates(t *testing.T) {
+	answer := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.168.1.10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:3 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+		"a=end-of-candidates\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.168.1.10 7885 typ host\r\n" +
+		"a=candidate:4 1 udp 2130706431 172.16.0.10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n"
+
+	preferred := [][]net.IP{
+		{net.ParseIP("10.0.0.10")},
+		{net.ParseIP("172.16.0.10")},
+	}
+
+	expected := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:3 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+		"a=candidate:1 1 udp 2130705919 192.168.1.10 7885 typ host\r\n" +
+		"a=end-of-candidates\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:4 1 udp 2130706175 172.16.0.10 7885 typ host\r\n" +
+		"a=candidate:1 1 udp 2130705919 192.168.1.10 7885 typ host\r\n"
+
+	require.Equal(t, expected, prioritizeCandidates(answer, preferred))
+	require.Equal(t, answer, prioritizeCandidates(answer, nil))
+}
+
+func TestGetHostCandidateIPs(t *testing.T) {
+	answer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.0.0.10 50001 typ host\r\n" +
+		"a=candidate:2 1 tcp 1671430143 10.0.0.10 9 typ host tcptype passive\r\n" +
+		"a=candidate:3 1 udp 1694498815 203.0.113.1 50001 typ srflx raddr 10.0.0.10 rport 50001\r\n" +
+		"a=candidate:4 1 udp 2130706431 192.168.1.10 50002 typ host\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.0.0.10 50001 typ host\r\n" +
+		"a=candidate:4 1 udp 2130706431 192.168.1.10 50002 typ host\r\n"
+
+	var parsed sdp.SessionDescription
+	require.NoError(t, parsed.UnmarshalString(answer))
+	require.Equal(t, []string{"10.0.0.10", "192.168.1.10"}, getHostCandidateIPs(&parsed))
+
+	parsed.MediaDescriptions[0].Attributes = nil
+	parsed.MediaDescriptions[1].Attributes = nil
+	require.Empty(t, getHostCandidateIPs(&parsed))
+}
+
+func TestGetBundleLayout(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=group:BUNDLE 0 1\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n"
+
+	var parsed sdp.SessionDescription
+	require.NoError(t, parsed.UnmarshalString(offer))
+	bundle, media := getBundleLayout(&parsed)
+	require.Equal(t, []string{"0", "1"}, bundle)
+	require.Equal(t, []string{"0:video", "1:audio"}, media)
+
+	parsed.Attributes = nil
+	bundle, media = getBundleLayout(&parsed)
+	require.Nil(t, bundle)
+	require.Equal(t, []string{"0:video", "1:audio"}, media)
+}
+
+func TestGetSSRCCollisions(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+		"a=mid:0\r\n" +
+		"a=ssrc-group:FID 1111 2222\r\n" +
+		"a=ssrc:1111 cname:a\r\n" +
+		"a=ssrc:2222 cname:a\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n" +
+		"a=ssrc:3333 cname:a\r\n"
+
+	var parsed sdp.SessionDescription
+	require.NoError(t, parsed.UnmarshalString(offer))
+	require.Empty(t, getSSRCCollisions(&parsed))
+
+	require.NoError(t, parsed.UnmarshalString(offer+"a=ssrc:2222 cname:a\r\n"+"a=ssrc-group:FID 3333 1111\r\n"))
+	require.Equal(t, []uint32{1111, 2222}, getSSRCCollisions(&parsed))
+}
+
+func TestGetUnbundledMedia(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"%s" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n" +
+		"m=audio 0 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:2\r\n"
+
+	for _, tc := range []struct {
+		name      string
+		group     string
+		unbundled []string
+	}{
+		{"bundled", "a=group:BUNDLE 0 1\r\n", nil},
+		{"partially bundled", "a=group:BUNDLE 0\r\n", []string{"1:audio"}},
+		{"not bundled", "", []string{"0:video", "1:audio"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var parsed sdp.SessionDescription
+			require.NoError(t, parsed.UnmarshalString(fmt.Sprintf(offer, tc.group)))
+			require.Equal(t, tc.unbundled, getUnbundledMedia(&parsed))
+		})
+	}
+}
+
+func TestGetNonMuxedMedia(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n" +
+		"%s" +
+		"m=audio 0 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:2\r\n"
+
+	for _, tc := range []struct {
+		name     string
+		mux      string
+		nonMuxed []string
+	}{
+		{"muxed", "a=rtcp-mux\r\n", nil},
+		{"not muxed", "a=rtcp:9 IN IP4 0.0.0.0\r\n", []string{"1:audio"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var parsed sdp.SessionDescription
+			require.NoError(t, parsed.UnmarshalString(fmt.Sprintf(offer, tc.mux)))
+			require.Equal(t, tc.nonMuxed, getNonMuxedMedia(&parsed))
+		})
+	}
+}
+
+func TestGetInsecureMedia(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"%s" +
+		"m=video 9 %s 96\r\n" +
+		"a=mid:0\r\n" +
+		"m=audio 0 RTP/AVP 111\r\n" +
+		"a=mid:1\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+		"a=mid:2\r\n"
+	fingerprint := "a=fingerprint:sha-256 00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF\r\n"
+
+	for _, tc := range []struct {
+		name          string
+		fingerprint   string
+		profile       string
+		insecure      []string
+		noFingerprint bool
+	}{
+		{"DTLS-SRTP", fingerprint, "UDP/TLS/RTP/SAVPF", nil, false},
+		{"TCP DTLS-SRTP", fingerprint, "TCP/TLS/RTP/SAVPF", nil, false},
+		{"plain RTP", fingerprint, "RTP/AVP", []string{"0:video:RTP/AVP"}, false},
+		{"SRTP without DTLS", fingerprint, "RTP/SAVPF", []string{"0:video:RTP/SAVPF"}, false},
+		{"no fingerprint", "", "UDP/TLS/RTP/SAVPF", nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var parsed sdp.SessionDescription
+			require.NoError(t, parsed.UnmarshalString(fmt.Sprintf(offer, tc.fingerprint, tc.profile)))
+
+			insecure, noFingerprint := getInsecureMedia(&parsed)
+			require.Equal(t, tc.insecure, insecure)
+			require.Equal(t, tc.noFingerprint, noFingerprint)
+		})
+	}
+}
+
+func TestMixedDirectionOffer(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:0123456789abcdef012345\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=recvonly\r\n" +
+		"a=msid:- recv-audio\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n" +
+		"a=sendonly\r\n" +
+		"a=msid:- send-audio\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:2\r\n" +
+		"a=inactive\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:3\r\n" +
+		"a=msid:- send-video\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n"
+
+	newHandler := func(recvOnlyMedia string) *whipHandler {
+		return &whipHandler{
+			logger: logger.GetLogger(),
+			params: &params.Params{
+				Config: &config.Config{ServiceConfig: &config.ServiceConfig{WHIP: config.WHIPConfig{RecvOnlyMedia: recvOnlyMedia}}},
+			},
+		}
+	}
+
+	sd := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}
+
+	t.Run("recvonly and inactive sections ignored", func(t *testing.T) {
+		count, err := newHandler(config.WHIPRecvOnlyMediaIgnore).validateOfferAndGetExpectedTrackCount(sd)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		parsed, err := sd.Unmarshal()
+		require.NoError(t, err)
+		require.Equal(t, map[types.StreamKind]string{types.Audio: "send-audio", types.Video: "send-video"}, getOfferedTrackLabels(parsed))
+
+		f, ok := getOfferedAudioFormat(parsed)
+		require.True(t, ok)
+		require.Equal(t, uint32(48000), f.sampleRate)
+	})
+
+	t.Run("recvonly sections rejected", func(t *testing.T) {
+		_, err := newHandler(config.WHIPRecvOnlyMediaReject).validateOfferAndGetExpectedTrackCount(sd)
+		require.ErrorIs(t, err, errors.ErrInvalidWHIPOffer)
+	})
+
+	t.Run("no sending section", func(t *testing.T) {
+		recvOnly := strings.ReplaceAll(strings.ReplaceAll(offer, "a=sendonly\r\n", "a=recvonly\r\n"), "a=msid:- send-video\r\n", "a=recvonly\r\n")
+		_, err := newHandler(config.WHIPRecvOnlyMediaIgnore).validateOfferAndGetExpectedTrackCount(&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: recvOnly})
+		require.ErrorIs(t, err, errors.ErrInvalidWHIPOffer)
+	})
+}
+
+func TestAllowSimulcast(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:0123456789abcdef012345\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:1\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rid:h send\r\n" +
+		"a=rid:m send\r\n" +
+		"a=rid:l send\r\n" +
+		"a=simulcast:send h;m;l\r\n"
+
+	newHandler := func(allowSimulcast bool) *whipHandler {
+		return &whipHandler{
+			logger: logger.GetLogger(),
+			params: &params.Params{
+				Config: &config.Config{ServiceConfig: &config.ServiceConfig{WHIP: config.WHIPConfig{AllowSimulcast: &allowSimulcast}}},
+			},
+		}
+	}
+
+	sd := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}
+
+	t.Run("allowed", func(t *testing.T) {
+		h := newHandler(true)
+		count, err := h.validateOfferAndGetExpectedTrackCount(sd)
+		require.NoError(t, err)
+		require.Equal(t, 4, count)
+		require.Equal(t, []string{"h", "m", "l"}, h.simulcastLayers)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		h := newHandler(false)
+		_, err := h.validateOfferAndGetExpectedTrackCount(sd)
+		require.ErrorIs(t, err, errors.ErrSimulcastNotAllowed)
+		require.Empty(t, h.simulcastLayers)
+
+		// Offers without simulcast are not affected
+		single := strings.Split(offer, "a=rid:h")[0]
+		count, err := h.validateOfferAndGetExpectedTrackCount(&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: single})
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+}
+
+func TestGetSRTPProtectionProfiles(t *testing.T) {
+	require.Equal(t, []dtls.SRTPProtectionProfile{dtls.SRTP_AEAD_AES_128_GCM, dtls.SRTP_AEAD_AES_256_GCM},
+		getSRTPProtectionProfiles([]string{config.SRTPAEADAES128GCM, config.SRTPAEADAES256GCM}))
+	require.Empty(t, getSRTPProtectionProfiles(nil))
+}
+
+func TestGetAdvertisedBitrates(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"b=AS:64\r\n" +
+		"a=mid:0\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rid:h send max-br=2000000\r\n" +
+		"a=rid:l send max-width=640;max-br=500000\r\n" +
+		"a=simulcast:send h;l\r\n"
+
+	parse := func(offer string) *sdp.SessionDescription {
+		parsed := &sdp.SessionDescription{}
+		require.NoError(t, parsed.UnmarshalString(offer))
+		return parsed
+	}
+
+	require.Equal(t, map[types.StreamKind]uint64{types.Audio: 64000, types.Video: 2500000}, getAdvertisedBitrates(parse(offer)))
+
+	// TIAS takes precedence over AS
+	tias := strings.Replace(offer, "b=AS:64\r\n", "b=AS:64\r\nb=TIAS:48000\r\n", 1)
+	require.Equal(t, uint64(48000), getAdvertisedBitrates(parse(tias))[types.Audio])
+
+	none := strings.Replace(strings.Replace(offer, "b=AS:64\r\n", "", 1), "max-br=", "max-fps=", -1)
+	require.Empty(t, getAdvertisedBitrates(parse(none)))
+}
+
+func TestLimitCandidates(t *testing.T) {
+	answer := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:1 1 udp 2130705919 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.1.10 7885 typ host\r\n" +
+		"a=candidate:3 1 udp 2130706175 10.0.2.10 7885 typ host\r\n" +
+		"a=candidate:4 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+		"a=end-of-candidates\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130705919 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.1.10 7885 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+
+	expected := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.1.10 7885 typ host\r\n" +
+		"a=candidate:3 1 udp 2130706175 10.0.2.10 7885 typ host\r\n" +
+		"a=candidate:4 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+		"a=end-of-candidates\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130705919 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.1.10 7885 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+
+	limited, removed := limitCandidates(answer, 2)
+	require.Equal(t, expected, limited)
+	require.Equal(t, 1, removed)
+
+	limited, removed = limitCandidates(answer, 0)
+	require.Equal(t, answer, limited)
+	require.Zero(t, removed)
+}
+
+func TestFilterCandidateFamilies(t *testing.T) {
+	answer := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=candidate:1 1 udp 2130706431 2001:db8::10 7885 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+		"a=candidate:3 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+		"a=candidate:4 1 udp 2130706431 a1b2c3.local 7885 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+
+	t.Run("ipv4 only", func(t *testing.T) {
+		expected := "v=0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+			"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+			"a=candidate:3 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+			"a=candidate:4 1 udp 2130706175 a1b2c3.local 7885 typ host\r\n" +
+			"a=end-of-candidates\r\n"
+
+		require.Equal(t, expected, filterCandidateFamilies(answer, []string{config.WHIPIPFamilyIPv4}))
+	})
+
+	t.Run("ipv6 only", func(t *testing.T) {
+		expected := "v=0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+			"a=candidate:1 1 udp 2130706431 2001:db8::10 7885 typ host\r\n" +
+			"a=candidate:4 1 udp 2130706175 a1b2c3.local 7885 typ host\r\n" +
+			"a=end-of-candidates\r\n"
+
+		require.Equal(t, expected, filterCandidateFamilies(answer, []string{config.WHIPIPFamilyIPv6}))
+	})
+
+	t.Run("ipv4 preferred", func(t *testing.T) {
+		expected := "v=0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+			"a=candidate:2 1 udp 2130706431 10.0.0.10 7885 typ host\r\n" +
+			"a=candidate:3 1 udp 1694498815 203.0.113.1 7885 typ srflx raddr 10.0.0.10 rport 7885\r\n" +
+			"a=candidate:1 1 udp 2130706175 2001:db8::10 7885 typ host\r\n" +
+			"a=candidate:4 1 udp 2130705919 a1b2c3.local 7885 typ host\r\n" +
+			"a=end-of-candidates\r\n"
+
+		require.Equal(t, expected, filterCandidateFamilies(answer, []string{config.WHIPIPFamilyIPv4, config.WHIPIPFamilyIPv6}))
+	})
+
+	require.Equal(t, answer, filterCandidateFamilies(answer, nil))
+}
+
+func TestGetNegotiatedTracks(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=sendonly\r\n" +
+		"a=msid:stream audio-track\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 102\r\n" +
+		"a=mid:1\r\n" +
+		"a=sendonly\r\n" +
+		"a=ssrc:1234 msid:stream video-track\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:2\r\n" +
+		"a=recvonly\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n"
+
+	answer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"a=recvonly\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 102 96\r\n" +
+		"a=mid:1\r\n" +
+		"a=recvonly\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=mid:2\r\n" +
+		"a=inactive\r\n"
+
+	parsedOffer := &sdp.SessionDescription{}
+	require.NoError(t, parsedOffer.UnmarshalString(offer))
+	parsedAnswer := &sdp.SessionDescription{}
+	require.NoError(t, parsedAnswer.UnmarshalString(answer))
+
+	require.Equal(t, []negotiatedTrack{
+		{Kind: types.Audio, Codec: "audio/opus", Mid: "0", MSID: "stream audio-track"},
+		{Kind: types.Video, Codec: "video/H264", Mid: "1", MSID: "stream video-track"},
+	}, getNegotiatedTracks(parsedOffer, parsedAnswer))
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	require.True(t, acceptsJSON("application/json"))
+	require.True(t, acceptsJSON("application/sdp, application/json;q=0.5"))
+	require.False(t, acceptsJSON("application/sdp"))
+	require.False(t, acceptsJSON(""))
+}
+
+func TestGetICEServerLinks(t *testing.T) {
+	links := getICEServerLinks([]webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{
+			URLs:       []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:443"},
+			Username:   "user",
+			Credential: `pa"ss`,
+		},
+	})
+	require.Equal(t, []string{
+		`<stun:stun.example.com:3478>; rel="ice-server"`,
+		`<turn:turn.example.com:3478?transport=udp>; rel="ice-server"; username="user"; credential="pa\"ss"; credential-type="password"`,
+		`<turns:turn.example.com:443>; rel="ice-server"; username="user"; credential="pa\"ss"; credential-type="password"`,
+	}, links)
+}
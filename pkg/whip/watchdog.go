@@ -0,0 +1,48 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+const maxWatchdogStackSize = 1 << 20
+
+// watchPhase reports a negotiation phase still running after the deadline. Phases are bounded by
+// context timeouts, so this only fires when something ignores its context, such as a blocked cgo call.
+// The returned function must be called when the phase completes.
+func watchPhase(l logger.Logger, phase string, deadline time.Duration, onStuck func(phase string)) func() {
+	start := time.Now()
+	t := time.AfterFunc(deadline, func() {
+		l.Warnw("WHIP negotiation phase stuck", nil, "phase", phase, "elapsed", time.Since(start), "deadline", deadline)
+		if onStuck != nil {
+			onStuck(phase)
+		}
+
+		buf := make([]byte, maxWatchdogStackSize)
+		buf = buf[:runtime.Stack(buf, true)]
+		l.Debugw("goroutine stacks of stuck WHIP negotiation", "phase", phase, "stacks", string(buf))
+	})
+
+	return func() {
+		if !t.Stop() {
+			// Already reported
+			l.Infow("stuck WHIP negotiation phase completed", "phase", phase, "elapsed", time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/logger"
+)
+
+func TestWatchPhase(t *testing.T) {
+	stuck := make(chan string, 1)
+	onStuck := func(phase string) {
+		stuck <- phase
+	}
+
+	stop := watchPhase(logger.GetLogger(), "init", time.Hour, onStuck)
+	stop()
+
+	stop = watchPhase(logger.GetLogger(), "start", 10*time.Millisecond, onStuck)
+	select {
+	case phase := <-stuck:
+		require.Equal(t, "start", phase)
+	case <-time.After(time.Second):
+		t.Fatal("stuck phase not reported")
+	}
+	stop()
+
+	require.Empty(t, stuck)
+}
@@ -17,9 +17,16 @@ package whip
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/dtls/v2/pkg/crypto/elliptic"
@@ -29,7 +36,9 @@ import (
 	"github.com/pion/webrtc/v3"
 	google_protobuf2 "google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/ipc"
 	"github.com/livekit/ingress/pkg/lksdk_output"
 	"github.com/livekit/ingress/pkg/params"
 	"github.com/livekit/ingress/pkg/stats"
@@ -40,7 +49,6 @@ import (
 	"github.com/livekit/protocol/rpc"
 	"github.com/livekit/protocol/tracer"
 	putils "github.com/livekit/protocol/utils"
-	"github.com/livekit/psrpc"
 	"github.com/livekit/server-sdk-go/v2/pkg/synchronizer"
 )
 
@@ -65,83 +73,199 @@ type WhipTrackDescription struct {
 type whipHandler struct {
 	logger logger.Logger
 	params *params.Params
+	app    string
+
+	resourceId string
+	streamKey  string
+	offerKey   string // identifies the offer the session was created from, to detect POST retries
+	sdpAnswer  string
+	generation atomic.Uint32 // incremented on every ICE restart
 
 	rtcConfig          *rtcconfig.WebRTCConfig
+	pcPool             *peerConnectionPool
+	answerBuilder      AnswerBuilder
+	modifications      []string
 	pc                 *webrtc.PeerConnection
 	sync               *synchronizer.Synchronizer
 	stats              *stats.LocalMediaStatsGatherer
 	expectedTrackCount int
+	targetLatency      time.Duration
+	hostCandidateIPs   []string
+	renegotiations     *renegotiationLimiter
+	bandwidthRamp      *bandwidthRamp
+	ssrcCollisions     int
+	iceGathering       time.Duration
+	trackLabels        map[types.StreamKind]string
+	headerExtensions   map[types.StreamKind][]types.HeaderExtension
 	closeOnce          sync.Once
-
-	trackLock       sync.Mutex
-	simulcastLayers []string
-	tracks          []*webrtc.TrackRemote
-	trackHandlers   map[WhipTrackDescription]WhipTrackHandler
-	trackAddedChan  chan *webrtc.TrackRemote
+	iceConnectedOnce   sync.Once
+	iceConnected       chan struct{}
+	iceTransportOnce   sync.Once
+	iceTransportUp     chan struct{}
+	dtlsFailedOnce     sync.Once
+	dtlsFailed         chan struct{}
+	requestReceivedAt  time.Time
+	createdAt          time.Time
+	done               chan struct{} // closed once the session is removed and its end reported
+	started            atomic.Bool   // all the offered tracks are ready
+	endErr             error         // reason the session ended, nil for a normal end. Guarded by the server handlersLock
+	handedOver         atomic.Bool   // replaced by a reconnection, which reports the end of the ingress instead
+	onEnded            func(summary *types.SessionSummary, err error)
+	firstKeyframeOnce  sync.Once
+	onTimeToFirstFrame func(mimeType string, d time.Duration)
+
+	trackLock               sync.Mutex
+	startedAt               time.Time
+	bytesBeforeFirstFrame   uint64
+	packetsBeforeFirstFrame uint64
+	turnServer              string
+	connectionLabel         string
+	simulcastLayers         []string
+	tracks                  []*webrtc.TrackRemote
+	trackHandlers           map[WhipTrackDescription]WhipTrackHandler
+	trackAddedChan          chan *webrtc.TrackRemote
+	relays                  map[types.StreamKind]bool // kinds with a relay writer set
+	advertisedBitrates      map[types.StreamKind]uint64
+	senderReports           map[types.StreamKind]types.SenderReport
 
 	trackSDKMediaSinkLock sync.Mutex
 	trackSDKMediaSink     map[types.StreamKind]*SDKMediaSink
 }
 
-func NewWHIPHandler(webRTCConfig *rtcconfig.WebRTCConfig) *whipHandler {
+func NewWHIPHandler(webRTCConfig *rtcconfig.WebRTCConfig, pcPool *peerConnectionPool, answerBuilder AnswerBuilder, app string) *whipHandler {
 	// Copy the rtc conf to allow modifying to to match the request
 	rtcConfCopy := *webRTCConfig
 
+	if answerBuilder == nil {
+		answerBuilder = DefaultAnswerBuilder{}
+	}
+
 	return &whipHandler{
 		rtcConfig:         &rtcConfCopy,
+		pcPool:            pcPool,
+		answerBuilder:     answerBuilder,
+		app:               app,
+		createdAt:         time.Now(),
+		done:              make(chan struct{}),
 		sync:              synchronizer.NewSynchronizer(nil),
+		iceConnected:      make(chan struct{}),
+		iceTransportUp:    make(chan struct{}),
+		dtlsFailed:        make(chan struct{}),
 		trackHandlers:     make(map[WhipTrackDescription]WhipTrackHandler),
 		trackSDKMediaSink: make(map[types.StreamKind]*SDKMediaSink),
 	}
 }
 
-func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer string) (string, error) {
+func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer string, targetLatency time.Duration) (string, error) {
 	var err error
 
-	h.logger = p.GetLogger()
+	// The session logs carry the id of the request that created it
+	h.logger = requestLogger(ctx, p.GetLogger())
 	h.params = p
+	h.renegotiations = newRenegotiationLimiter(p.WHIP.MaxRenegotiationsPerMinute, p.WHIP.MaxRenegotiations)
+	if p.WHIP.InitialBitrate > 0 {
+		h.bandwidthRamp = newBandwidthRamp(p.WHIP.InitialBitrate, p.WHIP.BitrateRampRate, p.WHIP.BitrateRampDuration)
+	}
+	if *p.EnableTranscoding {
+		// Media is forwarded without a jitter buffer when not transcoding
+		h.targetLatency = targetLatency
+	}
 
-	h.updateSettings()
+	updateSettingEngine(&h.rtcConfig.SettingEngine, &p.WHIP)
 
 	offer := &webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdpOffer,
 	}
 
-	h.logger.Infow("received SDP offer", "offer", sdpOffer, "transcoding", *p.EnableTranscoding, "simulcast", len(h.simulcastLayers) != 0, "params", p)
+	h.logger.Infow("received SDP offer", "offer", sdpOffer, "transcoding", *p.EnableTranscoding, "simulcast", len(h.simulcastLayers) != 0, "targetLatency", h.targetLatency, "params", p)
 
 	h.expectedTrackCount, err = h.validateOfferAndGetExpectedTrackCount(offer)
 	if err != nil {
 		return "", err
 	}
 
+	// Pion only implements DTLS-SRTP, other transports would otherwise fail when applying the offer
+	if err = h.checkTransportSecurity(offer); err != nil {
+		return "", err
+	}
+
 	if *p.EnableTranscoding && len(h.simulcastLayers) != 0 {
 		return "", errors.ErrSimulcastTranscode
 	}
 
+	if err = h.checkSSRCCollisions(offer); err != nil {
+		return "", err
+	}
+
+	if parsed, err := offer.Unmarshal(); err == nil {
+		h.advertisedBitrates = getAdvertisedBitrates(parsed)
+		if len(h.advertisedBitrates) > 0 {
+			h.logger.Infow("publisher advertised bitrates", "bitrates", h.advertisedBitrates)
+		}
+	}
+
+	if err = h.validateAudioFormat(offer); err != nil {
+		return "", err
+	}
+
 	h.trackAddedChan = make(chan *webrtc.TrackRemote, h.expectedTrackCount)
 
-	m, err := newMediaEngine()
+	// Pion copies the feedback from the offer to the answer. Only keep what we implement
+	offer.SDP, err = filterRTCPFeedback(offer.SDP, p.WHIP.RTCPFeedback)
 	if err != nil {
 		return "", err
 	}
 
-	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP Pipeline.
-	// This provides NACKs, RTCP Reports and other features. If you use `webrtc.NewPeerConnection`
-	// this is enabled by default. If you are manually managing You MUST create a InterceptorRegistry
-	// for each PeerConnection.
-	i := &interceptor.Registry{}
+	for _, pref := range []struct {
+		kind     types.StreamKind
+		mimeType string
+	}{
+		{types.Video, p.WHIP.PreferredVideoCodec},
+		{types.Audio, p.WHIP.PreferredAudioCodec},
+	} {
+		if pref.mimeType == "" {
+			continue
+		}
 
-	if *p.EnableTranscoding {
-		// Use the default set of Interceptors
-		if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		var offered bool
+		offer.SDP, offered, err = preferCodec(offer.SDP, pref.kind, pref.mimeType)
+		if err != nil {
 			return "", err
 		}
+		h.logger.Debugw("applied codec preference", "kind", pref.kind, "codec", pref.mimeType, "offered", offered)
 	}
 
-	// Create the API object with the MediaEngine
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(h.rtcConfig.SettingEngine), webrtc.WithInterceptorRegistry(i))
-	h.pc, err = h.createPeerConnection(api)
+	parsedOffer, err := offer.Unmarshal()
+	if err != nil {
+		return "", err
+	}
+	if bundle, media := getBundleLayout(parsedOffer); bundle != nil {
+		h.logger.Debugw("offer BUNDLE group", "bundle", bundle, "media", media)
+	} else {
+		h.logger.Infow("offer does not use BUNDLE", "media", media)
+	}
+	if p.WHIP.RequireBundle {
+		if unbundled := getUnbundledMedia(parsedOffer); len(unbundled) > 0 {
+			h.logger.Infow("rejecting offer with media outside of the BUNDLE group", "unbundled", unbundled)
+			return "", errors.ErrBundleRequired
+		}
+	}
+	// Pion always answers with a=rtcp-mux and has no fallback to a separate RTCP port, but the
+	// client may still expect one if it did not offer multiplexing
+	if p.WHIP.RequireRTCPMux {
+		if nonMuxed := getNonMuxedMedia(parsedOffer); len(nonMuxed) > 0 {
+			h.logger.Infow("rejecting offer with media not multiplexing RTCP", "nonMuxed", nonMuxed)
+			return "", errors.ErrRTCPMuxRequired
+		}
+	}
+
+	h.trackLabels = getOfferedTrackLabels(parsedOffer)
+	if len(h.trackLabels) != 0 {
+		h.logger.Infow("offered track labels", "trackLabels", h.trackLabels)
+	}
+
+	h.pc, err = h.createPeerConnection(true)
 	if err != nil {
 		return "", err
 	}
@@ -153,6 +277,19 @@ func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer strin
 
 	h.logger.Infow("created peer connection with offer", "offer", sdpOffer)
 	sdpAnswer, err := h.getSDPAnswer(ctx, offer)
+	if errors.Is(err, errors.ErrNoAvailablePorts) && p.WHIP.FallbackPortRangeStart != 0 {
+		h.logger.Warnw("ICE port range exhausted, using the fallback port range", nil, "portRangeStart", p.WHIP.FallbackPortRangeStart, "portRangeEnd", p.WHIP.FallbackPortRangeEnd)
+
+		h.pc.Close()
+		if err = h.rtcConfig.SettingEngine.SetEphemeralUDPPortRange(p.WHIP.FallbackPortRangeStart, p.WHIP.FallbackPortRangeEnd); err != nil {
+			return "", err
+		}
+		h.pc, err = h.createPeerConnection(false)
+		if err != nil {
+			return "", err
+		}
+		sdpAnswer, err = h.getSDPAnswer(ctx, offer)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -160,19 +297,60 @@ func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer strin
 
 	sdpAnswer = addICEToAnswer(sdpAnswer)
 
+	if p.WHIP.AnswerModificationsHeader {
+		if h.modifications, err = getAnswerModifications(sdpOffer, h.pc.LocalDescription().SDP, sdpAnswer); err != nil {
+			h.logger.Warnw("failed listing answer modifications", err)
+			err = nil
+		} else if len(h.modifications) > 0 {
+			h.logger.Debugw("answer departs from the offer", "modifications", h.modifications)
+		}
+	}
+
 	return sdpAnswer, nil
 }
 
-func (h *whipHandler) Start(ctx context.Context) (map[types.StreamKind]string, error) {
+// Start waits for all the offered tracks and returns their mime types and msid labels
+func (h *whipHandler) Start(ctx context.Context) (map[types.StreamKind]string, map[types.StreamKind]string, error) {
 	var trackCount int
 	mimeTypes := make(map[types.StreamKind]string)
 
+	iceConnected := h.iceConnected
+	iceTransportUp := h.iceTransportUp
+	var firstMediaTimeout <-chan time.Time
+	var dtlsTimeout <-chan time.Time
+	dtlsHandshakeTimeout := h.params.WHIP.GetDTLSHandshakeTimeout(h.app)
+
 loop:
 	for {
 		select {
 		case <-ctx.Done():
-			return nil, errors.ErrSourceNotReady
+			return nil, nil, errors.ErrSourceNotReady
+		case <-iceTransportUp:
+			iceTransportUp = nil
+			// The peer connection is connected once both ICE and DTLS are
+			if iceConnected != nil && dtlsHandshakeTimeout > 0 {
+				t := time.NewTimer(dtlsHandshakeTimeout)
+				defer t.Stop()
+				dtlsTimeout = t.C
+			}
+		case <-dtlsTimeout:
+			h.logger.Infow("DTLS handshake not completed after ICE connection", "timeout", dtlsHandshakeTimeout)
+			return nil, nil, errors.ErrDTLSTimeout
+		case <-iceConnected:
+			iceConnected = nil
+			dtlsTimeout = nil
+			if trackCount == 0 && h.params.WHIP.FirstMediaTimeout > 0 {
+				t := time.NewTimer(h.params.WHIP.FirstMediaTimeout)
+				defer t.Stop()
+				firstMediaTimeout = t.C
+			}
+		case <-firstMediaTimeout:
+			h.logger.Infow("no media received after ICE connection", "timeout", h.params.WHIP.FirstMediaTimeout)
+			return nil, nil, errors.ErrNoMediaReceived
+		case <-h.dtlsFailed:
+			return nil, nil, errors.ErrSRTPProfileNotAllowed
 		case track := <-h.trackAddedChan:
+			firstMediaTimeout = nil
 			mimeTypes[streamKindFromCodecType(track.Kind())] = track.Codec().MimeType
 
 			trackCount++
@@ -185,13 +363,72 @@ loop:
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
 
-	return mimeTypes, nil
+	h.startedAt = time.Now()
+
+	return mimeTypes, maps.Clone(h.trackLabels), nil
+}
+
+// GetSessionSummary returns the session duration, media received before the first frame and final media stats
+func (h *whipHandler) GetSessionSummary(ctx context.Context) *types.SessionSummary {
+	h.trackLock.Lock()
+	st := h.stats
+	startedAt := h.startedAt
+	summary := &types.SessionSummary{
+		BytesBeforeFirstFrame:   h.bytesBeforeFirstFrame,
+		PacketsBeforeFirstFrame: h.packetsBeforeFirstFrame,
+	}
+	advertised := h.advertisedBitrates
+	h.trackLock.Unlock()
+
+	if !startedAt.IsZero() {
+		summary.Duration = time.Since(startedAt)
+	}
+	if st != nil {
+		ms, err := st.GatherStats(ctx)
+		if err != nil {
+			h.logger.Infow("failed gathering final media stats", "error", err)
+		}
+		summary.Stats = ms
+	}
+	summary.Bitrates = getTrackBitrates(advertised, summary.Stats)
+
+	return summary
+}
+
+// getTrackBitrates compares the advertised bitrates with the average ones of the media stats
+func getTrackBitrates(advertised map[types.StreamKind]uint64, ms *ipc.MediaStats) map[types.StreamKind]types.TrackBitrate {
+	if len(advertised) == 0 {
+		return nil
+	}
+
+	bitrates := make(map[types.StreamKind]types.TrackBitrate, len(advertised))
+	for kind, bitrate := range advertised {
+		b := types.TrackBitrate{Advertised: bitrate}
+		if ts := ms.GetTrackStats()[inputStatsPath(kind)]; ts != nil {
+			b.Measured = uint64(ts.AverageBitrate)
+			b.Ratio = float64(b.Measured) / float64(bitrate)
+		}
+		bitrates[kind] = b
+	}
+
+	return bitrates
+}
+
+func inputStatsPath(kind types.StreamKind) string {
+	if kind == types.Audio {
+		return stats.InputAudio
+	}
+	return stats.InputVideo
 }
 
 func (h *whipHandler) SetMediaStatsGatherer(st *stats.LocalMediaStatsGatherer) {
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
 	h.stats = st
+	st.SetTURNServer(h.turnServer)
+	st.SetTargetLatency(h.targetLatency)
+	st.SetICEGatheringDuration(h.iceGathering)
+	st.SetConnectionLabel(h.connectionLabel)
 
 	for _, th := range h.trackHandlers {
 		th.SetMediaTrackStatsGatherer(st)
@@ -206,6 +443,10 @@ func (h *whipHandler) Close() {
 
 func (h *whipHandler) WaitForSessionEnd(ctx context.Context) error {
 	defer func() {
+		h.trackLock.Lock()
+		h.logger.Infow("WHIP session summary", "turnServer", h.turnServer)
+		h.trackLock.Unlock()
+
 		h.logger.Infow("closing peer connection")
 		h.pc.Close()
 	}()
@@ -225,6 +466,48 @@ func (h *whipHandler) WaitForSessionEnd(ctx context.Context) error {
 	return err
 }
 
+// GetHeaderExtensions returns the RTP header extensions negotiated for each track received so far
+func (h *whipHandler) GetHeaderExtensions() map[types.StreamKind][]types.HeaderExtension {
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	return maps.Clone(h.headerExtensions)
+}
+
+// GetSenderReport returns the RTP to NTP timestamp mapping of the last sender report received for the track
+func (h *whipHandler) GetSenderReport(kind types.StreamKind) (types.SenderReport, bool) {
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	sr, ok := h.senderReports[kind]
+	return sr, ok
+}
+
+// getRTCPCallback returns the callback passing the RTCP of a relayed track to the synchronizer,
+// recording the timestamp mapping of its sender reports on the way
+func (h *whipHandler) getRTCPCallback(track *webrtc.TrackRemote) func(pkt rtcp.Packet) {
+	kind := streamKindFromCodecType(track.Kind())
+	ssrc := uint32(track.SSRC())
+	clockRate := track.Codec().ClockRate
+
+	return func(pkt rtcp.Packet) {
+		if sr, ok := pkt.(*rtcp.SenderReport); ok && sr.SSRC == ssrc {
+			h.trackLock.Lock()
+			if h.senderReports == nil {
+				h.senderReports = make(map[types.StreamKind]types.SenderReport)
+			}
+			h.senderReports[kind] = types.SenderReport{
+				RTPTime:   sr.RTPTime,
+				NTPTime:   sr.NTPTime,
+				ClockRate: clockRate,
+			}
+			h.trackLock.Unlock()
+		}
+
+		h.sync.OnRTCP(pkt)
+	}
+}
+
 func (h *whipHandler) AssociateRelay(kind types.StreamKind, token string, w io.WriteCloser) error {
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
@@ -233,6 +516,12 @@ func (h *whipHandler) AssociateRelay(kind types.StreamKind, token string, w io.W
 		return errors.ErrInvalidRelayToken
 	}
 
+	// Associating a kind again replaces its writer, it is not an additional relay
+	if maxRelays := h.params.WHIP.MaxRelaysPerSession; maxRelays > 0 && w != nil && !h.relays[kind] && len(h.relays) >= maxRelays {
+		h.logger.Warnw("rejecting relay association, limit reached", nil, "kind", kind, "relays", len(h.relays), "maxRelays", maxRelays)
+		return errors.ErrTooManyRelays
+	}
+
 	t, ok := h.trackHandlers[WhipTrackDescription{Kind: kind, Quality: livekit.VideoQuality_HIGH}]
 	if !ok {
 		h.logger.Errorw("track handler not found", nil)
@@ -245,7 +534,9 @@ func (h *whipHandler) AssociateRelay(kind types.StreamKind, token string, w io.W
 		return errors.ErrIngressNotFound
 	}
 
+	// The writer is set even if flushing the preroll buffer to it fails
 	err := th.SetWriter(w)
+	h.setRelayedLocked(kind, w != nil)
 	if err != nil {
 		return err
 	}
@@ -253,6 +544,73 @@ func (h *whipHandler) AssociateRelay(kind types.StreamKind, token string, w io.W
 	return nil
 }
 
+// setRelayedLocked records whether kind has a relay writer. trackLock must be held
+func (h *whipHandler) setRelayedLocked(kind types.StreamKind, relayed bool) {
+	if !relayed {
+		delete(h.relays, kind)
+		return
+	}
+
+	if h.relays == nil {
+		h.relays = make(map[types.StreamKind]bool)
+	}
+	h.relays[kind] = true
+}
+
+// SessionDescription is a snapshot of a session, for operational debugging
+type SessionDescription struct {
+	ResourceID    string                      `json:"resource_id"`
+	App           string                      `json:"app"`
+	StreamKey     string                      `json:"stream_key"`
+	State         string                      `json:"state"` // connecting or connected
+	UptimeSeconds float64                     `json:"uptime_seconds"`
+	MimeTypes     map[types.StreamKind]string `json:"mime_types"`
+	Relays        int                         `json:"relays"`
+}
+
+// Describe returns a snapshot of the session. The uptime is counted from the reception of the offer
+func (h *whipHandler) Describe() SessionDescription {
+	d := SessionDescription{
+		ResourceID: h.resourceId,
+		App:        h.app,
+		StreamKey:  h.streamKey,
+		State:      "connecting",
+		MimeTypes:  make(map[types.StreamKind]string),
+	}
+	if h.IsConnected() {
+		d.State = "connected"
+	}
+	if !h.requestReceivedAt.IsZero() {
+		d.UptimeSeconds = time.Since(h.requestReceivedAt).Seconds()
+	}
+
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	for _, track := range h.tracks {
+		d.MimeTypes[streamKindFromCodecType(track.Kind())] = track.Codec().MimeType
+	}
+	d.Relays = len(h.relays)
+
+	return d
+}
+
+// RelayCount returns the number of relays associated with the session
+func (h *whipHandler) RelayCount() int {
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	return len(h.relays)
+}
+
+// IsRelayed returns whether a relay is associated with the track of kind
+func (h *whipHandler) IsRelayed(kind types.StreamKind) bool {
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	return h.relays[kind]
+}
+
 func (h *whipHandler) DissociateRelay(kind types.StreamKind) {
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
@@ -269,18 +627,21 @@ func (h *whipHandler) DissociateRelay(kind types.StreamKind) {
 	}
 
 	err := th.SetWriter(nil)
+	h.setRelayedLocked(kind, false)
 	if err != nil {
 		return
 	}
 }
 
-func (h *whipHandler) updateSettings() {
-	se := &h.rtcConfig.SettingEngine
-
+func updateSettingEngine(se *webrtc.SettingEngine, conf *config.WHIPConfig) {
 	// Change elliptic curve to improve connectivity
 	// https://github.com/pion/dtls/pull/474
 	se.SetDTLSEllipticCurves(elliptic.X25519, elliptic.P384, elliptic.P256)
 
+	if len(conf.SRTPProtectionProfiles) > 0 {
+		se.SetSRTPProtectionProfiles(getSRTPProtectionProfiles(conf.SRTPProtectionProfiles)...)
+	}
+
 	//
 	// Disable SRTP replay protection (https://datatracker.ietf.org/doc/html/rfc3711#page-15).
 	// Needed due to lack of RTX stream support in Pion.
@@ -303,9 +664,30 @@ func (h *whipHandler) updateSettings() {
 	se.SetDTLSRetransmissionInterval(dtlsRetransmissionInterval)
 }
 
-func (h *whipHandler) createPeerConnection(api *webrtc.API) (*webrtc.PeerConnection, error) {
+func newPeerConnection(rtcConfig *rtcconfig.WebRTCConfig, conf *config.WHIPConfig, transcoding bool) (*webrtc.PeerConnection, error) {
+	m, err := newMediaEngine(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP Pipeline.
+	// This provides NACKs, RTCP Reports and other features. If you use `webrtc.NewPeerConnection`
+	// this is enabled by default. If you are manually managing You MUST create a InterceptorRegistry
+	// for each PeerConnection.
+	i := &interceptor.Registry{}
+
+	if transcoding {
+		// Use the default set of Interceptors
+		if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the API object with the MediaEngine
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(rtcConfig.SettingEngine), webrtc.WithInterceptorRegistry(i))
+
 	// Create a new RTCPeerConnection
-	pc, err := api.NewPeerConnection(h.rtcConfig.Configuration)
+	pc, err := api.NewPeerConnection(rtcConfig.Configuration)
 	if err != nil {
 		return nil, err
 	}
@@ -315,15 +697,76 @@ func (h *whipHandler) createPeerConnection(api *webrtc.API) (*webrtc.PeerConnect
 		if _, err := pc.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
 			Direction: webrtc.RTPTransceiverDirectionRecvonly,
 		}); err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+
+	return pc, nil
+}
+
+func (h *whipHandler) createPeerConnection(allowPooled bool) (*webrtc.PeerConnection, error) {
+	var pc *webrtc.PeerConnection
+	if allowPooled && h.params.WHIP.DebugKeyLogFile == "" {
+		// Pooled peer connections do not log their keys
+		pc = h.pcPool.Get(*h.params.EnableTranscoding)
+	}
+	if pc == nil {
+		rtcConfig := h.rtcConfig
+		if h.params.WHIP.DebugKeyLogFile != "" {
+			h.logger.Warnw("DTLS key logging enabled, session media can be decrypted from captures", nil, "keyLogFile", h.params.WHIP.DebugKeyLogFile)
+
+			rtcConfCopy := *h.rtcConfig
+			rtcConfCopy.SettingEngine.SetDTLSKeyLogWriter(newKeyLogWriter(h.params.WHIP.DebugKeyLogFile, h.params.State.ResourceId))
+			rtcConfig = &rtcConfCopy
+		}
+
+		var err error
+		pc, err = newPeerConnection(rtcConfig, &h.params.WHIP, *h.params.EnableTranscoding)
+		if err != nil {
 			return nil, err
 		}
+	} else {
+		h.logger.Debugw("using pooled peer connection")
 	}
 
 	pc.OnTrack(h.addTrack)
 
+	// All media is bundled on the same ICE transport
+	pc.SCTP().Transport().ICETransport().OnSelectedCandidatePairChange(h.onSelectedCandidatePairChange)
+
+	if len(h.params.WHIP.SRTPProtectionProfiles) > 0 {
+		// The profiles are negotiated in the DTLS handshake, clients supporting none of the allowed ones fail it
+		pc.SCTP().Transport().OnStateChange(func(state webrtc.DTLSTransportState) {
+			if state == webrtc.DTLSTransportStateFailed {
+				h.logger.Warnw("DTLS handshake failed, the client may not support the allowed SRTP protection profiles", nil, "allowedProfiles", h.params.WHIP.SRTPProtectionProfiles)
+				h.dtlsFailedOnce.Do(func() {
+					close(h.dtlsFailed)
+				})
+			}
+		})
+	}
+
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateConnected {
+			h.iceTransportOnce.Do(func() {
+				close(h.iceTransportUp)
+			})
+		}
+	})
+
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		h.logger.Infow("Peer Connection State changed", "state", state.String())
 
+		if state == webrtc.PeerConnectionStateConnected {
+			h.iceConnectedOnce.Do(func() {
+				if h.bandwidthRamp != nil {
+					h.bandwidthRamp.Start(time.Now())
+				}
+				close(h.iceConnected)
+			})
+		}
+
 		if state >= webrtc.PeerConnectionStateFailed {
 			h.closeOnce.Do(func() {
 				h.sync.End()
@@ -337,10 +780,10 @@ func (h *whipHandler) createPeerConnection(api *webrtc.API) (*webrtc.PeerConnect
 }
 
 func (h *whipHandler) getSDPAnswer(ctx context.Context, offer *webrtc.SessionDescription) (string, error) {
-	// Set the remote SessionDescription
+	// Set the remote SessionDescription. Failures come from offers pion cannot negotiate
 	err := h.pc.SetRemoteDescription(*offer)
 	if err != nil {
-		return "", err
+		return "", errors.ErrInvalidWHIPOfferReason(err.Error())
 	}
 
 	// Create an answer
@@ -352,6 +795,7 @@ func (h *whipHandler) getSDPAnswer(ctx context.Context, offer *webrtc.SessionDes
 	h.logger.Infow("created answer", "answer", answer.SDP)
 	// Create channel that is blocked until ICE Gathering is complete
 	gatherComplete := webrtc.GatheringCompletePromise(h.pc)
+	gatheringStart := time.Now()
 
 	// Sets the LocalDescription, and starts our UDP listeners
 	if err = h.pc.SetLocalDescription(answer); err != nil {
@@ -360,30 +804,105 @@ func (h *whipHandler) getSDPAnswer(ctx context.Context, offer *webrtc.SessionDes
 
 	select {
 	case <-gatherComplete:
-		// success
+		// Slow gathering is usually caused by TURN allocations
+		h.iceGathering = time.Since(gatheringStart)
+		h.logger.Debugw("ICE gathering complete", "duration", h.iceGathering)
 	case <-ctx.Done():
-		return "", psrpc.NewErrorf(psrpc.DeadlineExceeded, "timed out while waiting for ICE candidate gathering")
+		return "", errors.ErrICEGatheringTimeout
 	}
 
 	parsedAnswer, err := h.pc.LocalDescription().Unmarshal()
 	if err != nil {
 		return "", err
 	}
+
+	if bundle, media := getBundleLayout(parsedAnswer); bundle != nil {
+		h.logger.Debugw("answer BUNDLE group", "bundle", bundle, "media", media)
+	} else {
+		h.logger.Infow("answer does not use BUNDLE", "media", media)
+	}
+
+	h.hostCandidateIPs = getHostCandidateIPs(parsedAnswer)
+	if len(h.hostCandidateIPs) == 0 && h.params.RTCConfig.ICEPortRangeStart != 0 {
+		// Pion skips the addresses it could not listen on, so an exhausted port range results in no host candidate
+		h.logger.Warnw("no host candidate gathered, ICE port range exhausted", nil)
+		return "", errors.ErrNoAvailablePorts
+	}
 	for _, m := range parsedAnswer.MediaDescriptions {
 		// Pion puts a media description with fmt = 0 and no attributes for unsupported codecs
 		if len(m.Attributes) == 0 {
 			h.logger.Infow("unsupported codec in SDP offer")
 			return "", errors.ErrUnsupportedDecodeFormat
 		}
+
+		if len(m.MediaName.Formats) == 0 {
+			continue
+		}
+		// The first format of the answer is the codec the client sends
+		if pt, err := strconv.ParseUint(m.MediaName.Formats[0], 10, 8); err == nil {
+			if codec, err := parsedAnswer.GetCodecForPayloadType(uint8(pt)); err == nil {
+				h.logger.Infow("selected codec", "kind", m.MediaName.Media, "codec", codec.Name, "payloadType", codec.PayloadType)
+			}
+		}
 	}
 
-	sdpAnswer := h.pc.LocalDescription().SDP
+	sdpAnswer, err := h.answerBuilder.BuildAnswer(&AnswerRequest{
+		Offer:       offer.SDP,
+		LocalAnswer: h.pc.LocalDescription().SDP,
+		Params:      h.params,
+	})
+	if err != nil {
+		return "", err
+	}
 	h.logger.Infow("created SDP answer from Local Description", "answer", sdpAnswer)
-	sdpAnswer = addICEToAnswer(sdpAnswer)
 
 	return sdpAnswer, nil
 }
 
+func (h *whipHandler) onSelectedCandidatePairChange(pair *webrtc.ICECandidatePair) {
+	if pair == nil {
+		return
+	}
+
+	turnServer := getTURNServer(pair)
+	h.logger.Infow("selected ICE candidate pair", "pair", pair.String(), "turnServer", turnServer)
+
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	h.turnServer = turnServer
+	if h.stats != nil {
+		h.stats.SetTURNServer(turnServer)
+	}
+}
+
+// getFirstKeyframeCallback returns the callback reporting the time to the first keyframe of the session, nil for audio tracks
+func (h *whipHandler) getFirstKeyframeCallback(track *webrtc.TrackRemote) func(bytesBefore, packetsBefore uint64) {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil
+	}
+
+	mimeType := track.Codec().MimeType
+	return func(bytesBefore, packetsBefore uint64) {
+		h.firstKeyframeOnce.Do(func() {
+			h.trackLock.Lock()
+			h.bytesBeforeFirstFrame = bytesBefore
+			h.packetsBeforeFirstFrame = packetsBefore
+			h.trackLock.Unlock()
+
+			if h.requestReceivedAt.IsZero() {
+				return
+			}
+
+			d := time.Since(h.requestReceivedAt)
+			h.logger.Infow("first keyframe received", "timeToFirstFrame", d, "codec", mimeType, "bytesBefore", bytesBefore, "packetsBefore", packetsBefore)
+			if h.onTimeToFirstFrame != nil {
+				h.onTimeToFirstFrame(mimeType, d)
+			}
+		})
+	}
+}
+
 func (h *whipHandler) getTrackQuality(track *webrtc.TrackRemote) livekit.VideoQuality {
 	trackQuality := livekit.VideoQuality_HIGH
 	if track.RID() != "" {
@@ -408,17 +927,37 @@ func (h *whipHandler) addTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPRe
 
 	logger.Infow("track has started", "type", track.PayloadType(), "codec", track.Codec().MimeType)
 
+	if isFECMimeType(track.Codec().MimeType) {
+		logger.Infow("draining FEC repair stream")
+		go drainFECTrack(track)
+		return
+	}
+
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
+	for _, t := range h.tracks {
+		if t.SSRC() == track.SSRC() {
+			logger.Warnw("SSRC collision with another track", nil, "ssrc", track.SSRC(), "otherTrackID", t.ID(), "otherKind", streamKindFromCodecType(t.Kind()))
+		}
+	}
 	h.tracks = append(h.tracks, track)
 
+	var extensions []types.HeaderExtension
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		extensions = append(extensions, types.HeaderExtension{ID: e.ID, URI: e.URI})
+	}
+	if h.headerExtensions == nil {
+		h.headerExtensions = make(map[types.StreamKind][]types.HeaderExtension)
+	}
+	h.headerExtensions[kind] = extensions
+
 	trackQuality := h.getTrackQuality(track)
 
 	var th WhipTrackHandler
 	var err error
 	if !*h.params.EnableTranscoding {
 		h.logger.Infow("creating SDK whip track handler without transcoding", "trackID", track.ID(), "kind", kind, "quality", trackQuality)
-		th, err = NewSDKWhipTrackHandler(logger, track, trackQuality, receiver, h.writePLI, h.writeRTCPUpstream)
+		th, err = NewSDKWhipTrackHandler(logger, track, trackQuality, receiver, h.writePLI, h.writeRTCPUpstream, h.getFirstKeyframeCallback(track))
 		if err != nil {
 			logger.Warnw("failed creating SDK whip track handler", err)
 			return
@@ -426,7 +965,12 @@ func (h *whipHandler) addTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPRe
 	} else {
 		sync := h.sync.AddTrack(track, whipIdentity)
 
-		th, err = NewRelayWhipTrackHandler(logger, track, trackQuality, sync, receiver, h.writePLI, h.sync.OnRTCP)
+		var monitor *keyframeMonitor
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			monitor = newKeyframeMonitor(h.params.WHIP.MinKeyframeInterval, h.params.WHIP.MaxKeyframeInterval)
+		}
+
+		th, err = NewRelayWhipTrackHandler(logger, track, trackQuality, sync, receiver, h.writePLI, h.getRTCPCallback(track), h.params.WHIP.ReplayKeyframeOnRelay, h.targetLatency, h.getFirstKeyframeCallback(track), monitor)
 		if err != nil {
 			logger.Warnw("failed creating relay whip track handler", err)
 			return
@@ -455,7 +999,7 @@ func (h *whipHandler) getSDKTrackMediaSink(sdkOutput *lksdk_output.LKSDKOutput,
 			layers = []livekit.VideoQuality{livekit.VideoQuality_HIGH, livekit.VideoQuality_MEDIUM}
 		}
 
-		h.trackSDKMediaSink[kind] = NewSDKMediaSink(h.logger, h.params, sdkOutput, track.Codec(), streamKindFromCodecType(track.Kind()), layers)
+		h.trackSDKMediaSink[kind] = NewSDKMediaSink(h.logger, h.params, sdkOutput, track.Codec(), kind, h.trackLabels[kind], layers)
 	}
 
 	sdkTrack := h.trackSDKMediaSink[kind].GetTrack(trackQuality)
@@ -480,12 +1024,37 @@ func (h *whipHandler) writePLI(ssrc webrtc.SSRC) {
 }
 
 func (h *whipHandler) writeRTCPUpstream(pkt rtcp.Packet) {
+	if remb, ok := pkt.(*rtcp.ReceiverEstimatedMaximumBitrate); ok && h.bandwidthRamp != nil {
+		pkt = h.limitEstimate(remb)
+	}
+
 	err := h.pc.WriteRTCP([]rtcp.Packet{pkt})
 	if err != nil {
 		h.logger.Warnw("failed writing RTCP packet upstream", err)
 	}
 }
 
+// limitEstimate applies the bandwidth ramp-up to a receiver estimate forwarded to the publisher
+func (h *whipHandler) limitEstimate(remb *rtcp.ReceiverEstimatedMaximumBitrate) *rtcp.ReceiverEstimatedMaximumBitrate {
+	estimate := uint64(remb.Bitrate)
+	limited := h.bandwidthRamp.Apply(estimate, time.Now())
+	if limited == estimate {
+		return remb
+	}
+
+	h.trackLock.Lock()
+	st := h.stats
+	h.trackLock.Unlock()
+	if st != nil {
+		st.SetRampClampedEstimates(h.bandwidthRamp.Clamped())
+	}
+	h.logger.Debugw("limiting receiver estimate during bandwidth ramp-up", "estimate", estimate, "limit", limited)
+
+	clamped := *remb
+	clamped.Bitrate = float32(limited)
+	return &clamped
+}
+
 func (h *whipHandler) runSession(ctx context.Context) error {
 	var err error
 	var sdkOutput *lksdk_output.LKSDKOutput
@@ -579,15 +1148,46 @@ func (h *whipHandler) closeTrackHandlers() {
 	}
 }
 
+func (h *whipHandler) checkTransportSecurity(offer *webrtc.SessionDescription) error {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return errors.ErrInvalidWHIPOfferReason(err.Error())
+	}
+
+	insecure, noFingerprint := getInsecureMedia(parsed)
+	if len(insecure) > 0 || noFingerprint {
+		h.logger.Infow("rejecting offer not using DTLS-SRTP", "insecureMedia", insecure, "noFingerprint", noFingerprint)
+		return errors.ErrInsecureTransport
+	}
+
+	return nil
+}
+
 func (h *whipHandler) validateOfferAndGetExpectedTrackCount(offer *webrtc.SessionDescription) (int, error) {
 	parsed, err := offer.Unmarshal()
 	if err != nil {
+		return 0, errors.ErrInvalidWHIPOfferReason(err.Error())
+	}
+
+	// Pion only notices missing credentials when applying the remote description
+	if err = validateICECredentials(parsed); err != nil {
 		return 0, err
 	}
 
 	audioCount, videoCount := 0, 0
 
 	for _, m := range parsed.MediaDescriptions {
+		// Ingress never sends media, so sections the client does not send on are not tracks to wait for
+		if !isSendingMedia(parsed, m) {
+			mid, _ := m.Attribute(sdp.AttrKeyMID)
+			if h.params.WHIP.RecvOnlyMedia == config.WHIPRecvOnlyMediaReject {
+				h.logger.Infow("rejecting offer with media section not sending media", "kind", m.MediaName.Media, "mid", mid)
+				return 0, errors.ErrInvalidWHIPOfferReason(fmt.Sprintf("%s media section with mid %s does not send media", m.MediaName.Media, mid))
+			}
+			h.logger.Infow("ignoring media section not sending media", "kind", m.MediaName.Media, "mid", mid)
+			continue
+		}
+
 		if types.StreamKind(m.MediaName.Media) == types.Audio {
 			// Duplicate track for a given type. Forbidden by the RFC
 			if audioCount != 0 {
@@ -614,6 +1214,11 @@ func (h *whipHandler) validateOfferAndGetExpectedTrackCount(offer *webrtc.Sessio
 						return 0, errors.ErrInvalidSimulcast
 					}
 
+					if allow := h.params.WHIP.AllowSimulcast; allow != nil && !*allow {
+						h.logger.Infow("rejecting simulcast offer, simulcast not allowed", "layers", layersSplit)
+						return 0, errors.ErrSimulcastNotAllowed
+					}
+
 					h.simulcastLayers = layersSplit
 					videoCount += len(h.simulcastLayers)
 				}
@@ -626,9 +1231,59 @@ func (h *whipHandler) validateOfferAndGetExpectedTrackCount(offer *webrtc.Sessio
 		}
 	}
 
+	if audioCount+videoCount == 0 {
+		return 0, errors.ErrInvalidWHIPOfferReason("no media section sending audio or video")
+	}
+
 	return audioCount + videoCount, nil
 }
 
+// checkSSRCCollisions logs the SSRCs announced by more than one media section of the offer,
+// and rejects the offer if configured to
+func (h *whipHandler) checkSSRCCollisions(offer *webrtc.SessionDescription) error {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return err
+	}
+
+	collisions := getSSRCCollisions(parsed)
+	if len(collisions) == 0 {
+		return nil
+	}
+	h.ssrcCollisions = len(collisions)
+
+	if h.params.WHIP.RejectSSRCCollisions {
+		h.logger.Infow("rejecting offer with SSRC collisions", "ssrcs", collisions)
+		return errors.ErrSSRCCollision
+	}
+	h.logger.Warnw("SSRC collisions in offer", nil, "ssrcs", collisions)
+
+	return nil
+}
+
+func (h *whipHandler) validateAudioFormat(offer *webrtc.SessionDescription) error {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return err
+	}
+
+	f, ok := getOfferedAudioFormat(parsed)
+	if !ok {
+		return nil
+	}
+
+	h.logger.Infow("offered audio format", "codec", f.mimeType, "sampleRate", f.sampleRate, "channels", f.channels)
+
+	if len(h.params.WHIP.AllowedAudioSampleRates) > 0 && !slices.Contains(h.params.WHIP.AllowedAudioSampleRates, f.sampleRate) {
+		return errors.ErrUnsupportedAudioFormat
+	}
+	if len(h.params.WHIP.AllowedAudioChannels) > 0 && !slices.Contains(h.params.WHIP.AllowedAudioChannels, f.channels) {
+		return errors.ErrUnsupportedAudioFormat
+	}
+
+	return nil
+}
+
 func streamKindFromCodecType(typ webrtc.RTPCodecType) types.StreamKind {
 	switch typ {
 	case webrtc.RTPCodecTypeAudio:
@@ -640,7 +1295,7 @@ func streamKindFromCodecType(typ webrtc.RTPCodecType) types.StreamKind {
 	}
 }
 
-func newMediaEngine() (*webrtc.MediaEngine, error) {
+func newMediaEngine(conf *config.WHIPConfig) (*webrtc.MediaEngine, error) {
 	m := &webrtc.MediaEngine{}
 
 	for _, codec := range []webrtc.RTPCodecParameters{
@@ -658,7 +1313,11 @@ func newMediaEngine() (*webrtc.MediaEngine, error) {
 		}
 	}
 
-	videoRTCPFeedback := []webrtc.RTCPFeedback{{Type: "goog-remb", Parameter: ""}, {Type: "ccm", Parameter: "fir"}, {Type: "nack", Parameter: ""}, {Type: "nack", Parameter: "pli"}}
+	var videoRTCPFeedback []webrtc.RTCPFeedback
+	for _, fb := range conf.RTCPFeedback {
+		typ, param, _ := strings.Cut(fb, " ")
+		videoRTCPFeedback = append(videoRTCPFeedback, webrtc.RTCPFeedback{Type: typ, Parameter: param})
+	}
 
 	for _, codec := range []webrtc.RTPCodecParameters{
 		{
@@ -675,6 +1334,26 @@ func newMediaEngine() (*webrtc.MediaEngine, error) {
 		}
 	}
 
+	// FEC codecs are only part of the answer if the client offers them
+	var fecCodecs []webrtc.RTPCodecParameters
+	if conf.FEC.ULPFEC {
+		fecCodecs = append(fecCodecs, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeULPFEC, ClockRate: 90000},
+			PayloadType:        ulpfecPayloadType,
+		})
+	}
+	if conf.FEC.FlexFEC {
+		fecCodecs = append(fecCodecs, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeFlexFEC, ClockRate: 90000, SDPFmtpLine: "repair-window=10000000"},
+			PayloadType:        flexfecPayloadType,
+		})
+	}
+	for _, codec := range fecCodecs {
+		if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.SDESMidURI}, webrtc.RTPCodecTypeVideo); err != nil {
 		return nil, err
 	}
@@ -709,6 +1388,8 @@ func (h *whipHandler) DeleteWHIPResource(ctx context.Context, req *rpc.DeleteWHI
 	_, span := tracer.Start(ctx, "whipHandler.DeleteWHIPResource")
 	defer span.End()
 
+	h.logger.Infow("deleting WHIP resource", "deleteRequestID", getIncomingRequestID(ctx))
+
 	// only test for stream key correctness if it is part of the request for backward compatibility
 	if req.StreamKey != "" && h.params.StreamKey != req.StreamKey {
 		h.logger.Infow("received delete request with wrong stream key", "streamKey", req.StreamKey)
@@ -719,6 +1400,25 @@ func (h *whipHandler) DeleteWHIPResource(ctx context.Context, req *rpc.DeleteWHI
 	return &google_protobuf2.Empty{}, nil
 }
 
+// IsConnected returns true once ICE and DTLS connected. It stays true through ICE restarts
+func (h *whipHandler) IsConnected() bool {
+	select {
+	case <-h.iceConnected:
+		return true
+	default:
+		return false
+	}
+}
+
+// ETag identifies the current ICE session of the resource, it changes with every ICE restart
+func (h *whipHandler) ETag() string {
+	return sessionETag(h.resourceId, h.generation.Load())
+}
+
+func sessionETag(resourceId string, generation uint32) string {
+	return fmt.Sprintf(`"%08x"`, crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s:%d", resourceId, generation))))
+}
+
 func (h *whipHandler) ICERestartWHIPResource(ctx context.Context, req *rpc.ICERestartWHIPResourceRequest) (*rpc.ICERestartWHIPResourceResponse, error) {
 	_, span := tracer.Start(ctx, "whipHandler.ICERestartWHIPResource")
 	defer span.End()
@@ -726,6 +1426,16 @@ func (h *whipHandler) ICERestartWHIPResource(ctx context.Context, req *rpc.ICERe
 	if h.pc == nil {
 		return nil, errors.ErrIngressNotFound
 	}
+	h.logger.Debugw("received WHIP ICE restart request", "restartRequestID", getIncomingRequestID(ctx), "trickle", len(req.Candidates) > 0)
+
+	// Trickle-ICE requests carry candidates for the current ICE session, and do not renegotiate
+	if len(req.Candidates) > 0 {
+		return h.addRemoteCandidates(req)
+	}
+
+	if err := h.checkRenegotiation(); err != nil {
+		return nil, err
+	}
 
 	remoteDescription := h.pc.CurrentRemoteDescription()
 	if remoteDescription == nil {
@@ -759,7 +1469,9 @@ func (h *whipHandler) ICERestartWHIPResource(ctx context.Context, req *rpc.ICERe
 	//
 	// https://www.ietf.org/archive/id/draft-ietf-wish-whip-14.html#name-ice-restarts
 	var trickleIceSdpfrag strings.Builder
-	scanner := bufio.NewScanner(strings.NewReader(h.pc.LocalDescription().SDP))
+	localSDP := filterCandidateFamilies(h.pc.LocalDescription().SDP, h.params.WHIP.IPFamilies)
+	localSDP = applyPreferredInterfaces(h.logger, h.params.WHIP.PreferredInterfaces, localSDP)
+	scanner := bufio.NewScanner(strings.NewReader(applyCandidateLimit(h.logger, h.params.WHIP.MaxCandidatesPerType, localSDP)))
 	for scanner.Scan() {
 		l := scanner.Text()
 		if strings.HasPrefix(l, "a=") && !strings.HasPrefix(l, "a=ice-pwd") && !strings.HasPrefix(l, "a=ice-ufrag") && !strings.HasPrefix(l, "a=candidate") {
@@ -769,9 +1481,61 @@ func (h *whipHandler) ICERestartWHIPResource(ctx context.Context, req *rpc.ICERe
 		trickleIceSdpfrag.WriteString(l + "\n")
 	}
 
+	h.generation.Add(1)
+
 	return &rpc.ICERestartWHIPResourceResponse{TrickleIceSdpfrag: trickleIceSdpfrag.String()}, nil
 }
 
+// addRemoteCandidates applies the candidates of a Trickle-ICE request to the peer connection
+func (h *whipHandler) addRemoteCandidates(req *rpc.ICERestartWHIPResourceRequest) (*rpc.ICERestartWHIPResourceResponse, error) {
+	remoteDescription := h.pc.CurrentRemoteDescription()
+	if remoteDescription == nil {
+		return nil, errors.ErrIngressNotFound
+	}
+
+	// Candidates gathered before an ICE restart must not be applied to the restarted ICE session
+	if req.UserFragment != "" {
+		parsed, err := remoteDescription.Unmarshal()
+		if err != nil {
+			return nil, errors.ErrIngressNotFound
+		}
+		if ufrag := getICEUfrag(parsed); req.UserFragment != ufrag {
+			h.logger.Infow("rejecting Trickle-ICE candidates for another ICE session", "ufrag", req.UserFragment, "currentUfrag", ufrag)
+			return nil, errors.ErrInvalidWHIPRestartRequest
+		}
+	}
+
+	for _, c := range req.Candidates {
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal([]byte(c), &candidate); err != nil {
+			h.logger.Infow("failed decoding Trickle-ICE candidate", "error", err, "candidate", c)
+			return nil, errors.ErrInvalidWHIPRestartRequest
+		}
+		if err := h.pc.AddICECandidate(candidate); err != nil {
+			h.logger.Infow("failed adding Trickle-ICE candidate", "error", err, "candidate", candidate.Candidate)
+			return nil, errors.ErrInvalidWHIPRestartRequest
+		}
+	}
+	h.logger.Infow("added Trickle-ICE candidates", "count", len(req.Candidates))
+
+	return &rpc.ICERestartWHIPResourceResponse{}, nil
+}
+
+// checkRenegotiation returns an error if the session renegotiated too often, and should be called
+// before any renegotiation of the session
+func (h *whipHandler) checkRenegotiation() error {
+	count, ok := h.renegotiations.Allow(time.Now())
+	if !ok {
+		h.logger.Warnw("rejecting renegotiation, limit reached", nil, "recentCount", count, "maxPerMinute", h.params.WHIP.MaxRenegotiationsPerMinute, "maxTotal", h.params.WHIP.MaxRenegotiations)
+		return errors.ErrTooManyRenegotiations
+	}
+	if count > 1 {
+		h.logger.Warnw("repeated renegotiation", nil, "recentCount", count)
+	}
+
+	return nil
+}
+
 func addICEToAnswer(sdp string) string {
 	iceString := "a=ice-options:trickle\r\n"
 	if strings.Contains(sdp, iceString) {